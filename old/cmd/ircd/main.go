@@ -17,8 +17,9 @@ var (
 	silent = flag.Bool("silent", false, "Don't write logs to the console")
 
 	// Other execution modes
-	genconf   = flag.Bool("genconf", false, "Genereate a configuration file and exit")
-	checkconf = flag.Bool("checkconf", false, "Check the configuration file and exit")
+	genconf     = flag.Bool("genconf", false, "Genereate a configuration file and exit")
+	checkconf   = flag.Bool("checkconf", false, "Check the configuration file and exit")
+	convertconf = flag.String("convertconf", "", "Convert the XML configuration file to this TOML (.toml) or YAML (.yaml) file and exit")
 )
 
 func main() {
@@ -37,6 +38,14 @@ func main() {
 		os.Exit(0)
 	}
 
+	if len(*convertconf) > 0 {
+		if err := core.ConvertConfigFile(*config, *convertconf); err != nil {
+			log.Error.Fatalf("Converting %q to %q: %s", *config, *convertconf, err)
+		}
+		log.Info.Printf("Configuration file %q converted to %q", *config, *convertconf)
+		os.Exit(0)
+	}
+
 	if err := log.SetFile(*logfile); err != nil {
 		log.Error.Fatalf("Opening logfile: %s", err)
 	}
@@ -44,6 +53,16 @@ func main() {
 		log.ShowInConsole()
 	}
 
+	// Report every mistake in the file (with its position) before loading
+	// it; REHASH refuses the same files
+	errs := core.ValidateConfigFile(*config)
+	for _, err := range errs {
+		log.Error.Printf("%s: %s", *config, err)
+	}
+	if len(errs) > 0 {
+		log.Error.Fatalf("Invalid configuration")
+	}
+
 	if err := core.LoadConfigFile(*config); err != nil {
 		log.Error.Fatalf("Loading config: %s", err)
 	}
@@ -53,6 +72,7 @@ func main() {
 			log.Error.Fatalf("Invalid configuration")
 		}
 		log.Info.Printf("Configuration successfully checked.")
+		os.Exit(0)
 	}
 
 	core.Start()
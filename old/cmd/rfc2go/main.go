@@ -63,7 +63,7 @@ func generate(f io.Writer, rfc parsedRFC) {
 }
 
 var (
-	extract = regexp.MustCompile(`([0-9][0-9][0-9])[ \t]+([A-Z]+_[\-_A-Z]+)[ \t\r\n]+":?(([^"]|"[^"\r\n]*")+)"\n`)
+	extract = regexp.MustCompile(`([0-9][0-9][0-9])[ \t]+([A-Z]+_[\-_A-Z0-9]+)[ \t\r\n]+":?(([^"]|"[^"\r\n]*")+)"\n`)
 	joiner  = regexp.MustCompile(`\n[ \t\n]+`)
 )
 
@@ -92,6 +92,7 @@ func parse() parsedRFC {
 		for _, match := range matches {
 			numeric, name, text := string(match[1]), string(match[2]), string(match[3])
 			text = joiner.ReplaceAllString(text, " ")
+			_, seen := rfc.name2text[name]
 
 			if _, overwrite := rfc.numeric2name[numeric]; overwrite {
 				o, n := rfc.numeric2name[numeric], name
@@ -99,11 +100,14 @@ func parse() parsedRFC {
 
 				// Remove the old text mapping
 				delete(rfc.name2text, o)
+			} else {
+				rfc.numerics = append(rfc.numerics, numeric)
 			}
 
-			rfc.numerics = append(rfc.numerics, numeric)
 			rfc.numeric2name[numeric] = name
-			rfc.names = append(rfc.names, name)
+			if !seen {
+				rfc.names = append(rfc.names, name)
+			}
 			rfc.name2text[name] = text
 		}
 	}
@@ -1,3 +1,9 @@
 module github.com/kylelemons/ircd-blight/old
 
 go 1.16
+
+require (
+	github.com/BurntSushi/toml v1.2.1
+	golang.org/x/crypto v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,205 @@
+// Package account stores the accounts that users can log in to with SASL.
+//
+// Accounts are loaded from a file with one account per line:
+//
+//	<account> <password hash> [<certificate fingerprint>...]
+//
+// Password hashes are bcrypt ($2a$, $2b$, $2y$) or argon2 ($argon2id$,
+// $argon2i$) in their usual encoded forms; a hash of * disables password
+// logins for the account.  Certificate fingerprints are the hex SHA-256 of
+// the client certificate.  Blank lines and lines starting with # are ignored.
+package account
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+)
+
+var (
+	accountMutex = new(sync.RWMutex)
+
+	// accounts[lowname] = account
+	accounts = make(Store)
+)
+
+// An Account is a registered account name and its credentials.
+type Account struct {
+	Name   string
+	Hash   string
+	CertFP []string
+}
+
+// A Store is a set of accounts, indexed by their lowercase names, which can
+// be read before it is put in use.
+type Store map[string]*Account
+
+// Load replaces the account store with the accounts in the given file.
+func Load(filename string) error {
+	store, err := Read(filename)
+	if err != nil {
+		return err
+	}
+	Use(store)
+	return nil
+}
+
+// LoadFrom replaces the account store with the accounts read from r.  If
+// there is an error, the store is not changed.
+func LoadFrom(r io.Reader) error {
+	store, err := ReadFrom(r)
+	if err != nil {
+		return err
+	}
+	Use(store)
+	return nil
+}
+
+// Read reads the accounts in the given file without using them.
+func Read(filename string) (Store, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadFrom(file)
+}
+
+// ReadFrom reads accounts from r without using them.
+func ReadFrom(r io.Reader) (Store, error) {
+	loaded := make(Store)
+
+	lines := bufio.NewScanner(r)
+	for lineno := 1; lines.Scan(); lineno++ {
+		fields := strings.Fields(lines.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: missing password hash for %q", lineno, fields[0])
+		}
+		acct := &Account{
+			Name:   fields[0],
+			Hash:   fields[1],
+			CertFP: fields[2:],
+		}
+		for i, fp := range acct.CertFP {
+			acct.CertFP[i] = normalizeFP(fp)
+		}
+		loaded[parser.ToLower(acct.Name)] = acct
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// Use replaces the account store with the given accounts.
+func Use(store Store) {
+	accountMutex.Lock()
+	defer accountMutex.Unlock()
+	accounts = store
+}
+
+// CheckPassword returns the name of the account if the password is correct.
+func CheckPassword(name, password string) (account string, ok bool) {
+	accountMutex.RLock()
+	acct, found := accounts[parser.ToLower(name)]
+	accountMutex.RUnlock()
+
+	if !found || !verify(acct.Hash, password) {
+		return "", false
+	}
+	return acct.Name, true
+}
+
+// CheckCertFP returns the name of the account with the given certificate
+// fingerprint.  If name is not empty, only that account is considered.
+func CheckCertFP(fp, name string) (account string, ok bool) {
+	fp = normalizeFP(fp)
+	if len(fp) == 0 {
+		return "", false
+	}
+
+	accountMutex.RLock()
+	defer accountMutex.RUnlock()
+
+	for lowname, acct := range accounts {
+		if len(name) > 0 && lowname != parser.ToLower(name) {
+			continue
+		}
+		for _, accepted := range acct.CertFP {
+			if accepted == fp {
+				return acct.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Fingerprints are compared in lower case without separators.
+func normalizeFP(fp string) string {
+	return strings.ToLower(strings.Replace(fp, ":", "", -1))
+}
+
+// Check a password against an encoded bcrypt or argon2 hash.
+func verify(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$argon2"):
+		return verifyArgon2(hash, password)
+	}
+	return false
+}
+
+// Check a password against a hash of the form
+//
+//	$argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+//
+// where the salt and key are unpadded base64.
+func verifyArgon2(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false
+	}
+
+	var version int
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+
+	var derived []byte
+	switch parts[1] {
+	case "argon2id":
+		derived = argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	case "argon2i":
+		derived = argon2.Key([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare(derived, key) == 1
+}
@@ -0,0 +1,91 @@
+package account
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testStore(t *testing.T) string {
+	bhash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %s", err)
+	}
+	salt := []byte("saltsaltsaltsalt")
+	key := argon2.IDKey([]byte("correct horse"), salt, 1, 64, 1, 32)
+	ahash := fmt.Sprintf("$argon2id$v=%d$m=64,t=1,p=1$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+
+	return strings.Join([]string{
+		"# name hash [certfp...]",
+		"",
+		"Alice " + string(bhash),
+		"bob " + ahash + " AB:CD:EF",
+		"carol * 0123",
+	}, "\n")
+}
+
+var checkPasswordTests = []struct {
+	Name     string
+	Password string
+	Account  string
+	OK       bool
+}{
+	{"alice", "hunter2", "Alice", true},
+	{"ALICE", "hunter2", "Alice", true},
+	{"alice", "hunter3", "", false},
+	{"bob", "correct horse", "bob", true},
+	{"bob", "battery staple", "", false},
+	{"carol", "*", "", false},
+	{"dave", "", "", false},
+}
+
+func TestCheckPassword(t *testing.T) {
+	if err := LoadFrom(strings.NewReader(testStore(t))); err != nil {
+		t.Fatalf("LoadFrom: %s", err)
+	}
+	for _, test := range checkPasswordTests {
+		account, ok := CheckPassword(test.Name, test.Password)
+		if account != test.Account || ok != test.OK {
+			t.Errorf("CheckPassword(%q, %q) = %q, %v; want %q, %v",
+				test.Name, test.Password, account, ok, test.Account, test.OK)
+		}
+	}
+}
+
+var checkCertFPTests = []struct {
+	FP      string
+	Name    string
+	Account string
+	OK      bool
+}{
+	{"abcdef", "", "bob", true},
+	{"AB:CD:EF", "bob", "bob", true},
+	{"abcdef", "carol", "", false},
+	{"0123", "", "carol", true},
+	{"", "", "", false},
+}
+
+func TestCheckCertFP(t *testing.T) {
+	if err := LoadFrom(strings.NewReader(testStore(t))); err != nil {
+		t.Fatalf("LoadFrom: %s", err)
+	}
+	for _, test := range checkCertFPTests {
+		account, ok := CheckCertFP(test.FP, test.Name)
+		if account != test.Account || ok != test.OK {
+			t.Errorf("CheckCertFP(%q, %q) = %q, %v; want %q, %v",
+				test.FP, test.Name, account, ok, test.Account, test.OK)
+		}
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	if err := LoadFrom(strings.NewReader("nohash\n")); err == nil {
+		t.Errorf("LoadFrom(account without hash) succeeded unexpectedly")
+	}
+}
@@ -0,0 +1,392 @@
+// Package ban stores the server bans set by operators:
+//
+//	K-lines ban a user@host mask (the host may also be an IP or CIDR range)
+//	D-lines ban an IP address or CIDR range before the client registers
+//	X-lines ban a realname, given as a glob or as a /regexp/
+//
+// Bans may expire, and are saved to a file so that they survive a restart.
+// The file has one ban per line, with tab-separated fields:
+//
+//	<kind> <mask> <set time> <expiry time> <setter> <reason> <oper reason>
+//
+// Times are in Unix seconds; an expiry time of 0 means the ban is permanent.
+// Blank lines and lines starting with # are ignored.
+package ban
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+)
+
+// The kinds of ban.
+const (
+	KLine = 'K'
+	DLine = 'D'
+	XLine = 'X'
+)
+
+var (
+	banMutex = new(sync.RWMutex)
+
+	// Saves are made one at a time, since they share a temporary file
+	saveMutex = new(sync.Mutex)
+
+	// bans[kind][lowmask] = ban
+	bans = Set{
+		KLine: {},
+		DLine: {},
+		XLine: {},
+	}
+)
+
+// A Ban is a K-, D- or X-line.
+type Ban struct {
+	Kind       byte
+	Mask       string
+	Set        int64  // when the ban was set
+	Expires    int64  // when the ban expires (0 if it is permanent)
+	Setter     string // the oper (or server) that set it
+	Reason     string // the reason shown to banned users
+	OperReason string // the reason shown only to opers
+
+	// The parsed mask (see compile)
+	user   string
+	host   string
+	ipnet  *net.IPNet
+	regexp *regexp.Regexp
+}
+
+// New constructs a ban of the given kind, which expires after the given
+// duration (or never, if it is zero).  An error is returned if the mask is
+// not valid for the kind of ban.
+func New(kind byte, mask string, duration time.Duration, setter, reason, operReason string) (*Ban, error) {
+	now := time.Now()
+	b := &Ban{
+		Kind:       kind,
+		Mask:       mask,
+		Set:        now.Unix(),
+		Setter:     setter,
+		Reason:     reason,
+		OperReason: operReason,
+	}
+	if duration > 0 {
+		b.Expires = now.Add(duration).Unix()
+	}
+	if err := b.compile(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Parse the mask into the fields used to match it.
+func (b *Ban) compile() error {
+	switch b.Kind {
+	case KLine:
+		at := strings.Index(b.Mask, "@")
+		if at <= 0 || at == len(b.Mask)-1 {
+			return fmt.Errorf("invalid K-line mask %q: want user@host", b.Mask)
+		}
+		b.user, b.host = b.Mask[:at], b.Mask[at+1:]
+		if _, ipnet, err := net.ParseCIDR(b.host); err == nil {
+			b.ipnet = ipnet
+		}
+	case DLine:
+		ipnet, err := parseIPNet(b.Mask)
+		if err != nil {
+			return fmt.Errorf("invalid D-line mask %q: want an IP address or CIDR range", b.Mask)
+		}
+		b.ipnet = ipnet
+	case XLine:
+		if len(b.Mask) > 2 && b.Mask[0] == '/' && b.Mask[len(b.Mask)-1] == '/' {
+			re, err := regexp.Compile("(?i)" + b.Mask[1:len(b.Mask)-1])
+			if err != nil {
+				return fmt.Errorf("invalid X-line regexp %q: %s", b.Mask, err)
+			}
+			b.regexp = re
+		} else if len(b.Mask) == 0 {
+			return fmt.Errorf("empty X-line mask")
+		}
+	default:
+		return fmt.Errorf("unknown ban type %q", b.Kind)
+	}
+	return nil
+}
+
+// Parse an IP address (as a single-address network) or a CIDR range.
+func parseIPNet(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	return ipnet, err
+}
+
+// Get the name of the kind of ban (e.g. "K-line").
+func (b *Ban) Type() string {
+	return string(b.Kind) + "-line"
+}
+
+// Check whether the ban has expired.
+func (b *Ban) Expired(now time.Time) bool {
+	return b.Expires > 0 && now.Unix() >= b.Expires
+}
+
+// Get the time until the ban expires, or 0 if it is permanent.
+func (b *Ban) Remaining(now time.Time) time.Duration {
+	if b.Expires == 0 {
+		return 0
+	}
+	return time.Unix(b.Expires, 0).Sub(now)
+}
+
+// Check whether a user matches a K-line.  The host part of the mask is
+// matched against both the hostname and the IP address.
+func (b *Ban) matchUser(username, host, ip string) bool {
+	if !parser.Match(b.user, username) {
+		return false
+	}
+	if b.ipnet != nil {
+		addr := net.ParseIP(ip)
+		return addr != nil && b.ipnet.Contains(addr)
+	}
+	return parser.Match(b.host, host) || parser.Match(b.host, ip)
+}
+
+// Check whether a realname matches an X-line.
+func (b *Ban) matchName(realname string) bool {
+	if b.regexp != nil {
+		return b.regexp.MatchString(realname)
+	}
+	return parser.Match(b.Mask, realname)
+}
+
+// Check whether a client matches the ban.  Each kind of ban only looks at
+// the fields it applies to.
+func (b *Ban) Matches(username, host, ip, realname string) bool {
+	switch b.Kind {
+	case KLine:
+		return b.matchUser(username, host, ip)
+	case DLine:
+		addr := net.ParseIP(ip)
+		return addr != nil && b.ipnet.Contains(addr)
+	case XLine:
+		return b.matchName(realname)
+	}
+	return false
+}
+
+// Normalize a mask for use as a key.
+func key(mask string) string {
+	return parser.ToLower(mask)
+}
+
+// Add a ban, replacing any of the same kind with the same mask.
+func Add(b *Ban) {
+	banMutex.Lock()
+	defer banMutex.Unlock()
+	bans[b.Kind][key(b.Mask)] = b
+}
+
+// Remove the ban of the given kind with the given mask, returning it.  If
+// there is no such ban, ok is false.
+func Remove(kind byte, mask string) (b *Ban, ok bool) {
+	banMutex.Lock()
+	defer banMutex.Unlock()
+	b, ok = bans[kind][key(mask)]
+	delete(bans[kind], key(mask))
+	return
+}
+
+// List the current bans of the given kind, sorted by mask.
+func List(kind byte) []*Ban {
+	banMutex.RLock()
+	defer banMutex.RUnlock()
+
+	now := time.Now()
+	list := make([]*Ban, 0, len(bans[kind]))
+	for _, b := range bans[kind] {
+		if !b.Expired(now) {
+			list = append(list, b)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return key(list[i].Mask) < key(list[j].Mask)
+	})
+	return list
+}
+
+// Find the first current ban of the given kind for which match returns true.
+func find(kind byte, match func(*Ban) bool) (*Ban, bool) {
+	banMutex.RLock()
+	defer banMutex.RUnlock()
+
+	now := time.Now()
+	for _, b := range bans[kind] {
+		if !b.Expired(now) && match(b) {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// Find a K-line matching the given username, hostname and IP address.
+func CheckUser(username, host, ip string) (*Ban, bool) {
+	return find(KLine, func(b *Ban) bool { return b.matchUser(username, host, ip) })
+}
+
+// Find a D-line matching the given IP address.
+func CheckIP(ip net.IP) (*Ban, bool) {
+	return find(DLine, func(b *Ban) bool { return b.ipnet.Contains(ip) })
+}
+
+// Find an X-line matching the given realname.
+func CheckName(realname string) (*Ban, bool) {
+	return find(XLine, func(b *Ban) bool { return b.matchName(realname) })
+}
+
+// A Set holds bans of each kind, which can be read before they are put in
+// use.
+type Set map[byte]map[string]*Ban
+
+// Load replaces the current bans with the ones in the given file.  A file
+// which does not exist holds no bans.
+func Load(filename string) error {
+	set, err := Read(filename)
+	if err != nil {
+		return err
+	}
+	Use(set)
+	return nil
+}
+
+// LoadFrom replaces the current bans with the ones read from r.  If there is
+// an error, the bans are not changed.
+func LoadFrom(r io.Reader) error {
+	set, err := ReadFrom(r)
+	if err != nil {
+		return err
+	}
+	Use(set)
+	return nil
+}
+
+// Read reads the bans in the given file without using them.  A file which
+// does not exist holds no bans.
+func Read(filename string) (Set, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return ReadFrom(strings.NewReader(""))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadFrom(file)
+}
+
+// ReadFrom reads bans from r without using them.  Bans that have expired are
+// skipped.
+func ReadFrom(r io.Reader) (Set, error) {
+	loaded := Set{
+		KLine: {},
+		DLine: {},
+		XLine: {},
+	}
+
+	now := time.Now()
+	lines := bufio.NewScanner(r)
+	for lineno := 1; lines.Scan(); lineno++ {
+		line := lines.Text()
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 || len(fields[0]) != 1 {
+			return nil, fmt.Errorf("line %d: want 7 tab-separated fields", lineno)
+		}
+		set, err1 := strconv.ParseInt(fields[2], 10, 64)
+		expires, err2 := strconv.ParseInt(fields[3], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("line %d: invalid time", lineno)
+		}
+		b := &Ban{
+			Kind:       fields[0][0],
+			Mask:       fields[1],
+			Set:        set,
+			Expires:    expires,
+			Setter:     fields[4],
+			Reason:     fields[5],
+			OperReason: fields[6],
+		}
+		if err := b.compile(); err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineno, err)
+		}
+		if !b.Expired(now) {
+			loaded[b.Kind][key(b.Mask)] = b
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// Use replaces the current bans with the given ones.
+func Use(set Set) {
+	banMutex.Lock()
+	defer banMutex.Unlock()
+	bans = set
+}
+
+// Save writes the current bans to the given file, replacing its contents.
+func Save(filename string) error {
+	saveMutex.Lock()
+	defer saveMutex.Unlock()
+
+	tmp := filename + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := SaveTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// SaveTo writes the current bans to w.
+func SaveTo(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	fmt.Fprintln(buf, "# kind\tmask\tset\texpires\tsetter\treason\toper reason")
+	for _, kind := range []byte{KLine, DLine, XLine} {
+		for _, b := range List(kind) {
+			fmt.Fprintf(buf, "%c\t%s\t%d\t%d\t%s\t%s\t%s\n", b.Kind, b.Mask, b.Set, b.Expires,
+				b.Setter, clean(b.Reason), clean(b.OperReason))
+		}
+	}
+	return buf.Flush()
+}
+
+// Tabs would split a field in two.
+func clean(s string) string {
+	return strings.Replace(s, "\t", " ", -1)
+}
@@ -0,0 +1,134 @@
+package ban
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func mustNew(t *testing.T, kind byte, mask string, duration time.Duration) *Ban {
+	b, err := New(kind, mask, duration, "oper", "reason", "oper reason")
+	if err != nil {
+		t.Fatalf("New(%c, %q): %s", kind, mask, err)
+	}
+	return b
+}
+
+var invalidMasks = []struct {
+	Kind byte
+	Mask string
+}{
+	{KLine, "nohost"},
+	{KLine, "@host"},
+	{KLine, "user@"},
+	{DLine, "example.com"},
+	{DLine, "10.0.0.0/33"},
+	{XLine, "/(/"},
+	{'Q', "*"},
+}
+
+func TestInvalidMasks(t *testing.T) {
+	for _, test := range invalidMasks {
+		if _, err := New(test.Kind, test.Mask, 0, "", "", ""); err == nil {
+			t.Errorf("New(%c, %q) succeeded, want error", test.Kind, test.Mask)
+		}
+	}
+}
+
+var checkTests = []struct {
+	Desc  string
+	Check func() bool
+	Want  bool
+}{
+	{"kline host", func() bool { _, ok := CheckUser("bob", "bad.example.com", "1.2.3.4"); return ok }, true},
+	{"kline ip", func() bool { _, ok := CheckUser("bob", "good.example.com", "10.1.2.3"); return ok }, true},
+	{"kline user", func() bool { _, ok := CheckUser("alice", "good.example.com", "1.2.3.4"); return ok }, false},
+	{"kline cidr", func() bool { _, ok := CheckUser("x", "host", "192.168.7.7"); return ok }, true},
+	{"kline expired", func() bool { _, ok := CheckUser("x", "old.example.com", "1.2.3.4"); return ok }, false},
+	{"dline ip", func() bool { _, ok := CheckIP(net.ParseIP("172.16.0.1")); return ok }, true},
+	{"dline cidr", func() bool { _, ok := CheckIP(net.ParseIP("2001:db8::1")); return ok }, true},
+	{"dline miss", func() bool { _, ok := CheckIP(net.ParseIP("172.16.0.2")); return ok }, false},
+	{"xline glob", func() bool { _, ok := CheckName("Free Money Here"); return ok }, true},
+	{"xline regexp", func() bool { _, ok := CheckName("spambot1234"); return ok }, true},
+	{"xline miss", func() bool { _, ok := CheckName("Just Bob"); return ok }, false},
+}
+
+func setupBans(t *testing.T) {
+	LoadFrom(new(bytes.Buffer))
+	Add(mustNew(t, KLine, "b*@*.example.com", 0))
+	Add(mustNew(t, KLine, "*@10.*", time.Hour))
+	Add(mustNew(t, KLine, "*@192.168.0.0/16", 0))
+	Add(mustNew(t, KLine, "*@old.example.com", -time.Hour))
+	Add(mustNew(t, DLine, "172.16.0.1", 0))
+	Add(mustNew(t, DLine, "2001:db8::/32", 0))
+	Add(mustNew(t, XLine, "*free money*", 0))
+	Add(mustNew(t, XLine, "/^spambot[0-9]+$/", 0))
+
+	// A negative duration is permanent; expire it by hand
+	expired, _ := Remove(KLine, "*@OLD.example.com")
+	expired.Expires = time.Now().Add(-time.Minute).Unix()
+	Add(expired)
+}
+
+func TestCheck(t *testing.T) {
+	setupBans(t)
+	for _, test := range checkTests {
+		if got, want := test.Check(), test.Want; got != want {
+			t.Errorf("%s: got %v, want %v", test.Desc, got, want)
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	setupBans(t)
+	before := len(List(KLine)) + len(List(DLine)) + len(List(XLine))
+
+	buf := new(bytes.Buffer)
+	if err := SaveTo(buf); err != nil {
+		t.Fatalf("SaveTo: %s", err)
+	}
+	LoadFrom(new(bytes.Buffer))
+	if err := LoadFrom(buf); err != nil {
+		t.Fatalf("LoadFrom: %s", err)
+	}
+
+	if after := len(List(KLine)) + len(List(DLine)) + len(List(XLine)); after != before {
+		t.Errorf("loaded %d bans, want %d", after, before)
+	}
+	for _, test := range checkTests {
+		if got, want := test.Check(), test.Want; got != want {
+			t.Errorf("after reload: %s: got %v, want %v", test.Desc, got, want)
+		}
+	}
+
+	if err := LoadFrom(bytes.NewBufferString("K\tnohost\t0\t0\toper\treason\t\n")); err == nil {
+		t.Errorf("LoadFrom(invalid mask) succeeded, want error")
+	}
+}
+
+func TestConcurrentSave(t *testing.T) {
+	setupBans(t)
+	dir, err := ioutil.TempDir("", "bans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ircd.bans")
+
+	errs := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() { errs <- Save(file) }()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Save: %s", err)
+		}
+	}
+	if err := Load(file); err != nil {
+		t.Errorf("Load: %s", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/kylelemons/ircd-blight/old/ircd/mode"
 	"github.com/kylelemons/ircd-blight/old/ircd/parser"
 )
 
@@ -14,13 +15,33 @@ var (
 	chanMap   = make(map[string]*Channel)
 )
 
+// If set, OnDestroy is called with the name of each channel as it is
+// destroyed because its last user has left.  It is called with the channel
+// locked, so it must not use the channel package.
+var OnDestroy func(name string)
+
+// Remove an empty channel.  Both its mutex and chanMutex must be held.
+func (c *Channel) destroy() {
+	delete(chanMap, parser.ToLower(c.name))
+	if OnDestroy != nil {
+		OnDestroy(c.name)
+	}
+}
+
 // Store the channel information and keep it synchronized across possible
 // multiple accesses.
 type Channel struct {
-	mutex *sync.RWMutex
-	name  string
-	ts    int64
-	users map[string]string // users[uid] = hostmask
+	mutex   *sync.RWMutex
+	name    string
+	ts      int64
+	created int64             // when the channel was created (in seconds)
+	users   map[string]string // users[uid] = hostmask
+	joined  map[string]int64  // joined[uid] = when they joined (in nanoseconds)
+	modes   *mode.ActiveModes // status modes are stored with the uid as argument
+
+	topic   string
+	topicBy string
+	topicTS int64
 }
 
 // Get the Channel structure for the given channel.  If it does not exist and
@@ -43,9 +64,12 @@ func Get(name string, create bool) (*Channel, error) {
 	}
 
 	c := &Channel{
-		mutex: new(sync.RWMutex),
-		name:  name,
-		users: make(map[string]string),
+		mutex:   new(sync.RWMutex),
+		name:    name,
+		created: time.Now().Unix(),
+		users:   make(map[string]string),
+		joined:  make(map[string]int64),
+		modes:   mode.NewActiveModes(mode.ChannelModes),
 	}
 
 	chanMap[lowname] = c
@@ -64,6 +88,13 @@ func (c *Channel) TS() string {
 	return strconv.FormatInt(c.ts/1e9, 10)
 }
 
+// Get when the channel was created on this server (in seconds)
+func (c *Channel) Created() int64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.created
+}
+
 // Get the chanel member IDs
 func (c *Channel) UserIDs() []string {
 	c.mutex.RLock()
@@ -75,13 +106,13 @@ func (c *Channel) UserIDs() []string {
 	return ids
 }
 
-// Get the chanel member IDs with prefixes
+// Get the chanel member IDs with status prefixes (e.g. "@+" for SJOIN)
 func (c *Channel) UserIDsWithPrefix() []string {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 	ids := make([]string, 0, len(c.users))
 	for id := range c.users {
-		ids = append(ids, id)
+		ids = append(ids, c.status(id)+id)
 	}
 	return ids
 }
@@ -94,6 +125,17 @@ func (c *Channel) OnChan(uid string) (on bool) {
 	return
 }
 
+// Get when a user joined the channel.
+func (c *Channel) Joined(uid string) (joined time.Time, on bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	ts, on := c.joined[uid]
+	if !on {
+		return
+	}
+	return time.Unix(0, ts), true
+}
+
 // Join a user to the channel.
 func (c *Channel) Join(uids ...string) (notify []string, err error) {
 	c.mutex.Lock()
@@ -107,6 +149,7 @@ func (c *Channel) Join(uids ...string) (notify []string, err error) {
 		// TODO(kevlar): Check hostmask
 		c.users[uid] = "host@mask"
 		c.ts = time.Now().UnixNano()
+		c.joined[uid] = c.ts
 	}
 
 	notify = make([]string, 0, len(c.users))
@@ -148,13 +191,15 @@ func (c *Channel) Part(uid string) (notify []string, err error) {
 		notify = append(notify, id)
 	}
 	delete(c.users, uid)
+	delete(c.joined, uid)
+	c.dropStatus(uid)
 	c.ts = time.Now().UnixNano()
 
 	if len(c.users) == 0 {
 		chanMutex.Lock()
 		defer chanMutex.Unlock()
 
-		delete(chanMap, c.name)
+		c.destroy()
 	}
 
 	return
@@ -178,10 +223,12 @@ func PartAll(uid string) (notify map[string][]string) {
 			notify[c.name] = append(notify[c.name], id)
 		}
 		delete(c.users, uid)
+		delete(c.joined, uid)
+		c.dropStatus(uid)
 		c.ts = time.Now().UnixNano()
 
 		if len(c.users) == 0 {
-			delete(chanMap, c.name)
+			c.destroy()
 		}
 	}
 
@@ -212,6 +259,8 @@ func Netsplit(sid string, uids []string) map[string][]string {
 		for leavingUID := range leaving2notify {
 			leavingChanUIDs = append(leavingChanUIDs, leavingUID)
 			delete(c.users, leavingUID)
+			delete(c.joined, leavingUID)
+			c.dropStatus(leavingUID)
 		}
 		if len(leavingChanUIDs) == 0 {
 			return
@@ -227,7 +276,7 @@ func Netsplit(sid string, uids []string) map[string][]string {
 		}
 
 		if len(c.users) == 0 {
-			delete(chanMap, c.name)
+			c.destroy()
 		}
 	}
 
@@ -244,6 +293,32 @@ func Netsplit(sid string, uids []string) map[string][]string {
 	return notify
 }
 
+// UserChannels returns the channels the given user is on.
+func UserChannels(uid string) []*Channel {
+	// Don't hold chanMutex while locking the channels (see chanMutex)
+	chanMutex.RLock()
+	all := make([]*Channel, 0, len(chanMap))
+	for _, c := range chanMap {
+		all = append(all, c)
+	}
+	chanMutex.RUnlock()
+
+	chans := []*Channel{}
+	for _, c := range all {
+		if c.OnChan(uid) {
+			chans = append(chans, c)
+		}
+	}
+	return chans
+}
+
+// Get the number of channels.
+func Count() int {
+	chanMutex.RLock()
+	defer chanMutex.RUnlock()
+	return len(chanMap)
+}
+
 func Iter() <-chan string {
 	chanMutex.RLock()
 	defer chanMutex.RUnlock()
@@ -114,3 +114,26 @@ func BenchmarkJoin(b *testing.B) {
 	}
 	wg.Wait()
 }
+
+func TestDestroy(t *testing.T) {
+	destroyed := []string{}
+	OnDestroy = func(name string) { destroyed = append(destroyed, name) }
+	defer func() { OnDestroy = nil }()
+
+	for _, name := range []string{"#Destroy1", "#Destroy2"} {
+		c, _ := Get(name, true)
+		c.Join("000AAADS1")
+	}
+	c, _ := Get("#Destroy1", false)
+	c.Part("000AAADS1")
+	PartAll("000AAADS1")
+
+	if got, want := len(destroyed), 2; got != want {
+		t.Errorf("destroyed %v, want both channels", destroyed)
+	}
+	for _, name := range []string{"#destroy1", "#DESTROY2"} {
+		if _, err := Get(name, false); err == nil {
+			t.Errorf("%s still exists", name)
+		}
+	}
+}
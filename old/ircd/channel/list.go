@@ -0,0 +1,87 @@
+package channel
+
+import (
+	"strconv"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+)
+
+// A ListFilter selects the channels shown by LIST.  The zero value matches
+// every channel; each nonzero field adds a restriction.  Times are absolute
+// (in seconds).
+type ListFilter struct {
+	Masks    []string // the channel name must match one of these
+	NotMasks []string // the channel name must match none of these
+
+	MinUsers int // more than this many users (>N)
+	MaxUsers int // fewer than this many users (<N)
+
+	CreatedAfter  int64 // created after this time (C<N)
+	CreatedBefore int64 // created before this time (C>N)
+	TopicAfter    int64 // topic set after this time (T<N)
+	TopicBefore   int64 // topic set before this time (T>N)
+
+	Hidden bool // include secret and private channels (for opers with auspex)
+}
+
+// Construct the RPL_LIST for the channel as seen by destID.  If the channel
+// does not match the filter, or if it is private or secret and destID is
+// not a member (unless the filter includes hidden channels), nil is returned.
+func (c *Channel) ListMessage(destID string, filter *ListFilter) *parser.Message {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if _, member := c.users[destID]; !member && c.symbol() != "=" && !filter.Hidden {
+		return nil
+	}
+	if !filter.match(c) {
+		return nil
+	}
+
+	return &parser.Message{
+		Command: parser.RPL_LIST,
+		Args: []string{
+			"*",
+			c.name,
+			strconv.Itoa(len(c.users)),
+			c.topic,
+		},
+		DestIDs: []string{destID},
+	}
+}
+
+// Make sure the channel mutex is (r)locked before calling this.
+func (f *ListFilter) match(c *Channel) bool {
+	switch users := len(c.users); {
+	case f.MinUsers > 0 && users <= f.MinUsers:
+		return false
+	case f.MaxUsers > 0 && users >= f.MaxUsers:
+		return false
+	}
+
+	switch {
+	case f.CreatedAfter > 0 && c.created <= f.CreatedAfter:
+		return false
+	case f.CreatedBefore > 0 && c.created >= f.CreatedBefore:
+		return false
+	case f.TopicAfter > 0 && c.topicTS <= f.TopicAfter:
+		return false
+	case f.TopicBefore > 0 && c.topicTS >= f.TopicBefore:
+		return false
+	}
+
+	for _, mask := range f.NotMasks {
+		if parser.Match(mask, c.name) {
+			return false
+		}
+	}
+	if len(f.Masks) == 0 {
+		return true
+	}
+	for _, mask := range f.Masks {
+		if parser.Match(mask, c.name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package channel
+
+import (
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/mode"
+)
+
+var listMessageTests = []struct {
+	Desc   string
+	Dest   string
+	Filter ListFilter
+	Listed bool
+}{
+	{"no filter", "000AAAOUT", ListFilter{}, true},
+	{"mask", "000AAAOUT", ListFilter{Masks: []string{"#LIST*"}}, true},
+	{"other mask", "000AAAOUT", ListFilter{Masks: []string{"#other"}}, false},
+	{"excluded", "000AAAOUT", ListFilter{NotMasks: []string{"#*msg"}}, false},
+	{"more users", "000AAAOUT", ListFilter{MinUsers: 1}, true},
+	{"too few users", "000AAAOUT", ListFilter{MinUsers: 2}, false},
+	{"fewer users", "000AAAOUT", ListFilter{MaxUsers: 3}, true},
+	{"too many users", "000AAAOUT", ListFilter{MaxUsers: 2}, false},
+	{"created after", "000AAAOUT", ListFilter{CreatedAfter: 500}, true},
+	{"created before", "000AAAOUT", ListFilter{CreatedBefore: 500}, false},
+	{"topic after", "000AAAOUT", ListFilter{TopicAfter: 1500}, false},
+	{"topic before", "000AAAOUT", ListFilter{TopicBefore: 1500}, true},
+}
+
+func TestListMessage(t *testing.T) {
+	c, _ := Get("#listmsg", true)
+	c.Join("000AAALS1", "000AAALS2")
+	defer PartAll("000AAALS1")
+	defer PartAll("000AAALS2")
+	c.SetTopic("nick", "a topic", 1000)
+
+	for _, test := range listMessageTests {
+		msg := c.ListMessage(test.Dest, &test.Filter)
+		if got, want := msg != nil, test.Listed; got != want {
+			t.Errorf("%s: listed = %v, want %v", test.Desc, got, want)
+			continue
+		}
+		if msg == nil {
+			continue
+		}
+		if got, want := msg.Args[2], "2"; got != want {
+			t.Errorf("%s: users = %q, want %q", test.Desc, got, want)
+		}
+		if got, want := msg.Args[3], "a topic"; got != want {
+			t.Errorf("%s: topic = %q, want %q", test.Desc, got, want)
+		}
+	}
+
+	changes, _ := mode.ChannelModes.ParseModeChange([]string{"+s"})
+	c.ApplyModes(changes)
+	if msg := c.ListMessage("000AAAOUT", &ListFilter{}); msg != nil {
+		t.Errorf("+s: non-member got %v", msg)
+	}
+	if msg := c.ListMessage("000AAALS1", &ListFilter{}); msg == nil {
+		t.Errorf("+s: member got nil")
+	}
+}
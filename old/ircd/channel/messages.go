@@ -1,38 +1,96 @@
 package channel
 
 import (
-	"bytes"
 	"log"
 
 	"github.com/kylelemons/ircd-blight/old/ircd/parser"
 	"github.com/kylelemons/ircd-blight/old/ircd/user"
 )
 
-// Construct a names message for the channel.
-func (c *Channel) NamesMessage(destIDs ...string) *parser.Message {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+// Get the NAMES symbol for the channel: = for public, * for private (+p),
+// and @ for secret (+s) channels.
+func (c *Channel) Symbol() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.symbol()
+}
+
+// Make sure the channel mutex is (r)locked before calling this.
+func (c *Channel) symbol() string {
+	if _, secret := c.modes.Lookup('s'); secret {
+		return "@"
+	}
+	if _, private := c.modes.Lookup('p'); private {
+		return "*"
+	}
+	return "="
+}
+
+// Construct the RPL_NAMREPLY messages for the channel as seen by destID.  The
+// list of names in each reply is kept within maxlen bytes.  Non-members do
+// not see invisible (+i) users or the members of private and secret
+// channels.  With multiPrefix, all of a member's status prefixes are shown
+// instead of just the highest; with userhost, members are shown as
+// nick!user@host.  The RPL_ENDOFNAMES is not included.
+func (c *Channel) NamesMessages(destID string, maxlen int, multiPrefix, userhost bool) []*parser.Message {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	_, member := c.users[destID]
+	symbol := c.symbol()
+	if !member && symbol != "=" {
+		return nil
+	}
+
+	msgs := []*parser.Message{}
+	names := ""
+	flush := func() {
+		if len(names) == 0 {
+			return
+		}
+		msgs = append(msgs, &parser.Message{
+			Command: parser.RPL_NAMREPLY,
+			Args: []string{
+				"*",
+				symbol,
+				c.name,
+				names,
+			},
+			DestIDs: []string{destID},
+		})
+		names = ""
+	}
 
-	buf := bytes.NewBuffer(nil)
 	for id := range c.users {
-		nick, _, _, _, ok := user.GetInfo(id)
+		u, ok := user.Lookup(id)
 		if !ok {
 			log.Printf("Warning: Unknown id %q in %s", id, c.name)
 			continue
 		}
-		buf.WriteByte(' ')
-		buf.WriteString(nick)
-	}
-	buf.ReadByte()
-	return &parser.Message{
-		Command: parser.RPL_NAMREPLY,
-		Args: []string{
-			// =public *private @secret
-			"*",
-			"@",
-			c.name,
-			buf.String(),
-		},
-		DestIDs: destIDs,
+		if !member && u.HasMode('i') {
+			continue
+		}
+
+		name := u.Nick()
+		if userhost {
+			name = u.Hostmask()
+		}
+		if status := c.status(id); len(status) > 0 {
+			if !multiPrefix {
+				status = status[:1]
+			}
+			name = status + name
+		}
+
+		if len(names) > 0 && len(names)+1+len(name) > maxlen {
+			flush()
+		}
+		if len(names) > 0 {
+			names += " "
+		}
+		names += name
 	}
+	flush()
+
+	return msgs
 }
@@ -0,0 +1,74 @@
+package channel
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/mode"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+func TestNamesMessages(t *testing.T) {
+	user.Import("001NAMESA", "Alpha", "alpha", "a.host", "10.0.0.1", "1", "1", "+", "Alpha")
+	user.Import("001NAMESB", "Bravo", "bravo", "b.host", "10.0.0.2", "1", "1", "+i", "Bravo")
+	user.Import("001NAMESC", "Charlie", "charlie", "c.host", "10.0.0.3", "1", "1", "+", "Charlie")
+	defer user.Delete("001NAMESA")
+	defer user.Delete("001NAMESB")
+	defer user.Delete("001NAMESC")
+
+	c, _ := Get("#names", true)
+	c.Join("001NAMESA", "001NAMESB")
+	defer PartAll("001NAMESA")
+	defer PartAll("001NAMESB")
+
+	status, _ := mode.ChannelModes.ParseModeChange(strings.Fields("+ov 001NAMESA 001NAMESA"))
+	c.ApplyModes(status)
+
+	names := func(destID string, maxlen int, multiPrefix, userhost bool) (symbols, lists []string) {
+		for _, msg := range c.NamesMessages(destID, maxlen, multiPrefix, userhost) {
+			symbols = append(symbols, msg.Args[1])
+			fields := strings.Fields(msg.Args[3])
+			sort.Strings(fields)
+			lists = append(lists, strings.Join(fields, " "))
+		}
+		return
+	}
+
+	tests := []struct {
+		Desc        string
+		DestID      string
+		Modes       string
+		MaxLen      int
+		MultiPrefix bool
+		UserHost    bool
+		Symbols     []string
+		Lists       []string
+	}{
+		{"member", "001NAMESA", "+", 400, false, false, []string{"="}, []string{"@Alpha Bravo"}},
+		{"non-member", "001NAMESC", "+", 400, false, false, []string{"="}, []string{"@Alpha"}},
+		{"multi-prefix", "001NAMESA", "+", 400, true, false, []string{"="}, []string{"@+Alpha Bravo"}},
+		{"userhost", "001NAMESC", "+", 400, false, true, []string{"="}, []string{"@Alpha!alpha@a.host"}},
+		{"split", "001NAMESA", "+", 6, false, false, []string{"=", "="}, nil},
+		{"private", "001NAMESA", "+p", 400, false, false, []string{"*"}, []string{"@Alpha Bravo"}},
+		{"private non-member", "001NAMESC", "+p", 400, false, false, nil, nil},
+		{"secret", "001NAMESA", "-p+s", 400, false, false, []string{"@"}, []string{"@Alpha Bravo"}},
+		{"secret non-member", "001NAMESC", "+s", 400, false, false, nil, nil},
+	}
+
+	for _, test := range tests {
+		changes, _ := mode.ChannelModes.ParseModeChange([]string{test.Modes})
+		c.ApplyModes(changes)
+
+		symbols, lists := names(test.DestID, test.MaxLen, test.MultiPrefix, test.UserHost)
+		if got, want := strings.Join(symbols, ","), strings.Join(test.Symbols, ","); got != want {
+			t.Errorf("%s: symbols = %q, want %q", test.Desc, got, want)
+		}
+		if test.Lists == nil {
+			continue
+		}
+		if got, want := strings.Join(lists, ","), strings.Join(test.Lists, ","); got != want {
+			t.Errorf("%s: names = %q, want %q", test.Desc, got, want)
+		}
+	}
+}
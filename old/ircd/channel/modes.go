@@ -0,0 +1,200 @@
+package channel
+
+import (
+	"strings"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/mode"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+)
+
+// The channel status modes, from the highest rank to the lowest.
+var statusModes = mode.ChannelModes.StatusModes()
+
+// ModeRank returns the rank of the given status mode (e.g. 'o'), or 0 if it
+// is not a status mode.  Higher ranks carry more privileges.
+func ModeRank(r rune) int {
+	for i, spec := range statusModes {
+		if spec.Char() == r {
+			return len(statusModes) - i
+		}
+	}
+	return 0
+}
+
+// PrefixRank returns the rank of the given status prefix (e.g. '@'), or 0 if
+// it is not a status prefix.
+func PrefixRank(prefix byte) int {
+	for i, spec := range statusModes {
+		if spec.Prefix()[0] == prefix {
+			return len(statusModes) - i
+		}
+	}
+	return 0
+}
+
+// SplitStatus splits a STATUSMSG target (e.g. "@#chan") into the minimum
+// rank of the recipients and the channel name.  If there are no status
+// prefixes, the rank is 0 and the target is returned unchanged.
+func SplitStatus(target string) (rank int, name string) {
+	name = target
+	for len(name) > 0 {
+		r := PrefixRank(name[0])
+		if r == 0 {
+			break
+		}
+		if rank == 0 || r < rank {
+			rank = r
+		}
+		name = name[1:]
+	}
+	return
+}
+
+// Get the status prefixes held by a user, highest first.
+func (c *Channel) Status(uid string) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.status(uid)
+}
+
+// Get the rank of the highest status held by a user.
+func (c *Channel) Rank(uid string) int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.rank(uid)
+}
+
+// Get whether a flag mode (e.g. 'n') is set on the channel.
+func (c *Channel) HasMode(r rune) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	_, set := c.modes.Lookup(r)
+	return set
+}
+
+// Get the channel modes and their arguments, suitable for an SJOIN or
+// RPL_CHANNELMODEIS.  Status and list modes are not included.
+func (c *Channel) Modes() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	modes := []mode.Mode{}
+	for _, m := range c.modes.Modes {
+		switch mode.ChannelModes.For(m).Type() {
+		case mode.StatusMode, mode.ListMode:
+			continue
+		}
+		modes = append(modes, m)
+	}
+	if len(modes) == 0 {
+		return []string{"+"}
+	}
+	return strings.Fields(mode.ChannelModes.ModeString(modes))
+}
+
+// Apply mode changes to the channel.  Status modes whose argument is not a
+// member of the channel are skipped.  The changes that were applied are
+// returned.
+func (c *Channel) ApplyModes(changes []mode.Mode) (applied []mode.Mode) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, m := range changes {
+		if mode.ChannelModes.For(m).Type() == mode.StatusMode {
+			if _, on := c.users[m.Args[0]]; !on {
+				continue
+			}
+		}
+		// Copy the arguments so the active modes never alias the caller's
+		m.Args = append([]string(nil), m.Args...)
+		applied = append(applied, m)
+	}
+	c.modes.Apply(applied)
+	return
+}
+
+// Check whether a user may speak on the channel.  Non-members are rejected
+// when the channel is +n and users without voice are rejected when it is +m.
+func (c *Channel) CanSend(uid string) error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if _, on := c.users[uid]; !on {
+		if _, set := c.modes.Lookup('n'); set {
+			return parser.NewNumeric(parser.ERR_CANNOTSENDTOCHAN, c.name)
+		}
+	}
+	if _, set := c.modes.Lookup('m'); set && c.rank(uid) < ModeRank('v') {
+		return parser.NewNumeric(parser.ERR_CANNOTSENDTOCHAN, c.name)
+	}
+	return nil
+}
+
+// Check whether one member may kick another.  Half-operators may only kick
+// voiced or regular users; operators may kick anyone (there is no higher
+// status in mode.ChannelModes).
+func (c *Channel) CanKick(kicker, target string) error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if _, on := c.users[kicker]; !on {
+		return parser.NewNumeric(parser.ERR_NOTONCHANNEL, c.name)
+	}
+	if _, on := c.users[target]; !on {
+		return parser.NewNumeric(parser.ERR_USERNOTINCHANNEL, target, c.name)
+	}
+
+	switch rank := c.rank(kicker); {
+	case rank >= ModeRank('o'):
+		return nil
+	case rank >= ModeRank('h') && c.rank(target) < ModeRank('h'):
+		return nil
+	}
+	return parser.NewNumeric(parser.ERR_CHANOPRIVSNEEDED, c.name)
+}
+
+// Get the IDs of the channel members with at least the given rank.
+func (c *Channel) UserIDsWithRank(min int) []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	ids := make([]string, 0, len(c.users))
+	for id := range c.users {
+		if c.rank(id) >= min {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Make sure the channel mutex is (r)locked before calling this.
+func (c *Channel) status(uid string) string {
+	prefixes := ""
+	for _, spec := range statusModes {
+		if c.modes.Contains(spec.Char(), uid) {
+			prefixes += spec.Prefix()
+		}
+	}
+	return prefixes
+}
+
+// Make sure the channel mutex is (r)locked before calling this.
+func (c *Channel) rank(uid string) int {
+	for i, spec := range statusModes {
+		if c.modes.Contains(spec.Char(), uid) {
+			return len(statusModes) - i
+		}
+	}
+	return 0
+}
+
+// Make sure the channel mutex is locked before calling this.
+func (c *Channel) dropStatus(uid string) {
+	for _, spec := range statusModes {
+		_, index, _ := mode.ChannelModes.Mode(spec.Char())
+		c.modes.Apply([]mode.Mode{{
+			Index: index,
+			Op:    mode.UnsetMode,
+			Args:  []string{uid},
+		}})
+	}
+}
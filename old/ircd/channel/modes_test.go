@@ -0,0 +1,118 @@
+package channel
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/mode"
+)
+
+var splitStatusTests = []struct {
+	Target string
+	Rank   int
+	Name   string
+}{
+	{"#chan", 0, "#chan"},
+	{"@#chan", 3, "#chan"},
+	{"%#chan", 2, "#chan"},
+	{"+#chan", 1, "#chan"},
+	{"@+#chan", 1, "#chan"},
+	{"nick", 0, "nick"},
+}
+
+func TestSplitStatus(t *testing.T) {
+	for idx, test := range splitStatusTests {
+		rank, name := SplitStatus(test.Target)
+		if got, want := rank, test.Rank; got != want {
+			t.Errorf("#%d: SplitStatus(%q) rank = %d, want %d", idx, test.Target, got, want)
+		}
+		if got, want := name, test.Name; got != want {
+			t.Errorf("#%d: SplitStatus(%q) name = %q, want %q", idx, test.Target, got, want)
+		}
+	}
+}
+
+var canSendTests = []struct {
+	Modes   string
+	ID      string
+	CanSend bool
+}{
+	{"+", "000AAAOUT", true},
+	{"+n", "000AAAOUT", false},
+	{"+n", "000AAAREG", true},
+	{"+m", "000AAAREG", false},
+	{"+m", "000AAAVOI", true},
+	{"+m", "000AAAHOP", true},
+	{"+m", "000AAAOPS", true},
+	{"-n", "000AAAOUT", false},
+	{"-m", "000AAAREG", true},
+}
+
+func TestCanSend(t *testing.T) {
+	c, _ := Get("#cansend", true)
+	c.Join("000AAAREG", "000AAAVOI", "000AAAHOP", "000AAAOPS")
+	defer PartAll("000AAAREG")
+	defer PartAll("000AAAVOI")
+	defer PartAll("000AAAHOP")
+	defer PartAll("000AAAOPS")
+
+	status, _ := mode.ChannelModes.ParseModeChange(strings.Fields("+vho 000AAAVOI 000AAAHOP 000AAAOPS"))
+	c.ApplyModes(status)
+
+	for idx, test := range canSendTests {
+		changes, _ := mode.ChannelModes.ParseModeChange([]string{test.Modes})
+		c.ApplyModes(changes)
+		if got, want := c.CanSend(test.ID) == nil, test.CanSend; got != want {
+			t.Errorf("#%d: after %s, CanSend(%s) = %v, want %v", idx, test.Modes, test.ID, got, want)
+		}
+	}
+
+	if got, want := len(c.UserIDsWithRank(PrefixRank('%'))), 2; got != want {
+		t.Errorf("len(UserIDsWithRank(%%)) = %d, want %d", got, want)
+	}
+	if got, want := c.Status("000AAAOPS"), "@"; got != want {
+		t.Errorf("Status(op) = %q, want %q", got, want)
+	}
+
+	c.Part("000AAAOPS")
+	c.Join("000AAAOPS")
+	if got, want := c.Status("000AAAOPS"), ""; got != want {
+		t.Errorf("Status(op) after rejoin = %q, want %q", got, want)
+	}
+}
+
+var canKickTests = []struct {
+	Kicker  string
+	Target  string
+	CanKick bool
+}{
+	{"000AAAOPS", "000AAAREG", true},
+	{"000AAAOPS", "000AAAHOP", true},
+	{"000AAAOPS", "000AAAOP2", true},
+	{"000AAAHOP", "000AAAREG", true},
+	{"000AAAHOP", "000AAAVOI", true},
+	{"000AAAHOP", "000AAAOPS", false},
+	{"000AAAVOI", "000AAAREG", false},
+	{"000AAAREG", "000AAAVOI", false},
+	{"000AAAOUT", "000AAAREG", false},
+	{"000AAAOPS", "000AAAOUT", false},
+}
+
+func TestCanKick(t *testing.T) {
+	c, _ := Get("#cankick", true)
+	c.Join("000AAAREG", "000AAAVOI", "000AAAHOP", "000AAAOPS", "000AAAOP2")
+	defer PartAll("000AAAREG")
+	defer PartAll("000AAAVOI")
+	defer PartAll("000AAAHOP")
+	defer PartAll("000AAAOPS")
+	defer PartAll("000AAAOP2")
+
+	status, _ := mode.ChannelModes.ParseModeChange(strings.Fields("+vhoo 000AAAVOI 000AAAHOP 000AAAOPS 000AAAOP2"))
+	c.ApplyModes(status)
+
+	for idx, test := range canKickTests {
+		if got, want := c.CanKick(test.Kicker, test.Target) == nil, test.CanKick; got != want {
+			t.Errorf("#%d: CanKick(%s, %s) = %v, want %v", idx, test.Kicker, test.Target, got, want)
+		}
+	}
+}
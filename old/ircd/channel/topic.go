@@ -0,0 +1,48 @@
+package channel
+
+import (
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+)
+
+// Get the channel topic, who set it, and when it was set (in seconds).
+func (c *Channel) Topic() (topic, setter string, ts int64) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.topic, c.topicBy, c.topicTS
+}
+
+// Check whether a user may change the topic.  Users must be on the channel,
+// and if it is +t they must be at least a half-operator.
+func (c *Channel) CanSetTopic(uid string) error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if _, on := c.users[uid]; !on {
+		return parser.NewNumeric(parser.ERR_NOTONCHANNEL, c.name)
+	}
+	if _, set := c.modes.Lookup('t'); set && c.rank(uid) < ModeRank('h') {
+		return parser.NewNumeric(parser.ERR_CHANOPRIVSNEEDED, c.name)
+	}
+	return nil
+}
+
+// Set the channel topic.  The caller is responsible for permission checks.
+func (c *Channel) SetTopic(setter, topic string, ts int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.topic, c.topicBy, c.topicTS = topic, setter, ts
+}
+
+// Merge a topic received in a burst (TB).  The topic is only accepted if the
+// channel has no topic, or if it is older than and different from the
+// current one.  The return value indicates whether the topic was accepted.
+func (c *Channel) MergeTopic(setter, topic string, ts int64) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.topic) > 0 && (ts >= c.topicTS || topic == c.topic) {
+		return false
+	}
+	c.topic, c.topicBy, c.topicTS = topic, setter, ts
+	return true
+}
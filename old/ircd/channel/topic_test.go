@@ -0,0 +1,59 @@
+package channel
+
+import (
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/mode"
+)
+
+var mergeTopicTests = []struct {
+	Topic  string
+	TS     int64
+	Accept bool
+	After  string
+}{
+	{"first", 1000, true, "first"},
+	{"newer", 2000, false, "first"},
+	{"first", 500, false, "first"},
+	{"older", 500, true, "older"},
+	{"same age", 500, false, "older"},
+}
+
+func TestMergeTopic(t *testing.T) {
+	c, _ := Get("#mergetopic", true)
+	for idx, test := range mergeTopicTests {
+		if got, want := c.MergeTopic("server.name", test.Topic, test.TS), test.Accept; got != want {
+			t.Errorf("#%d: MergeTopic(%q, %d) = %v, want %v", idx, test.Topic, test.TS, got, want)
+		}
+		if got, _, _ := c.Topic(); got != test.After {
+			t.Errorf("#%d: topic = %q, want %q", idx, got, test.After)
+		}
+	}
+}
+
+func TestCanSetTopic(t *testing.T) {
+	c, _ := Get("#cansettopic", true)
+	c.Join("000AAATOP", "000AAAHOP")
+	defer PartAll("000AAATOP")
+	defer PartAll("000AAAHOP")
+
+	changes, _ := mode.ChannelModes.ParseModeChange([]string{"+h", "000AAAHOP"})
+	c.ApplyModes(changes)
+
+	if err := c.CanSetTopic("000AAATOP"); err != nil {
+		t.Errorf("-t: CanSetTopic(member) = %v, want nil", err)
+	}
+	if err := c.CanSetTopic("000AAAOUT"); err == nil {
+		t.Errorf("-t: CanSetTopic(non-member) succeeded unexpectedly")
+	}
+
+	changes, _ = mode.ChannelModes.ParseModeChange([]string{"+t"})
+	c.ApplyModes(changes)
+
+	if err := c.CanSetTopic("000AAATOP"); err == nil {
+		t.Errorf("+t: CanSetTopic(member) succeeded unexpectedly")
+	}
+	if err := c.CanSetTopic("000AAAHOP"); err != nil {
+		t.Errorf("+t: CanSetTopic(halfop) = %v, want nil", err)
+	}
+}
@@ -2,8 +2,13 @@ package conn
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"log"
 	"net"
+	"sync"
 
 	"github.com/kylelemons/ircd-blight/old/ircd/parser"
 	"github.com/kylelemons/ircd-blight/old/ircd/user"
@@ -11,14 +16,30 @@ import (
 
 type Conn struct {
 	net.Conn
-	active      bool
 	subscribers map[chan<- *parser.Message]bool
 	onclose     map[chan<- string]bool
-	Error       error
 	id          string
 	reading     bool
+
+	// The reader and writer may both end the connection, so these are
+	// guarded by sendMutex (see fail)
+	active bool
+	err    error
+
+	// The send queue, if there is one (see SetSendQ)
+	sendMutex *sync.Mutex
+	sendq     chan []byte
+	queued    int // bytes in sendq
+	maxSendQ  int
+	closed    bool
 }
 
+// The error for a connection which has too much data waiting to be sent.
+var ErrSendQ = errors.New("SendQ exceeded")
+
+// The most messages which may be waiting in a send queue, however short.
+const maxQueuedMessages = 4096
+
 func NewConn(nc net.Conn) *Conn {
 	c := &Conn{
 		Conn:        nc,
@@ -26,6 +47,7 @@ func NewConn(nc net.Conn) *Conn {
 		subscribers: make(map[chan<- *parser.Message]bool),
 		onclose:     make(map[chan<- string]bool),
 		id:          user.NextUserID(),
+		sendMutex:   new(sync.Mutex),
 	}
 	log.Printf("[%s] ** Connected", c.id)
 	return c
@@ -35,29 +57,96 @@ func (c *Conn) Close() error {
 	for ch := range c.onclose {
 		ch <- c.id
 	}
+
+	// With a send queue, the connection is closed once it has been sent
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	if c.sendq != nil {
+		if !c.closed {
+			c.closed = true
+			close(c.sendq)
+		}
+		return nil
+	}
 	return c.Conn.Close()
 }
 
+// SetSendQ makes writes to the connection asynchronous, with at most max
+// bytes waiting to be sent.  If a write would exceed this, the connection is
+// dropped (and its Err is ErrSendQ).  This may only be called once.
+func (c *Conn) SetSendQ(max int) {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	if c.sendq != nil || c.closed {
+		return
+	}
+	c.maxSendQ = max
+	c.sendq = make(chan []byte, maxQueuedMessages)
+	go c.writethread()
+}
+
+// Queued returns the number of bytes waiting in the send queue (always 0 if
+// there is none).
+func (c *Conn) Queued() int {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	return c.queued
+}
+
+func (c *Conn) writethread() {
+	// Always close the connection once the queue is closed
+	defer c.Conn.Close()
+
+	failed := false
+	for bytes := range c.sendq {
+		c.sendMutex.Lock()
+		c.queued -= len(bytes)
+		c.sendMutex.Unlock()
+		if failed {
+			continue
+		}
+		if n, err := c.Conn.Write(bytes); err != nil || n != len(bytes) {
+			c.fail(err)
+			failed = true
+			c.Conn.Close()
+		}
+	}
+}
+
 func (c *Conn) ID() string {
 	return c.id
 }
 
+// Get the hex SHA-256 fingerprint of the client's TLS certificate, or "" if
+// the connection is not using TLS or no certificate was presented.
+func (c *Conn) CertFP() string {
+	tc, ok := c.Conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(certs[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
+
 func (c *Conn) readthread() {
 	// Always close the connection
 	defer c.Close()
 
-	// Read lines by \r\n or \n
-	linereader := bufio.NewReader(c)
-	for c.active {
+	// Read lines by \r\n or \n (with room for IRCv3 tags)
+	linereader := bufio.NewReaderSize(c, parser.MaxTagLength+512)
+	for c.Active() {
 		line, _, err := linereader.ReadLine()
 		if err != nil {
-			c.active = false
-			c.Error = err
+			c.fail(err)
 			return
 		}
 		message := parser.ParseMessage(line)
-		message.SenderID = c.id
 		if message != nil {
+			message.SenderID = c.id
 			for subscriber := range c.subscribers {
 				subscriber <- message
 			}
@@ -68,18 +157,60 @@ func (c *Conn) readthread() {
 func (c *Conn) WriteMessage(message *parser.Message) {
 	bytes := message.Bytes()
 	bytes = append(bytes, '\r', '\n')
+
+	c.sendMutex.Lock()
+	if c.sendq != nil {
+		defer c.sendMutex.Unlock()
+		if c.closed {
+			return
+		}
+		if c.queued+len(bytes) > c.maxSendQ || len(c.sendq) == cap(c.sendq) {
+			// The reader will notice and close the Conn
+			log.Printf("[%s] ** SendQ exceeded (%d bytes)", c.id, c.queued)
+			c.active = false
+			if c.err == nil {
+				c.err = ErrSendQ
+			}
+			c.Conn.Close()
+			return
+		}
+		c.queued += len(bytes)
+		c.sendq <- bytes
+		return
+	}
+	c.sendMutex.Unlock()
+
 	n, err := c.Write(bytes)
 	if err != nil || n != len(bytes) {
-		c.Error = err
-		c.active = false
+		c.fail(err)
 		c.Close()
 	}
 }
 
+// Mark the connection as no longer active because of err.  The first error
+// is kept, since closing the connection makes the others fail too.
+func (c *Conn) fail(err error) {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	c.active = false
+	if c.err == nil {
+		c.err = err
+	}
+}
+
 func (c *Conn) Active() bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
 	return c.active
 }
 
+// Err returns the error which ended the connection, if any.
+func (c *Conn) Err() error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	return c.err
+}
+
 func (c *Conn) Subscribe(chn chan<- *parser.Message) {
 	c.subscribers[chn] = true
 
@@ -2,6 +2,7 @@ package conn
 
 import (
 	"io"
+	"io/ioutil"
 	"net"
 	"testing"
 
@@ -110,3 +111,51 @@ func BenchmarkWriteMessage(b *testing.B) {
 		conn.WriteMessage(msg)
 	}
 }
+
+func TestSendQ(t *testing.T) {
+	msg := &parser.Message{
+		Prefix:  "server",
+		Command: "COMMAND",
+		Args:    []string{"arg1", "arg2", "arg3 arg3"},
+	}
+	line := ":server COMMAND arg1 arg2 :arg3 arg3\r\n"
+
+	// Nothing reads from the other end, so writes pile up
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := NewConn(server)
+	conn.SetSendQ(2 * len(line))
+	closed := make(chan string, 1)
+	conn.SubscribeClose(closed)
+
+	// Make sure the reader is waiting for the next line
+	messages := make(chan *parser.Message)
+	conn.Subscribe(messages)
+	go client.Write([]byte("PING :test\r\n"))
+	<-messages
+
+	for i := 0; i < 4 && conn.Err() == nil; i++ {
+		conn.WriteMessage(msg)
+	}
+	// The reader's error (from the closed connection) must not replace it
+	<-closed
+	if conn.Err() != ErrSendQ {
+		t.Errorf("Err() = %v, want %v", conn.Err(), ErrSendQ)
+	}
+
+	// Queued messages are sent before the connection is closed
+	client, server = net.Pipe()
+	conn = NewConn(server)
+	conn.SetSendQ(10 * len(line))
+	conn.WriteMessage(msg)
+	conn.WriteMessage(msg)
+	// At most one message can have been taken off the queue
+	if got, want := conn.Queued(), len(line); got < want {
+		t.Errorf("Queued() = %d, want at least %d", got, want)
+	}
+	conn.Close()
+	got, err := ioutil.ReadAll(client)
+	if want := line + line; err != nil || string(got) != want {
+		t.Errorf("read %q (err %v), want %q", got, err, want)
+	}
+}
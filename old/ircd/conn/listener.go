@@ -1,15 +1,27 @@
 package conn
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"sync"
+	"time"
 )
 
+// How long a client has to complete a TLS handshake.
+const HandshakeTimeout = 30 * time.Second
+
 type Listener struct {
-	ports    map[int]net.Listener
+	portMutex sync.Mutex
+	ports     map[int]net.Listener // guarded by portMutex
+
 	Incoming chan *Conn
 	wg       sync.WaitGroup
+
+	// If Reject is set, it is called with the IP address of each incoming
+	// connection before a Conn is made for it.  If it returns true, the
+	// client is sent the reason in an ERROR and disconnected.
+	Reject func(ip net.IP) (reason string, reject bool)
 }
 
 func NewListener() *Listener {
@@ -21,14 +33,31 @@ func NewListener() *Listener {
 
 // AddPort starts a new goroutine listening on the given port number.
 // If the port number is already being listened to, nothing happens.
-func (l *Listener) AddPort(portno int) {
+// An error is returned if the port could not be listened on.
+func (l *Listener) AddPort(portno int) error {
+	return l.addPort(portno, nil)
+}
+
+// AddTLSPort is like AddPort, but clients must connect with TLS using the
+// given configuration.  The handshake is completed before the connection is
+// passed on, so that the client's certificate (see Conn.CertFP) is known.
+func (l *Listener) AddTLSPort(portno int, config *tls.Config) error {
+	return l.addPort(portno, config)
+}
+
+func (l *Listener) addPort(portno int, config *tls.Config) error {
+	l.portMutex.Lock()
+	defer l.portMutex.Unlock()
+
 	if _, ok := l.ports[portno]; ok {
-		return
+		return nil
 	}
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", portno))
 	if err != nil {
-		fmt.Sprintf("ErrorMessage[%d]: %s\n", portno, err)
-		return
+		return err
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
 	}
 
 	l.ports[portno] = listener
@@ -47,35 +76,71 @@ func (l *Listener) AddPort(portno int) {
 				break
 			}
 			go func(c net.Conn) {
+				if tc, ok := c.(*tls.Conn); ok {
+					tc.SetDeadline(time.Now().Add(HandshakeTimeout))
+					if err := tc.Handshake(); err != nil {
+						c.Close()
+						return
+					}
+					tc.SetDeadline(time.Time{})
+				}
+				if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok && l.Reject != nil {
+					if reason, reject := l.Reject(addr.IP); reject {
+						fmt.Fprintf(c, "ERROR :Closing Link (%s)\r\n", reason)
+						c.Close()
+						return
+					}
+				}
 				l.Incoming <- NewConn(c)
 			}(conn)
 		}
-		delete(l.ports, portno)
+		// The port may have been closed and reopened in the meantime
+		l.portMutex.Lock()
+		if l.ports[portno] == listener {
+			delete(l.ports, portno)
+		}
+		l.portMutex.Unlock()
 	}()
+	return nil
 }
 
-// ClosePort stops listening on the given port.  If this listener
-// is not listening on the port, nothing happens.
+// ClosePort stops listening on the given port, after which it may be added
+// again.  If this listener is not listening on the port, nothing happens.
 func (l *Listener) ClosePort(portno int) {
+	l.portMutex.Lock()
 	listener, ok := l.ports[portno]
-	if !ok {
-		return
+	if ok {
+		listener.Close()
+		delete(l.ports, portno)
 	}
-	listener.Close()
-	c, _ := net.Dial("tcp", fmt.Sprintf(":%d", portno))
-	if c != nil {
-		c.Close()
+	l.portMutex.Unlock()
+
+	if ok {
+		unblock(portno)
 	}
 }
 
 // Close signals all of the listening ports to stop listening.
 func (l *Listener) Close() {
+	l.portMutex.Lock()
+	closed := make([]int, 0, len(l.ports))
 	for port, listener := range l.ports {
 		listener.Close()
-		c, _ := net.Dial("tcp", fmt.Sprintf(":%d", port))
-		if c != nil {
-			c.Close()
-		}
+		delete(l.ports, port)
+		closed = append(closed, port)
+	}
+	l.portMutex.Unlock()
+
+	for _, port := range closed {
+		unblock(port)
 	}
 	l.wg.Wait()
 }
+
+// Connect to a closed port, in case its goroutine is still blocked in Accept.
+func unblock(portno int) {
+	c, _ := net.Dial("tcp", fmt.Sprintf(":%d", portno))
+	if c != nil {
+		c.Close()
+	}
+}
@@ -1,6 +1,17 @@
 package conn
 
 import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
 	"runtime"
 	"testing"
 	"time"
@@ -18,6 +29,9 @@ func TestNewListener(t *testing.T) {
 }
 
 func TestAddPort(t *testing.T) {
+	// Give the goroutines of earlier tests time to exit before counting
+	time.Sleep(10 * time.Millisecond)
+
 	l := NewListener()
 	gcnt := runtime.NumGoroutine()
 	l.AddPort(56561)
@@ -51,13 +65,13 @@ func TestClosePort(t *testing.T) {
 	l.AddPort(56561)
 	gcnt := runtime.NumGoroutine()
 	l.ClosePort(56561)
-	// ClosePort is not synchronized, so give it some time (on mac, dialog pops up)
-	for i := 0; i < 100 && 0 != len(l.ports); i++ {
-		time.Sleep(1e6)
-	}
-	if runtime.Gosched(); 0 != len(l.ports) {
+	if 0 != len(l.ports) {
 		t.Errorf("After ClosePort(), ports should have 0 entries, got %d", len(l.ports))
 	}
+	// The goroutine exits asynchronously, so give it some time (on mac, dialog pops up)
+	for i := 0; i < 100 && gcnt <= runtime.NumGoroutine(); i++ {
+		time.Sleep(1e6)
+	}
 	if runtime.Gosched(); gcnt <= runtime.NumGoroutine() {
 		t.Errorf("Expected fewer than %d goroutines after ClosePort(), %d running", gcnt,
 			runtime.NumGoroutine())
@@ -71,3 +85,107 @@ func TestClosePort(t *testing.T) {
 			runtime.NumGoroutine())
 	}
 }
+
+func TestReopenPort(t *testing.T) {
+	l := NewListener()
+	defer l.Close()
+	if err := l.AddPort(56564); err != nil {
+		t.Fatalf("AddPort: %s", err)
+	}
+	l.ClosePort(56564)
+	if err := l.AddPort(56564); err != nil {
+		t.Fatalf("AddPort after ClosePort: %s", err)
+	}
+	c, err := net.Dial("tcp", "127.0.0.1:56564")
+	if err != nil {
+		t.Fatalf("Dial reopened port: %s", err)
+	}
+	defer c.Close()
+	select {
+	case conn := <-l.Incoming:
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Errorf("no connection from the reopened port")
+	}
+
+	// The port is in use by the first listener
+	other := NewListener()
+	defer other.Close()
+	if err := other.AddPort(56564); err == nil {
+		t.Errorf("AddPort of a port in use succeeded")
+	}
+}
+
+func TestReject(t *testing.T) {
+	l := NewListener()
+	defer l.Close()
+	l.Reject = func(ip net.IP) (string, bool) {
+		return "D-lined", ip.IsLoopback()
+	}
+	l.AddPort(56562)
+
+	c, err := net.Dial("tcp", "127.0.0.1:56562")
+	if err != nil {
+		t.Fatalf("Dial: %s", err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if got, want := line, "ERROR :Closing Link (D-lined)\r\n"; err != nil || got != want {
+		t.Errorf("rejected client got %q (err %v), want %q", got, err, want)
+	}
+	select {
+	case <-l.Incoming:
+		t.Errorf("rejected connection was passed on")
+	default:
+	}
+}
+
+// Make a self-signed certificate for testing.
+func testCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestAddTLSPort(t *testing.T) {
+	l := NewListener()
+	defer l.Close()
+	l.AddTLSPort(56563, &tls.Config{
+		Certificates: []tls.Certificate{testCert(t)},
+		ClientAuth:   tls.RequestClientCert,
+	})
+
+	client := testCert(t)
+	c, err := tls.Dial("tcp", "127.0.0.1:56563", &tls.Config{
+		Certificates:       []tls.Certificate{client},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("Dial: %s", err)
+	}
+	defer c.Close()
+
+	select {
+	case conn := <-l.Incoming:
+		defer conn.Close()
+		sum := sha256.Sum256(client.Certificate[0])
+		if got, want := conn.CertFP(), hex.EncodeToString(sum[:]); got != want {
+			t.Errorf("CertFP() = %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("TLS connection was not passed on")
+	}
+}
@@ -0,0 +1,368 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/ban"
+	"github.com/kylelemons/ircd-blight/old/ircd/log"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/server"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+var (
+	banhooks = []*Hook{
+		Register(parser.CMD_KLINE, User, OptArgs(1, 2), Privileged(PrivKline, SetBan)),
+		Register(parser.CMD_DLINE, User, OptArgs(1, 2), Privileged(PrivKline, SetBan)),
+		Register(parser.CMD_XLINE, User, OptArgs(1, 2), Privileged(PrivKline, SetBan)),
+		Register(parser.CMD_UNKLINE, User, NArgs(1), Privileged(PrivKline, Unban)),
+		Register(parser.CMD_UNDLINE, User, NArgs(1), Privileged(PrivKline, Unban)),
+		Register(parser.CMD_UNXLINE, User, NArgs(1), Privileged(PrivKline, Unban)),
+		Register(parser.CMD_BAN, Server, NArgs(8), SBan),
+	}
+
+	// The kind of ban set or removed by each command
+	banKinds = map[string]byte{
+		parser.CMD_KLINE:   ban.KLine,
+		parser.CMD_DLINE:   ban.DLine,
+		parser.CMD_XLINE:   ban.XLine,
+		parser.CMD_UNKLINE: ban.KLine,
+		parser.CMD_UNDLINE: ban.DLine,
+		parser.CMD_UNXLINE: ban.XLine,
+	}
+)
+
+// Get the message a banned user is disconnected with.
+func banMessage(b *ban.Ban) string {
+	return "Banned (" + b.Reason + ")"
+}
+
+// Split a ban reason of the form "<reason>|<oper reason>".
+func splitReason(reason string) (public, oper string) {
+	if bar := strings.Index(reason, "|"); bar >= 0 {
+		reason, oper = reason[:bar], strings.TrimSpace(reason[bar+1:])
+	}
+	public = strings.TrimSpace(reason)
+	if len(public) == 0 {
+		public = "No reason given"
+	}
+	return
+}
+
+// Join a ban's reasons as they are sent between servers.
+func joinReason(b *ban.Ban) string {
+	if len(b.OperReason) == 0 {
+		return b.Reason
+	}
+	return b.Reason + "|" + b.OperReason
+}
+
+// Describe a ban for a server notice.
+func describeBan(b *ban.Ban) string {
+	desc := b.Type() + " for [" + b.Mask + "] [" + b.Reason + "]"
+	if len(b.OperReason) > 0 {
+		desc += " (" + b.OperReason + ")"
+	}
+	if b.Expires > 0 {
+		mins := (b.Remaining(time.Now()) + time.Minute - 1) / time.Minute
+		desc = "temporary " + strconv.FormatInt(int64(mins), 10) + " min. " + desc
+	}
+	return desc
+}
+
+// Get the name used as the setter of a ban: the nick of a user or the ID of
+// a server.
+func banSetter(id string) string {
+	if u, ok := user.Lookup(id); ok {
+		return u.Nick()
+	}
+	return id
+}
+
+// Save the bans, if there is a ban file.
+func saveBans() {
+	conf := Config()
+	if len(conf.Bans) == 0 {
+		return
+	}
+	if err := ban.Save(conf.Bans); err != nil {
+		log.Error.Printf("Could not save bans: %s", err)
+	}
+}
+
+// Send a NOTICE to a local user.
+func notice(uid, text string) *parser.Message {
+	return &parser.Message{
+		Command: parser.CMD_NOTICE,
+		Args: []string{
+			"*",
+			text,
+		},
+		DestIDs: []string{uid},
+	}
+}
+
+// Check whether a local user is banned from the server.
+func checkBans(u *user.User) (*ban.Ban, bool) {
+	_, username, realname, _ := u.Info()
+	for _, kind := range []byte{ban.DLine, ban.KLine, ban.XLine} {
+		for _, b := range ban.List(kind) {
+			if b.Matches(username, u.Host(), u.IP(), realname) {
+				return b, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Disconnect a banned local user.
+func rejectBanned(u *user.User, b *ban.Ban, ircd *IRCd) {
+	uid := u.ID()
+	log.Info.Printf("[%s] Banned by %s %s", uid, b.Type(), b.Mask)
+	reply := parser.NewNumeric(parser.ERR_YOUREBANNEDCREEP).Message(uid)
+	reply.Args[len(reply.Args)-1] = "You are banned from this server: " + b.Reason
+	ircd.ToClient <- reply
+	if u.Type() == user.RegisteredAsUser {
+		serverNotice(SnoBan, b.Type()+" active for "+u.Hostmask(), ircd)
+		exitUser(uid, banMessage(b), banMessage(b), "", ircd)
+		return
+	}
+	ircd.ToClient <- &parser.Message{
+		Command: parser.CMD_ERROR,
+		Args: []string{
+			"Closing Link (" + banMessage(b) + ")",
+		},
+		DestIDs: []string{uid},
+	}
+}
+
+// Add a ban, tell the local opers, and disconnect the local users it
+// matches.
+func addBan(b *ban.Ban, ircd *IRCd) {
+	ban.Add(b)
+	saveBans()
+	log.Info.Printf("%s added %s %s (%s)", b.Setter, b.Type(), b.Mask, b.Reason)
+	serverNotice(SnoBan, b.Setter+" added "+describeBan(b), ircd)
+
+	for uid := range user.Iter() {
+		if uid[:3] != Config().SID {
+			continue
+		}
+		u, ok := user.Lookup(uid)
+		if !ok {
+			continue
+		}
+		_, username, realname, _ := u.Info()
+		if b.Matches(username, u.Host(), u.IP(), realname) {
+			rejectBanned(u, b, ircd)
+		}
+	}
+}
+
+// Remove a ban and tell the local opers.  If there is no such ban, ok is
+// false.
+func removeBan(kind byte, mask, setter string, ircd *IRCd) (b *ban.Ban, ok bool) {
+	b, ok = ban.Remove(kind, mask)
+	if !ok {
+		return nil, false
+	}
+	saveBans()
+	log.Info.Printf("%s removed %s %s", setter, b.Type(), b.Mask)
+	serverNotice(SnoBan, setter+" has removed the "+b.Type()+" for ["+b.Mask+"]", ircd)
+	return b, true
+}
+
+// Get the mask of a ban as it is sent between servers: K-lines are split
+// into the user and the host.
+func banMask(b *ban.Ban) []string {
+	if b.Kind == ban.KLine {
+		at := strings.Index(b.Mask, "@")
+		return []string{b.Mask[:at], b.Mask[at+1:]}
+	}
+	return []string{b.Mask}
+}
+
+// Send a ban (or, if remove is true, its removal) to every server in an
+// ENCAP from the given user.
+func sendBan(prefix string, b *ban.Ban, remove bool, ircd *IRCd) {
+	var args []string
+	if remove {
+		args = append([]string{"*", "UN" + string(b.Kind) + "LINE"}, banMask(b)...)
+	} else {
+		duration := int64(0)
+		if b.Expires > 0 {
+			duration = b.Expires - b.Set
+		}
+		args = []string{"*", string(b.Kind) + "LINE", strconv.FormatInt(duration, 10)}
+		args = append(args, banMask(b)...)
+		if b.Kind == ban.XLine {
+			args = append(args, "2") // the charybdis X-line type (reject)
+		}
+		args = append(args, joinReason(b))
+	}
+
+	for sid := range server.Iter() {
+		ircd.ToServer <- &parser.Message{
+			Prefix:  prefix,
+			Command: parser.CMD_ENCAP,
+			Args:    args,
+			DestIDs: []string{sid},
+		}
+	}
+}
+
+// KLINE [<minutes>] <user@host|nick> [:<reason>[|<oper reason>]]
+// DLINE [<minutes>] <ip|cidr> [:<reason>[|<oper reason>]]
+// XLINE [<minutes>] <realname glob|/regexp/> [:<reason>[|<oper reason>]]
+func SetBan(hook string, msg *parser.Message, ircd *IRCd) {
+	uid, kind, args := msg.SenderID, banKinds[hook], msg.Args
+
+	duration := time.Duration(0)
+	if len(args) > 1 {
+		if mins, err := strconv.Atoi(args[0]); err == nil && mins >= 0 {
+			duration, args = time.Duration(mins)*time.Minute, args[1:]
+		}
+	}
+	mask, reason := args[0], ""
+	if len(args) > 1 {
+		reason = args[1]
+	}
+	public, oper := splitReason(reason)
+
+	// A K-line for a nick bans their host
+	if kind == ban.KLine && !strings.Contains(mask, "@") {
+		if target, err := user.GetID(mask); err == nil {
+			if u, ok := user.Lookup(target); ok {
+				mask = "*@" + u.Host()
+			}
+		}
+	}
+
+	b, err := ban.New(kind, mask, duration, banSetter(uid), public, oper)
+	if err != nil {
+		ircd.ToClient <- notice(uid, "Invalid "+hook+": "+err.Error())
+		return
+	}
+	ircd.ToClient <- notice(uid, "Added "+describeBan(b))
+	addBan(b, ircd)
+	sendBan(uid, b, false, ircd)
+}
+
+// UNKLINE <user@host>
+// UNDLINE <ip|cidr>
+// UNXLINE <realname glob|/regexp/>
+func Unban(hook string, msg *parser.Message, ircd *IRCd) {
+	uid, kind, mask := msg.SenderID, banKinds[hook], msg.Args[0]
+	b, ok := removeBan(kind, mask, banSetter(uid), ircd)
+	if !ok {
+		ircd.ToClient <- notice(uid, "No "+string(kind)+"-line for ["+mask+"]")
+		return
+	}
+	ircd.ToClient <- notice(uid, b.Type()+" for ["+b.Mask+"] is removed")
+	sendBan(uid, b, true, ircd)
+}
+
+// Server ENCAP * KLINE <seconds> <user> <host> :<reason>
+// Server ENCAP * DLINE <seconds> <ip> :<reason>
+// Server ENCAP * XLINE <seconds> <realname> <type> :<reason>
+// Server ENCAP * UNKLINE <user> <host>
+// Server ENCAP * UNDLINE <ip>
+// Server ENCAP * UNXLINE <realname>
+//
+// The arguments are those after the subcommand.  Encap has already passed
+// the ban on, whether or not it is valid here.
+func encapBan(subcommand string, args []string, msg *parser.Message, ircd *IRCd) {
+	setter := banSetter(msg.Prefix)
+	if strings.HasPrefix(subcommand, "UN") {
+		kind := banKinds[subcommand]
+		if kind == ban.KLine && len(args) >= 2 {
+			removeBan(kind, args[0]+"@"+args[1], setter, ircd)
+		} else if kind != ban.KLine && len(args) >= 1 {
+			removeBan(kind, args[0], setter, ircd)
+		}
+		return
+	}
+
+	want := map[string]int{
+		parser.CMD_KLINE: 4,
+		parser.CMD_DLINE: 3,
+		parser.CMD_XLINE: 4,
+	}[subcommand]
+	if len(args) < want {
+		log.Warn.Printf("Ignoring short ENCAP %s from %s", subcommand, msg.SenderID)
+		return
+	}
+	secs, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		log.Warn.Printf("Ignoring ENCAP %s from %s: bad duration %q", subcommand, msg.SenderID, args[0])
+		return
+	}
+	mask := args[1]
+	if subcommand == parser.CMD_KLINE {
+		mask = args[1] + "@" + args[2]
+	}
+	public, oper := splitReason(args[want-1])
+
+	b, err := ban.New(banKinds[subcommand], mask, time.Duration(secs)*time.Second, setter, public, oper)
+	if err != nil {
+		log.Warn.Printf("Ignoring ENCAP %s from %s: %s", subcommand, msg.SenderID, err)
+		return
+	}
+	addBan(b, ircd)
+}
+
+// Server BAN <K|X> <user|*> <host|realname> <created> <duration> <lifetime> <oper> :<reason>
+//
+// A duration of 0 removes the ban.
+func SBan(hook string, msg *parser.Message, ircd *IRCd) {
+	// Forward
+	for sid := range server.Iter() {
+		if sid != msg.SenderID {
+			fmsg := msg.Dup()
+			fmsg.DestIDs = []string{sid}
+			ircd.ToServer <- fmsg
+		}
+	}
+
+	typ, mask := msg.Args[0], msg.Args[2]
+	created, err1 := strconv.ParseInt(msg.Args[3], 10, 64)
+	duration, err2 := strconv.ParseInt(msg.Args[4], 10, 64)
+	if err1 != nil || err2 != nil {
+		log.Warn.Printf("Ignoring BAN from %s: bad times", msg.SenderID)
+		return
+	}
+
+	var kind byte
+	switch typ {
+	case "K":
+		kind, mask = ban.KLine, msg.Args[1]+"@"+msg.Args[2]
+	case "X":
+		kind = ban.XLine
+	default:
+		log.Debug.Printf("Ignoring BAN %s from %s", typ, msg.SenderID)
+		return
+	}
+
+	setter := msg.Args[6]
+	if setter == "*" {
+		setter = banSetter(msg.Prefix)
+	}
+	if duration == 0 {
+		removeBan(kind, mask, setter, ircd)
+		return
+	}
+
+	public, oper := splitReason(msg.Args[7])
+	b, err := ban.New(kind, mask, 0, setter, public, oper)
+	if err != nil {
+		log.Warn.Printf("Ignoring BAN from %s: %s", msg.SenderID, err)
+		return
+	}
+	b.Set, b.Expires = created, created+duration
+	if b.Expired(time.Now()) {
+		return
+	}
+	addBan(b, ircd)
+}
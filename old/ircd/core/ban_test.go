@@ -0,0 +1,82 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/ban"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+var splitReasonTests = []struct {
+	Reason       string
+	Public, Oper string
+}{
+	{"", "No reason given", ""},
+	{"spamming", "spamming", ""},
+	{"spamming | see ticket 12", "spamming", "see ticket 12"},
+	{"|drones", "No reason given", "drones"},
+}
+
+func TestSplitReason(t *testing.T) {
+	for _, test := range splitReasonTests {
+		public, oper := splitReason(test.Reason)
+		if public != test.Public || oper != test.Oper {
+			t.Errorf("splitReason(%q) = %q, %q; want %q, %q", test.Reason, public, oper, test.Public, test.Oper)
+		}
+	}
+}
+
+func TestBanCommands(t *testing.T) {
+	SetConfig(&Configuration{SID: "000"})
+	defer SetConfig(nil)
+	reset := func() { ban.LoadFrom(strings.NewReader("")) }
+	reset()
+	defer reset()
+
+	victim := user.Get("000AAABN1")
+	defer user.Delete("000AAABN1")
+	victim.SetNick("spammer")
+	victim.SetUser("spam", "Free Money")
+	victim.SetHost("bad.example.com", "10.9.8.7")
+	victim.SetType(user.RegisteredAsUser)
+
+	run := func(fn func(string, *parser.Message, *IRCd), cmd string, args ...string) (cmds []string) {
+		for _, msg := range runHook(fn, cmd, "000AAABN2", args...) {
+			cmds = append(cmds, msg.Command)
+		}
+		return
+	}
+
+	if got := run(SetBan, parser.CMD_KLINE, "nohost"); len(got) != 1 || len(ban.List(ban.KLine)) != 0 {
+		t.Errorf("invalid KLINE: got %v, want one NOTICE and no ban", got)
+	}
+
+	got := strings.Join(run(SetBan, parser.CMD_KLINE, "60", "spammer", "go away|drone"), " ")
+	if want := "NOTICE 465 ERROR"; got != want {
+		t.Errorf("KLINE spammer: got %q, want %q", got, want)
+	}
+	list := ban.List(ban.KLine)
+	if len(list) != 1 || list[0].Mask != "*@bad.example.com" || list[0].OperReason != "drone" || list[0].Expires == 0 {
+		t.Fatalf("K-lines = %+v, want a temporary *@bad.example.com", list)
+	}
+
+	run(Unban, parser.CMD_UNKLINE, "*@BAD.example.com")
+	if list := ban.List(ban.KLine); len(list) != 0 {
+		t.Errorf("after UNKLINE: K-lines = %+v, want none", list)
+	}
+
+	// Bans from other servers apply too
+	ircd := &IRCd{
+		ToClient: make(chan *parser.Message, 20),
+		ToServer: make(chan *parser.Message, 20),
+	}
+	encapBan(parser.CMD_XLINE, []string{"0", "*free money*", "2", "spam"}, &parser.Message{
+		SenderID: "123",
+		Prefix:   "123AAAAAA",
+	}, ircd)
+	if _, ok := checkBans(victim); !ok {
+		t.Errorf("ENCAP XLINE was not applied")
+	}
+}
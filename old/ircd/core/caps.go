@@ -0,0 +1,222 @@
+package core
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/log"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+var (
+	caphooks = []*Hook{
+		Register(parser.CMD_CAP, Registration|User, OptArgs(1, 1), Cap),
+	}
+	capnotify = Capability("cap-notify", nil)
+)
+
+var (
+	capMutex     = new(sync.RWMutex)
+	capabilities = map[string]func() string{}
+)
+
+// Capability registers an IRCv3 capability to be offered in CAP LS.  If
+// value is non-nil, it is called each time the capability is listed to
+// compute the value shown to CAP LS 302 clients.  The name is returned so
+// that it can be registered in a var block.
+func Capability(name string, value func() string) string {
+	capMutex.Lock()
+	defer capMutex.Unlock()
+	capabilities[name] = value
+	return name
+}
+
+// Get whether a capability is currently offered.
+func capAvailable(name string) bool {
+	capMutex.RLock()
+	defer capMutex.RUnlock()
+	_, ok := capabilities[name]
+	return ok
+}
+
+// Get the capabilities offered in CAP LS in sorted order.  For version 302
+// and later, values are included (e.g. "sasl=PLAIN").
+func capList(version int) []string {
+	capMutex.RLock()
+	defer capMutex.RUnlock()
+	caps := make([]string, 0, len(capabilities))
+	for name, value := range capabilities {
+		caps = append(caps, capEntry(name, value, version))
+	}
+	sort.Strings(caps)
+	return caps
+}
+
+// Get the CAP LS entry for a capability.
+func capEntry(name string, value func() string, version int) string {
+	if value != nil && version >= 302 {
+		if v := value(); len(v) > 0 {
+			return name + "=" + v
+		}
+	}
+	return name
+}
+
+// Start offering a capability after startup, and tell the clients with
+// cap-notify about it.
+func capNew(name string, value func() string, ircd *IRCd) {
+	Capability(name, value)
+	for _, uid := range capNotifyUsers() {
+		if u, ok := user.Lookup(uid); ok {
+			entry := capEntry(name, value, u.CapVersion())
+			for _, reply := range capReplies("NEW", []string{entry}, uid, false) {
+				ircd.ToClient <- reply
+			}
+		}
+	}
+}
+
+// Stop offering a capability, disable it for the clients that had enabled
+// it, and tell the clients with cap-notify about it.
+func capDel(name string, ircd *IRCd) {
+	capMutex.Lock()
+	delete(capabilities, name)
+	capMutex.Unlock()
+
+	for uid := range user.Iter() {
+		if u, ok := user.Lookup(uid); ok && uid[:3] == Config().SID {
+			u.SetCap(name, false)
+		}
+	}
+	for _, uid := range capNotifyUsers() {
+		for _, reply := range capReplies("DEL", []string{name}, uid, false) {
+			ircd.ToClient <- reply
+		}
+	}
+}
+
+// Get the local users who have enabled cap-notify.
+func capNotifyUsers() []string {
+	uids := []string{}
+	for uid := range user.Iter() {
+		if u, ok := user.Lookup(uid); ok && uid[:3] == Config().SID && u.HasCap(capnotify) {
+			uids = append(uids, uid)
+		}
+	}
+	return uids
+}
+
+// Construct the CAP replies listing the given capabilities.  If multiline
+// is set, long lists are split with "*" marking all but the last line
+// (CAP LS 302); otherwise everything is sent on one line.
+func capReplies(subcommand string, caps []string, destID string, multiline bool) []*parser.Message {
+	const maxlen = 400
+
+	lines := []string{}
+	line := ""
+	for _, c := range caps {
+		if multiline && len(line) > 0 && len(line)+1+len(c) > maxlen {
+			lines = append(lines, line)
+			line = ""
+		}
+		if len(line) > 0 {
+			line += " "
+		}
+		line += c
+	}
+	lines = append(lines, line)
+
+	replies := make([]*parser.Message, 0, len(lines))
+	for i, line := range lines {
+		args := []string{"*", subcommand}
+		if i < len(lines)-1 {
+			args = append(args, "*")
+		}
+		replies = append(replies, &parser.Message{
+			Command: parser.CMD_CAP,
+			Args:    append(args, line),
+			DestIDs: []string{destID},
+		})
+	}
+	return replies
+}
+
+// CAP LS [<version>]
+// CAP LIST
+// CAP REQ :<capabilities>
+// CAP END
+func Cap(hook string, msg *parser.Message, ircd *IRCd) {
+	u, ok := user.Lookup(msg.SenderID)
+	if !ok {
+		log.Warn.Printf("CAP from unknown user %s", msg.SenderID)
+		return
+	}
+	registering := u.Type() == user.Unregistered
+
+	switch subcommand := strings.ToUpper(msg.Args[0]); subcommand {
+	case "LS":
+		version := 0
+		if len(msg.Args) > 1 {
+			version, _ = strconv.Atoi(msg.Args[1])
+		}
+		u.SetCapVersion(version)
+		if u.CapVersion() >= 302 {
+			// cap-notify is implied by version 302
+			u.SetCap(capnotify, true)
+		}
+		for _, reply := range capReplies(subcommand, capList(u.CapVersion()), u.ID(), u.CapVersion() >= 302) {
+			ircd.ToClient <- reply
+		}
+	case "LIST":
+		for _, reply := range capReplies(subcommand, u.Caps(), u.ID(), u.CapVersion() >= 302) {
+			ircd.ToClient <- reply
+		}
+	case "REQ":
+		requested := ""
+		if len(msg.Args) > 1 {
+			requested = msg.Args[1]
+		}
+
+		// The request is accepted or rejected as a whole
+		changes := strings.Fields(requested)
+		for _, change := range changes {
+			if !capAvailable(strings.TrimPrefix(change, "-")) {
+				ircd.ToClient <- &parser.Message{
+					Command: parser.CMD_CAP,
+					Args:    []string{"*", "NAK", requested},
+					DestIDs: []string{u.ID()},
+				}
+				return
+			}
+		}
+		for _, change := range changes {
+			if strings.HasPrefix(change, "-") {
+				u.SetCap(change[1:], false)
+			} else {
+				u.SetCap(change, true)
+			}
+		}
+		ircd.ToClient <- &parser.Message{
+			Command: parser.CMD_CAP,
+			Args:    []string{"*", "ACK", requested},
+			DestIDs: []string{u.ID()},
+		}
+	case "END":
+		if registering {
+			u.SetNegotiating(false)
+			registerUser(u, ircd)
+		}
+	default:
+		ircd.ToClient <- parser.NewNumeric(parser.ERR_INVALIDCAPCMD, msg.Args[0]).Message(u.ID())
+	}
+}
+
+// Suspend registration of a new client while it negotiates capabilities.
+// This is called before any of its messages are dispatched, so that
+// NICK and USER cannot complete registration in the meantime.
+func beginNegotiation(uid string) {
+	user.Get(uid).SetNegotiating(true)
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+func TestCapReplies(t *testing.T) {
+	caps := []string{}
+	for i := 0; i < 100; i++ {
+		caps = append(caps, "example.org/capability")
+	}
+
+	if got, want := len(capReplies("LS", caps, "000AAACAP", false)), 1; got != want {
+		t.Errorf("single line: got %d replies, want %d", got, want)
+	}
+
+	replies := capReplies("LS", caps, "000AAACAP", true)
+	if len(replies) < 2 {
+		t.Fatalf("multiline: got %d replies, want more than 1", len(replies))
+	}
+	for i, reply := range replies {
+		last := i == len(replies)-1
+		if got, want := len(reply.Args) == 3, last; got != want {
+			t.Errorf("reply %d: args = %q, want continuation marker only before the last", i, reply.Args)
+		}
+	}
+}
+
+var capReqTests = []struct {
+	Request string
+	Reply   string
+	Caps    string
+}{
+	{"multi-prefix bogus", "NAK", ""},
+	{"multi-prefix cap-notify", "ACK", "cap-notify multi-prefix"},
+	{"-cap-notify", "ACK", "multi-prefix"},
+}
+
+func TestCapReq(t *testing.T) {
+	ircd := &IRCd{ToClient: make(chan *parser.Message, 10)}
+	u := user.Get("000AAACAP")
+	defer user.Delete("000AAACAP")
+
+	for idx, test := range capReqTests {
+		Cap(parser.CMD_CAP, &parser.Message{
+			SenderID: "000AAACAP",
+			Command:  parser.CMD_CAP,
+			Args:     []string{"REQ", test.Request},
+		}, ircd)
+
+		reply := <-ircd.ToClient
+		if got, want := reply.Args[1], test.Reply; got != want {
+			t.Errorf("#%d: REQ %q got %s, want %s", idx, test.Request, got, want)
+		}
+		if got, want := strings.Join(u.Caps(), " "), test.Caps; got != want {
+			t.Errorf("#%d: caps after REQ %q = %q, want %q", idx, test.Request, got, want)
+		}
+	}
+	// Registration is suspended before CAP is dispatched, so a REQ handled
+	// after END must not suspend it again
+	if u.Negotiating() {
+		t.Errorf("registration suspended by CAP REQ")
+	}
+}
+
+func TestSASLCapNotify(t *testing.T) {
+	ircd := &IRCd{ToClient: make(chan *parser.Message, 10)}
+	u := user.Get("000AAACAP")
+	defer user.Delete("000AAACAP")
+	u.SetCap(capnotify, true)
+	u.SetCap(saslcap, true)
+
+	SetConfig(&Configuration{SID: "000"})
+	defer SetConfig(nil)
+	defer Capability(saslcap, saslValue)
+
+	// Without an accounts file, there is nothing to log in to
+	updateSASLCap(Config(), ircd)
+	if reply := <-ircd.ToClient; reply.Args[1] != "DEL" || reply.Args[2] != saslcap {
+		t.Errorf("accounts unloaded: got %q, want CAP DEL sasl", reply.Args)
+	}
+	if u.HasCap(saslcap) || capAvailable(saslcap) {
+		t.Errorf("sasl still enabled after CAP DEL")
+	}
+
+	SetConfig(&Configuration{SID: "000", Accounts: "accounts"})
+	updateSASLCap(Config(), ircd)
+	if reply := <-ircd.ToClient; reply.Args[1] != "NEW" || reply.Args[2] != saslcap {
+		t.Errorf("accounts loaded: got %q, want CAP NEW sasl", reply.Args)
+	}
+	if !capAvailable(saslcap) {
+		t.Errorf("sasl not offered after CAP NEW")
+	}
+}
@@ -1,11 +1,15 @@
 package core
 
 import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
 	"github.com/kylelemons/ircd-blight/old/ircd/channel"
+	"github.com/kylelemons/ircd-blight/old/ircd/mode"
 	"github.com/kylelemons/ircd-blight/old/ircd/parser"
-
-	//"github.com/kylelemons/ircd-blight/old/ircd/user"
-	"strings"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
 
 	"github.com/kylelemons/ircd-blight/old/ircd/log"
 	"github.com/kylelemons/ircd-blight/old/ircd/server"
@@ -19,11 +23,43 @@ var (
 		Register(parser.CMD_PART, User, OptArgs(1, 1), Part),
 		Register(parser.CMD_PART, Server, NArgs(2), SPart),
 	}
+	topichooks = []*Hook{
+		Register(parser.CMD_TOPIC, User, OptArgs(1, 1), Topic),
+		Register(parser.CMD_TOPIC, Server, NArgs(2), STopic),
+		Register(parser.CMD_TB, Server, OptArgs(3, 1), TB),
+	}
+	nameshooks = []*Hook{
+		Register(parser.CMD_NAMES, User, OptArgs(0, 1), Names),
+	}
+	kickhooks = []*Hook{
+		Register(parser.CMD_KICK, User, OptArgs(2, 1), Kick),
+		Register(parser.CMD_KICK, Server, OptArgs(2, 1), SKick),
+	}
+	listhooks = []*Hook{
+		Register(parser.CMD_LIST, User, OptArgs(0, 2), List),
+	}
+	listsupport = []string{
+		Support("ELIST", func() string { return "CMNTU" }),
+		Support("SAFELIST", nil),
+	}
+	multiprefix     = Capability("multi-prefix", nil)
+	userhostinnames = Capability("userhost-in-names", nil)
+	extendedjoin    = Capability("extended-join", nil)
+)
+
+var (
+	// LIST replies are sent in chunks of ListChunk, and the next chunk is
+	// held back while more than ListBacklog bytes are waiting to be sent to
+	// the client.
+	ListChunk   = 25
+	ListBacklog = 4096
+	ListDelay   = 50 * time.Millisecond
 )
 
 // Local joins only
 func Join(hook string, msg *parser.Message, ircd *IRCd) {
 	// todo keys
+	conf := Config()
 	for _, channame := range strings.Split(msg.Args[0], ",") {
 		channel, err := channel.Get(channame, true)
 		if num, ok := err.(*parser.Numeric); ok {
@@ -39,14 +75,21 @@ func Join(hook string, msg *parser.Message, ircd *IRCd) {
 
 		notify := []string{}
 		for _, uid := range members {
-			if uid[:3] == Config.SID {
+			if uid[:3] == conf.SID {
 				notify = append(notify, uid)
 			}
 		}
 
+		// The first user on a channel founds it
+		founded := len(members) == 1
+		if founded {
+			changes, _ := mode.ChannelModes.ParseModeChange([]string{"+nto", msg.SenderID})
+			channel.ApplyModes(changes)
+		}
+
 		// Forward to other servers
 		for sid := range server.Iter() {
-			ircd.ToServer <- &parser.Message{
+			fwd := &parser.Message{
 				Prefix:  msg.SenderID,
 				Command: parser.CMD_JOIN,
 				Args: []string{
@@ -56,20 +99,28 @@ func Join(hook string, msg *parser.Message, ircd *IRCd) {
 				},
 				DestIDs: []string{sid},
 			}
+			if founded {
+				fwd.Prefix = conf.SID
+				fwd.Command = parser.CMD_SJOIN
+				fwd.Args = append([]string{channel.TS(), channel.Name()}, channel.Modes()...)
+				fwd.Args = append(fwd.Args, strings.Join(channel.UserIDsWithPrefix(), " "))
+			}
+			ircd.ToServer <- fwd
 		}
 
-		if len(notify) > 0 {
-			ircd.ToClient <- &parser.Message{
-				Prefix:  msg.SenderID,
-				Command: parser.CMD_JOIN,
-				Args: []string{
-					channel.Name(),
-				},
-				DestIDs: notify,
+		for _, join := range joinMessages(channel, msg.SenderID, notify) {
+			ircd.ToClient <- join
+		}
+
+		if topic, _, _ := channel.Topic(); len(topic) > 0 {
+			for _, reply := range topicReplies(channel, msg.SenderID) {
+				ircd.ToClient <- reply
 			}
 		}
 
-		ircd.ToClient <- channel.NamesMessage(msg.SenderID)
+		for _, reply := range namesReplies(channel, msg.SenderID) {
+			ircd.ToClient <- reply
+		}
 	}
 }
 
@@ -97,7 +148,7 @@ func Part(hook string, msg *parser.Message, ircd *IRCd) {
 
 		notify := []string{}
 		for _, uid := range members {
-			if uid[:3] == Config.SID {
+			if uid[:3] == Config().SID {
 				notify = append(notify, uid)
 			}
 		}
@@ -135,18 +186,19 @@ func Part(hook string, msg *parser.Message, ircd *IRCd) {
 
 // Server JOIN and SJOIN
 func SJoin(hook string, msg *parser.Message, ircd *IRCd) {
-	chanTS, channame, mode := msg.Args[0], msg.Args[1], msg.Args[2]
+	chanTS, channame, modes := msg.Args[0], msg.Args[1], msg.Args[2:]
 
 	uids := []string{msg.Prefix}
 	if len(msg.Prefix) == 3 {
 		if len(msg.Args) == 3 {
 			return
 		}
-		uids = strings.Split(msg.Args[3], " ")
+		// SJOIN <ts> <channel> <modes> [<params>...] :<members>
+		modes = msg.Args[2 : len(msg.Args)-1]
+		uids = strings.Split(msg.Args[len(msg.Args)-1], " ")
 	}
 
 	_ = chanTS
-	_ = mode
 
 	// Forward on to other servers
 	for fwd := range server.Iter() {
@@ -157,7 +209,16 @@ func SJoin(hook string, msg *parser.Message, ircd *IRCd) {
 		}
 	}
 
+	// Members may be prefixed with their status (e.g. "@+")
+	status := []string{}
 	for i, uid := range uids {
+		for _, prefix := range []byte(uid[:len(uid)-9]) {
+			for _, spec := range mode.ChannelModes.StatusModes() {
+				if spec.Prefix()[0] == prefix {
+					status = append(status, "+"+string(spec.Char()), uid[len(uid)-9:])
+				}
+			}
+		}
 		uids[i] = uid[len(uid)-9:]
 	}
 
@@ -177,22 +238,24 @@ func SJoin(hook string, msg *parser.Message, ircd *IRCd) {
 		return
 	}
 
+	changes, _ := mode.ChannelModes.ParseModeChange(modes)
+	for i := 0; i < len(status); i += 2 {
+		change, _ := mode.ChannelModes.ParseModeChange(status[i : i+2])
+		changes = append(changes, change...)
+	}
+	channel.ApplyModes(changes)
+
 	notify := []string{}
 	for _, uid := range chanusers {
-		if uid[:3] == Config.SID {
+		if uid[:3] == Config().SID {
 			notify = append(notify, uid)
 		}
 	}
 
 	if len(notify) > 0 {
 		for _, joiner := range uids {
-			ircd.ToClient <- &parser.Message{
-				Prefix:  joiner,
-				Command: parser.CMD_JOIN,
-				Args: []string{
-					channel.Name(),
-				},
-				DestIDs: notify,
+			for _, join := range joinMessages(channel, joiner, notify) {
+				ircd.ToClient <- join
 			}
 		}
 	}
@@ -226,7 +289,7 @@ func SPart(hook string, msg *parser.Message, ircd *IRCd) {
 
 		notify := []string{}
 		for _, uid := range chanusers {
-			if uid[:3] == Config.SID {
+			if uid[:3] == Config().SID {
 				notify = append(notify, uid)
 			}
 		}
@@ -244,3 +307,475 @@ func SPart(hook string, msg *parser.Message, ircd *IRCd) {
 		}
 	}
 }
+
+// Local TOPIC queries and changes
+func Topic(hook string, msg *parser.Message, ircd *IRCd) {
+	channel, err := channel.Get(msg.Args[0], false)
+	if num, ok := err.(*parser.Numeric); ok {
+		ircd.ToClient <- num.Message(msg.SenderID)
+		return
+	}
+
+	// TOPIC <channel>
+	if len(msg.Args) == 1 {
+		if channel.HasMode('s') && !channel.OnChan(msg.SenderID) && !hasPrivilege(msg.SenderID, PrivAuspex) {
+			ircd.ToClient <- parser.NewNumeric(parser.ERR_NOTONCHANNEL, channel.Name()).Message(msg.SenderID)
+			return
+		}
+		for _, reply := range topicReplies(channel, msg.SenderID) {
+			ircd.ToClient <- reply
+		}
+		return
+	}
+
+	// TOPIC <channel> :<topic>
+	if num, ok := channel.CanSetTopic(msg.SenderID).(*parser.Numeric); ok && !hasPrivilege(msg.SenderID, PrivOverride) {
+		ircd.ToClient <- num.Message(msg.SenderID)
+		return
+	}
+	topic := msg.Args[1]
+	if len(topic) > TopicLen {
+		// Don't split a UTF-8 character
+		end := TopicLen
+		for end > 0 && !utf8.RuneStart(topic[end]) {
+			end--
+		}
+		topic = topic[:end]
+	}
+	nick, _, _, _, _ := user.GetInfo(msg.SenderID)
+	channel.SetTopic(nick, topic, time.Now().Unix())
+
+	notify := []string{}
+	for _, uid := range channel.UserIDs() {
+		if uid[:3] == Config().SID {
+			notify = append(notify, uid)
+		}
+	}
+	ircd.ToClient <- &parser.Message{
+		Prefix:  msg.SenderID,
+		Command: parser.CMD_TOPIC,
+		Args: []string{
+			channel.Name(),
+			topic,
+		},
+		DestIDs: notify,
+	}
+
+	// Forward to other servers
+	for sid := range server.Iter() {
+		ircd.ToServer <- &parser.Message{
+			Prefix:  msg.SenderID,
+			Command: parser.CMD_TOPIC,
+			Args: []string{
+				channel.Name(),
+				topic,
+			},
+			DestIDs: []string{sid},
+		}
+	}
+}
+
+// Server TOPIC
+func STopic(hook string, msg *parser.Message, ircd *IRCd) {
+	channame, topic := msg.Args[0], msg.Args[1]
+
+	channel, err := channel.Get(channame, false)
+	if num, ok := err.(*parser.Numeric); ok {
+		ircd.ToServer <- num.ErrorMessage(msg.SenderID)
+		return
+	}
+
+	// A topic set by a server (rather than one of its users) is credited
+	// to the server's name
+	source := msg.Prefix
+	if len(source) == 0 {
+		source = msg.SenderID
+	}
+	var setter string
+	if len(source) == 3 {
+		_, setter, _, _, _ = server.GetInfo(source)
+		source = setter
+	} else {
+		setter, _, _, _, _ = user.GetInfo(source)
+	}
+	channel.SetTopic(setter, topic, time.Now().Unix())
+
+	notifyTopic(channel, source, ircd)
+
+	// Forward on to other servers
+	for fwd := range server.Iter() {
+		if fwd != msg.SenderID {
+			log.Debug.Printf("Forwarding TOPIC from %s to %s", msg.SenderID, fwd)
+			fmsg := msg.Dup()
+			fmsg.DestIDs = []string{fwd}
+			ircd.ToServer <- fmsg
+		}
+	}
+}
+
+// Server TB (topic burst)
+func TB(hook string, msg *parser.Message, ircd *IRCd) {
+	channame, topicTS, topic := msg.Args[0], msg.Args[1], msg.Args[len(msg.Args)-1]
+
+	// Without a setter, use the name of the server
+	_, setter, _, _, _ := server.GetInfo(msg.Prefix)
+	if len(msg.Args) == 4 {
+		setter = msg.Args[2]
+	}
+
+	channel, err := channel.Get(channame, false)
+	if num, ok := err.(*parser.Numeric); ok {
+		ircd.ToServer <- num.ErrorMessage(msg.SenderID)
+		return
+	}
+
+	ts, _ := strconv.ParseInt(topicTS, 10, 64)
+	if !channel.MergeTopic(setter, topic, ts) {
+		log.Debug.Printf("Ignoring newer topic for %s from %s", channame, msg.SenderID)
+		return
+	}
+
+	notifyTopic(channel, setter, ircd)
+
+	// Forward on to other servers
+	for fwd := range server.Iter() {
+		if fwd != msg.SenderID {
+			log.Debug.Printf("Forwarding TB from %s to %s", msg.SenderID, fwd)
+			fmsg := msg.Dup()
+			fmsg.DestIDs = []string{fwd}
+			ircd.ToServer <- fmsg
+		}
+	}
+}
+
+// Send the current topic of a channel to its local members.
+func notifyTopic(c *channel.Channel, source string, ircd *IRCd) {
+	topic, _, _ := c.Topic()
+
+	notify := []string{}
+	for _, uid := range c.UserIDs() {
+		if uid[:3] == Config().SID {
+			notify = append(notify, uid)
+		}
+	}
+
+	if len(notify) > 0 {
+		ircd.ToClient <- &parser.Message{
+			Prefix:  source,
+			Command: parser.CMD_TOPIC,
+			Args: []string{
+				c.Name(),
+				topic,
+			},
+			DestIDs: notify,
+		}
+	}
+}
+
+// Construct RPL_TOPIC and RPL_TOPICWHOTIME (or RPL_NOTOPIC) for a channel.
+func topicReplies(c *channel.Channel, destID string) []*parser.Message {
+	topic, setter, ts := c.Topic()
+	if len(topic) == 0 {
+		return []*parser.Message{
+			parser.NewNumeric(parser.RPL_NOTOPIC, c.Name()).Message(destID),
+		}
+	}
+
+	msg := parser.NewNumeric(parser.RPL_TOPIC, c.Name()).Message(destID)
+	msg.Args[len(msg.Args)-1] = topic
+	who := parser.NewNumeric(parser.RPL_TOPICWHOTIME, c.Name(), setter).Message(destID)
+	who.Args[len(who.Args)-1] = strconv.FormatInt(ts, 10)
+	return []*parser.Message{msg, who}
+}
+
+// Local KICKs only
+func Kick(hook string, msg *parser.Message, ircd *IRCd) {
+	channames, nicks := strings.Split(msg.Args[0], ","), strings.Split(msg.Args[1], ",")
+	reason, _, _, _, _ := user.GetInfo(msg.SenderID)
+	if len(msg.Args) > 2 {
+		reason = msg.Args[2]
+	}
+
+	// KICK #chan a,b,c or KICK #a,#b,#c a,b,c
+	if len(channames) != 1 && len(channames) != len(nicks) {
+		ircd.ToClient <- parser.NewNumeric(parser.ERR_NEEDMOREPARAMS).Message(msg.SenderID)
+		return
+	}
+
+	for i, nick := range nicks {
+		channame := channames[0]
+		if len(channames) > 1 {
+			channame = channames[i]
+		}
+
+		channel, err := channel.Get(channame, false)
+		if num, ok := err.(*parser.Numeric); ok {
+			ircd.ToClient <- num.Message(msg.SenderID)
+			continue
+		}
+
+		target, err := user.GetID(nick)
+		if num, ok := err.(*parser.Numeric); ok {
+			ircd.ToClient <- num.Message(msg.SenderID)
+			continue
+		}
+
+		if num, ok := channel.CanKick(msg.SenderID, target).(*parser.Numeric); ok && !hasPrivilege(msg.SenderID, PrivOverride) {
+			ircd.ToClient <- num.Message(msg.SenderID)
+			continue
+		}
+
+		members, err := channel.Part(target)
+		if num, ok := err.(*parser.Numeric); ok {
+			ircd.ToClient <- num.Message(msg.SenderID)
+			continue
+		}
+
+		notify := []string{}
+		for _, uid := range members {
+			if uid[:3] == Config().SID {
+				notify = append(notify, uid)
+			}
+		}
+
+		ircd.ToClient <- &parser.Message{
+			Prefix:  msg.SenderID,
+			Command: parser.CMD_KICK,
+			Args: []string{
+				channel.Name(),
+				target,
+				reason,
+			},
+			DestIDs: notify,
+		}
+
+		// Forward to other servers
+		for sid := range server.Iter() {
+			ircd.ToServer <- &parser.Message{
+				Prefix:  msg.SenderID,
+				Command: parser.CMD_KICK,
+				Args: []string{
+					channel.Name(),
+					target,
+					reason,
+				},
+				DestIDs: []string{sid},
+			}
+		}
+	}
+}
+
+// Server KICK
+func SKick(hook string, msg *parser.Message, ircd *IRCd) {
+	channame, target := msg.Args[0], msg.Args[1]
+	reason := msg.Prefix
+	if len(msg.Args) > 2 {
+		reason = msg.Args[2]
+	}
+
+	// Forward on to other servers
+	for fwd := range server.Iter() {
+		if fwd != msg.SenderID {
+			log.Debug.Printf("Forwarding KICK from %s to %s", msg.SenderID, fwd)
+			fmsg := msg.Dup()
+			fmsg.DestIDs = []string{fwd}
+			ircd.ToServer <- fmsg
+		}
+	}
+
+	channel, err := channel.Get(channame, false)
+	if num, ok := err.(*parser.Numeric); ok {
+		ircd.ToServer <- num.ErrorMessage(msg.SenderID)
+		return
+	}
+
+	members, err := channel.Part(target)
+	if err != nil {
+		log.Debug.Printf("Ignoring KICK of %s from %s: %s", target, channame, err)
+		return
+	}
+
+	notify := []string{}
+	for _, uid := range members {
+		if uid[:3] == Config().SID {
+			notify = append(notify, uid)
+		}
+	}
+
+	if len(notify) > 0 {
+		ircd.ToClient <- &parser.Message{
+			Prefix:  msg.Prefix,
+			Command: parser.CMD_KICK,
+			Args: []string{
+				channel.Name(),
+				target,
+				reason,
+			},
+			DestIDs: notify,
+		}
+	}
+}
+
+// Local NAMES
+func Names(hook string, msg *parser.Message, ircd *IRCd) {
+	// NAMES with no channels only gets the end of the list
+	if len(msg.Args) == 0 {
+		ircd.ToClient <- parser.NewNumeric(parser.RPL_ENDOFNAMES, "*").Message(msg.SenderID)
+		return
+	}
+
+	for _, channame := range strings.Split(msg.Args[0], ",") {
+		channel, err := channel.Get(channame, false)
+		if err != nil {
+			ircd.ToClient <- parser.NewNumeric(parser.RPL_ENDOFNAMES, channame).Message(msg.SenderID)
+			continue
+		}
+		for _, reply := range namesReplies(channel, msg.SenderID) {
+			ircd.ToClient <- reply
+		}
+	}
+}
+
+// Construct the RPL_NAMREPLY messages and RPL_ENDOFNAMES for a channel.
+func namesReplies(c *channel.Channel, uid string) []*parser.Message {
+	nick, _, _, _, _ := user.GetInfo(uid)
+
+	// :<server> 353 <nick> = <channel> :<names>\r\n
+	maxlen := 510 - len(":"+Config().Name+" "+parser.RPL_NAMREPLY+" "+nick+" = "+c.Name()+" :")
+
+	multiPrefix, userhost := false, false
+	if u, ok := user.Lookup(uid); ok {
+		multiPrefix, userhost = u.HasCap(multiprefix), u.HasCap(userhostinnames)
+	}
+	replies := c.NamesMessages(uid, maxlen, multiPrefix, userhost)
+	return append(replies, parser.NewNumeric(parser.RPL_ENDOFNAMES, c.Name()).Message(uid))
+}
+
+// Local LIST
+func List(hook string, msg *parser.Message, ircd *IRCd) {
+	filter := &channel.ListFilter{}
+	if len(msg.Args) > 0 {
+		filter = parseListFilter(msg.Args[0], time.Now().Unix())
+	}
+	filter.Hidden = hasPrivilege(msg.SenderID, PrivAuspex)
+
+	sent := 0
+	for name := range channel.Iter() {
+		c, err := channel.Get(name, false)
+		if err != nil {
+			continue
+		}
+		reply := c.ListMessage(msg.SenderID, filter)
+		if reply == nil {
+			continue
+		}
+
+		// Let the send queue drain before generating the next chunk
+		if sent++; sent%ListChunk == 0 {
+			for queued(msg.SenderID) > ListBacklog {
+				time.Sleep(ListDelay)
+			}
+		}
+		ircd.ToClient <- reply
+	}
+	ircd.ToClient <- parser.NewNumeric(parser.RPL_LISTEND).Message(msg.SenderID)
+}
+
+// Parse the comma-separated ELIST conditions given to LIST.  Times are given
+// in minutes relative to now.  Anything that is not a condition is taken as
+// a channel name or mask, and masks starting with ! exclude channels.
+func parseListFilter(arg string, now int64) *channel.ListFilter {
+	filter := &channel.ListFilter{}
+	minutesAgo := func(s string) int64 {
+		n, _ := strconv.ParseInt(s, 10, 64)
+		return now - 60*n
+	}
+
+	for _, cond := range strings.Split(arg, ",") {
+		switch {
+		case len(cond) == 0:
+		case cond[0] == '>':
+			filter.MinUsers, _ = strconv.Atoi(cond[1:])
+		case cond[0] == '<':
+			filter.MaxUsers, _ = strconv.Atoi(cond[1:])
+		case strings.HasPrefix(cond, "C<"):
+			filter.CreatedAfter = minutesAgo(cond[2:])
+		case strings.HasPrefix(cond, "C>"):
+			filter.CreatedBefore = minutesAgo(cond[2:])
+		case strings.HasPrefix(cond, "T<"):
+			filter.TopicAfter = minutesAgo(cond[2:])
+		case strings.HasPrefix(cond, "T>"):
+			filter.TopicBefore = minutesAgo(cond[2:])
+		case cond[0] == '!':
+			filter.NotMasks = append(filter.NotMasks, cond[1:])
+		default:
+			filter.Masks = append(filter.Masks, cond)
+		}
+	}
+	return filter
+}
+
+// Build the JOIN messages announcing joiner to the local users in notify.
+// Clients with extended-join also receive the joiner's account ("*" if they
+// are not logged in) and real name.
+func joinMessages(c *channel.Channel, joiner string, notify []string) []*parser.Message {
+	plain, extended := []string{}, []string{}
+	for _, uid := range notify {
+		if u, ok := user.Lookup(uid); ok && u.HasCap(extendedjoin) {
+			extended = append(extended, uid)
+		} else {
+			plain = append(plain, uid)
+		}
+	}
+
+	msgs := []*parser.Message{}
+	if len(plain) > 0 {
+		msgs = append(msgs, &parser.Message{
+			Prefix:  joiner,
+			Command: parser.CMD_JOIN,
+			Args: []string{
+				c.Name(),
+			},
+			DestIDs: plain,
+		})
+	}
+	if len(extended) > 0 {
+		account, name := "*", "*"
+		if u, ok := user.Lookup(joiner); ok {
+			if acct := u.Account(); len(acct) > 0 {
+				account = acct
+			}
+			name = u.Name()
+		}
+		msgs = append(msgs, &parser.Message{
+			Prefix:  joiner,
+			Command: parser.CMD_JOIN,
+			Args: []string{
+				c.Name(),
+				account,
+				name,
+			},
+			DestIDs: extended,
+		})
+	}
+	return msgs
+}
+
+// Get the local users who share a channel with uid and have enabled the
+// given capability.  The user themselves is not included.
+func capPeers(uid, capname string) []string {
+	seen := map[string]bool{uid: true}
+	peers := []string{}
+	for _, c := range channel.UserChannels(uid) {
+		for _, peer := range c.UserIDs() {
+			if seen[peer] || peer[:3] != Config().SID {
+				continue
+			}
+			seen[peer] = true
+			if u, ok := user.Lookup(peer); ok && u.HasCap(capname) {
+				peers = append(peers, peer)
+			}
+		}
+	}
+	return peers
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/channel"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/server"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+func TestTopicLength(t *testing.T) {
+	SetConfig(&Configuration{SID: "000"})
+	defer SetConfig(nil)
+
+	user.Get("000AAATOP").SetNick("chatty")
+	defer user.Delete("000AAATOP")
+	c, _ := channel.Get("#topiclen", true)
+	c.Join("000AAATOP")
+	defer channel.PartAll("000AAATOP")
+
+	// The two-byte é would be split by cutting the topic at TopicLen
+	long := strings.Repeat("x", TopicLen-1) + "é and more"
+	runHook(Topic, parser.CMD_TOPIC, "000AAATOP", "#topiclen", long)
+	topic, _, _ := c.Topic()
+	if got, want := topic, strings.Repeat("x", TopicLen-1); got != want {
+		t.Errorf("topic = %q, want %q", got, want)
+	}
+	if !utf8.ValidString(topic) {
+		t.Errorf("topic %q is not valid UTF-8", topic)
+	}
+}
+
+func TestSTopicFromServer(t *testing.T) {
+	SetConfig(&Configuration{SID: "000"})
+	defer SetConfig(nil)
+
+	server.Get("123", true).SetServer("hub.example.com", "1")
+	defer server.Unlink("123")
+	user.Get("000AAATP2").SetNick("reader")
+	defer user.Delete("000AAATP2")
+	c, _ := channel.Get("#servertopic", true)
+	c.Join("000AAATP2")
+	defer channel.PartAll("000AAATP2")
+
+	msgs := runHook(STopic, parser.CMD_TOPIC, "123", "#servertopic", "Set by a server")
+	if _, setter, _ := c.Topic(); setter != "hub.example.com" {
+		t.Errorf("topic setter = %q, want %q", setter, "hub.example.com")
+	}
+	if len(msgs) != 1 || msgs[0].Prefix != "hub.example.com" {
+		t.Errorf("TOPIC sent to members: got %v, want one from hub.example.com", msgs)
+	}
+}
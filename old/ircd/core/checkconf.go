@@ -0,0 +1,335 @@
+package core
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A ConfigError is a problem found in a configuration file, at the given
+// (1-based) line and column.
+type ConfigError struct {
+	Line, Column int
+	Message      string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// An element describes the attributes and child elements a configuration
+// element may have.
+type element struct {
+	attrs    map[string]bool
+	children map[string]*element
+}
+
+// Build the description of the elements which decode into values of the
+// given type from its xml struct tags.
+func elementOf(t reflect.Type) *element {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	e := &element{
+		attrs:    make(map[string]bool),
+		children: make(map[string]*element),
+	}
+	if t.Kind() != reflect.Struct {
+		return e
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("xml"), ",")
+		switch {
+		case len(tag[0]) == 0:
+			// chardata
+		case len(tag) > 1 && tag[1] == "attr":
+			e.attrs[tag[0]] = true
+		default:
+			e.children[tag[0]] = elementOf(field.Type)
+		}
+	}
+	return e
+}
+
+// The elements allowed in a configuration file, under <server>.
+var configElement = elementOf(reflect.TypeOf(Configuration{}))
+
+// Check a host mask from a class, operator or link.  Only operator masks may
+// have a username (user@host).  The host is a glob or a CIDR address range
+// (see matchHostMask).
+func checkHostMask(mask string, withUser bool) error {
+	host := mask
+	if i := strings.Index(mask, "@"); i >= 0 {
+		if !withUser {
+			return errors.New("host mask " + strconv.Quote(mask) + " may not have a username")
+		}
+		username := mask[:i]
+		host = mask[i+1:]
+		if len(username) == 0 || strings.ContainsAny(username, "@!") {
+			return errors.New("invalid username in host mask " + strconv.Quote(mask))
+		}
+	}
+	if len(host) == 0 {
+		return errors.New("empty host mask")
+	}
+	if strings.Contains(host, "/") {
+		if _, _, err := net.ParseCIDR(host); err != nil {
+			return errors.New("invalid CIDR range in host mask " + strconv.Quote(mask))
+		}
+		return nil
+	}
+	for _, r := range host {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(".-:_*?", r):
+		default:
+			return errors.New("invalid character " + strconv.QuoteRune(r) + " in host mask " + strconv.Quote(mask))
+		}
+	}
+	return nil
+}
+
+// Check that an operator flag is one of OperFlags.
+func checkOperFlag(flag string) error {
+	for _, f := range OperFlags {
+		if strings.EqualFold(f, flag) {
+			return nil
+		}
+	}
+	return errors.New("unknown operator flag " + strconv.Quote(flag))
+}
+
+// Check that an operator class privilege is one of Privileges.
+func checkPrivilege(priv string) error {
+	for _, p := range Privileges {
+		if p == priv {
+			return nil
+		}
+	}
+	return errors.New("unknown privilege " + strconv.Quote(priv))
+}
+
+// ValidateConfig checks an XML configuration for mistakes which would
+// otherwise be silently ignored or only noticed later: unknown elements and
+// attributes, and the problems validateValues finds in the values.  All of
+// the problems found are returned as *ConfigErrors, in the order they appear
+// in the file.
+func ValidateConfig(confxml []byte) (errs []error) {
+	// Convert a byte offset into a line and column
+	errorAt := func(offset int64, msg string) {
+		before := confxml[:offset]
+		line := bytes.Count(before, []byte("\n")) + 1
+		col := len(before) - bytes.LastIndex(before, []byte("\n"))
+		errs = append(errs, &ConfigError{line, col, msg})
+	}
+
+	// An element which has been opened but not closed
+	type open struct {
+		name string
+		path string   // the names of the element and its parents
+		elem *element // nil if unknown
+	}
+	var stack []*open
+
+	// starts[path] = the offsets of the known elements with that path
+	starts := make(map[string][]int64)
+
+	dec := xml.NewDecoder(bytes.NewReader(confxml))
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			msg := err.Error()
+			if serr, ok := err.(*xml.SyntaxError); ok {
+				msg = serr.Msg
+			}
+			// The values cannot be checked without the whole file
+			errorAt(offset, msg)
+			return
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			name := tok.Name.Local
+			cur := &open{name: name, path: name}
+			switch {
+			case len(stack) == 0:
+				if name != "server" {
+					errorAt(offset, "the top level element must be <server>, not <"+name+">")
+					return
+				}
+				cur.elem = configElement
+			default:
+				parent := stack[len(stack)-1]
+				cur.path = parent.path + "/" + name
+				if parent.elem != nil {
+					if cur.elem = parent.elem.children[name]; cur.elem == nil {
+						errorAt(offset, "unknown element <"+name+"> in <"+parent.name+">")
+					}
+				}
+			}
+			if cur.elem != nil {
+				starts[cur.path] = append(starts[cur.path], offset)
+				for _, attr := range tok.Attr {
+					if len(attr.Name.Space) == 0 && !cur.elem.attrs[attr.Name.Local] {
+						errorAt(offset, "unknown attribute "+strconv.Quote(attr.Name.Local)+" on <"+name+">")
+					}
+				}
+			}
+			stack = append(stack, cur)
+
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	conf, err := parseXMLConfig(confxml)
+	if err != nil {
+		return append(errs, err)
+	}
+	for _, err := range validateValues(conf) {
+		verr := err.(*valueError)
+		if offsets := starts[verr.path]; verr.n < len(offsets) {
+			errorAt(offsets[verr.n], verr.err.Error())
+		} else {
+			errorAt(0, verr.err.Error())
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		a, b := errs[i].(*ConfigError), errs[j].(*ConfigError)
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return
+}
+
+// ValidateConfigFile checks a configuration file.  XML files are checked
+// with ValidateConfig; TOML and YAML files, whose unknown keys are already
+// errors when they are read, have their values checked by validateValues,
+// so their errors (other than syntax errors) have no positions.
+func ValidateConfigFile(filename string) []error {
+	if ConfigFormat(filename) == FormatXML {
+		confxml, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return []error{err}
+		}
+		return ValidateConfig(confxml)
+	}
+	conf, err := readConfigFile(filename)
+	if err != nil {
+		return []error{err}
+	}
+	return validateValues(conf)
+}
+
+// A problem with a configuration value, found in the nth element (counting
+// from 0 in the order they appear in the file) with the given path, e.g.
+// the second "server/operator/host".  The error describes where it is
+// (e.g. which operator) for those who cannot be shown the position.
+type valueError struct {
+	path  string
+	n     int
+	where string // e.g. `operator "god"`, if the error does not say
+	err   error
+}
+
+func (e *valueError) Error() string {
+	if len(e.where) == 0 {
+		return e.err.Error()
+	}
+	return e.where + ": " + e.err.Error()
+}
+
+// Check the values in a configuration: invalid and duplicate ports,
+// unparseable host masks, unknown operator flags and privileges, operators
+// whose class does not exist and links without passwords.  The errors are *valueErrors.
+func validateValues(conf *Configuration) (errs []error) {
+	// seen[path] = the number of elements with that path so far
+	seen := make(map[string]int)
+	next := func(path string) int {
+		n := seen[path]
+		seen[path]++
+		return n
+	}
+	errorIn := func(path string, n int, where string, err error) {
+		errs = append(errs, &valueError{path, n, where, err})
+	}
+
+	ports := make(map[int]bool)
+	for _, p := range conf.Ports {
+		n := next("server/ports")
+		list, err := p.GetPortList()
+		if err != nil {
+			errorIn("server/ports", n, "", fmt.Errorf("invalid ports %q: %s", strings.TrimSpace(p.PortString), err))
+			continue
+		}
+		for _, port := range list {
+			if ports[port] {
+				errorIn("server/ports", n, "", fmt.Errorf("duplicate port %d", port))
+			}
+			ports[port] = true
+		}
+	}
+	for _, class := range conf.Class {
+		for _, host := range class.Host {
+			n := next("server/class/host")
+			if err := checkHostMask(host, false); err != nil {
+				errorIn("server/class/host", n, "class "+strconv.Quote(class.Name), err)
+			}
+		}
+	}
+	classes := make(map[string]bool)
+	for _, class := range conf.OperClass {
+		classes[class.Name] = true
+		for _, priv := range class.Privilege {
+			n := next("server/operclass/privilege")
+			if err := checkPrivilege(priv); err != nil {
+				errorIn("server/operclass/privilege", n, "operator class "+strconv.Quote(class.Name), err)
+			}
+		}
+	}
+	for _, oper := range conf.Operator {
+		n := next("server/operator")
+		if !classes[oper.Class] {
+			errorIn("server/operator", n, "operator "+strconv.Quote(oper.Name), errors.New("undefined operator class "+strconv.Quote(oper.Class)))
+		}
+		for _, host := range oper.Host {
+			n := next("server/operator/host")
+			if err := checkHostMask(host, true); err != nil {
+				errorIn("server/operator/host", n, "operator "+strconv.Quote(oper.Name), err)
+			}
+		}
+		for _, flag := range oper.Flag {
+			n := next("server/operator/flag")
+			if err := checkOperFlag(flag); err != nil {
+				errorIn("server/operator/flag", n, "operator "+strconv.Quote(oper.Name), err)
+			}
+		}
+	}
+	if conf.Network != nil {
+		for _, link := range conf.Network.Link {
+			n := next("server/network/link")
+			for _, host := range link.Host {
+				hn := next("server/network/link/host")
+				if err := checkHostMask(host, false); err != nil {
+					errorIn("server/network/link/host", hn, "link "+strconv.Quote(link.Name), err)
+				}
+			}
+			if link.Password == nil {
+				errorIn("server/network/link", n, "", fmt.Errorf("link to %q has no password", link.Name))
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateDefaultConfig(t *testing.T) {
+	if errs := ValidateConfig([]byte(DefaultXML)); len(errs) > 0 {
+		t.Errorf("ValidateConfig(DefaultXML) = %v, want no errors", errs)
+	}
+}
+
+var validateConfigTests = []struct {
+	Desc   string
+	Config string
+	Errors []string
+}{
+	{
+		Desc: "unknown element and attribute",
+		Config: `<server name="a.b" sid="0AA">
+	<prots>6667</prots>
+	<class name="users" max="3"><host>*</host></class>
+</server>`,
+		Errors: []string{
+			`line 2, column 2: unknown element <prots> in <server>`,
+			`line 3, column 2: unknown attribute "max" on <class>`,
+		},
+	},
+	{
+		Desc: "ports",
+		Config: `<server>
+	<ports>0</ports>
+	<ports>6667-6669</ports>
+	<ports>6669,70000</ports>
+	<ports> 6669 </ports>
+</server>`,
+		Errors: []string{
+			`line 2, column 2: invalid ports "0": Port out of range: 0`,
+			`line 4, column 2: invalid ports "6669,70000": Port out of range: 70000`,
+			`line 5, column 2: duplicate port 6669`,
+		},
+	},
+	{
+		Desc: "hosts, flags and links",
+		Config: `<server>
+	<network><link name="hub.local"><host>hub local</host></link></network>
+	<class name="users"><host>user@*</host></class>
+	<operator name="god" class="staff">
+		<host>god@*.example.com</host>
+		<host>@*</host>
+		<flag>admin</flag>
+		<flag>root</flag>
+	</operator>
+</server>`,
+		Errors: []string{
+			`line 2, column 11: link to "hub.local" has no password`,
+			`line 2, column 34: invalid character ' ' in host mask "hub local"`,
+			`line 3, column 22: host mask "user@*" may not have a username`,
+			`line 4, column 2: undefined operator class "staff"`,
+			`line 6, column 3: invalid username in host mask "@*"`,
+			`line 8, column 3: unknown operator flag "root"`,
+		},
+	},
+	{
+		Desc: "CIDR masks",
+		Config: `<server>
+	<class name="lan"><host>10.0.0.0/8</host><host>2001:db8::/32</host></class>
+	<class name="bad"><host>10.0.0.0/33</host></class>
+	<operclass name="staff"><privilege>rehash</privilege></operclass>
+	<operator name="god" class="staff"><host>god@192.168.1.0/24</host></operator>
+</server>`,
+		Errors: []string{
+			`line 3, column 20: invalid CIDR range in host mask "10.0.0.0/33"`,
+		},
+	},
+	{
+		Desc: "privileges",
+		Config: `<server>
+	<operclass name="helper">
+		<privilege>kill:local</privilege>
+		<privilege>kill</privilege>
+	</operclass>
+</server>`,
+		Errors: []string{
+			`line 4, column 3: unknown privilege "kill"`,
+		},
+	},
+	{
+		Desc: "syntax error",
+		Config: `<server>
+	<ports>6667</port>
+</server>`,
+		Errors: []string{
+			`line 2, column 13: element <ports> closed by </port>`,
+		},
+	},
+	{
+		Desc:   "not a server",
+		Config: `<client/>`,
+		Errors: []string{
+			`line 1, column 1: the top level element must be <server>, not <client>`,
+		},
+	},
+}
+
+func TestValidateConfig(t *testing.T) {
+	for _, test := range validateConfigTests {
+		var got []string
+		for _, err := range ValidateConfig([]byte(test.Config)) {
+			got = append(got, err.Error())
+		}
+		if g, w := strings.Join(got, "\n"), strings.Join(test.Errors, "\n"); g != w {
+			t.Errorf("%s: errors:\n%s\nwant:\n%s", test.Desc, g, w)
+		}
+	}
+}
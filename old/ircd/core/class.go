@@ -0,0 +1,159 @@
+package core
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+var (
+	// Held while a connecting client is counted against the class limits
+	// and added to its class, so that clones cannot slip in together.
+	classMutex = new(sync.Mutex)
+)
+
+// Find the class with the given name.
+func findClass(name string) (*Class, bool) {
+	conf := Config()
+	if conf == nil || len(name) == 0 {
+		return nil, false
+	}
+	for _, class := range conf.Class {
+		if class.Name == name {
+			return class, true
+		}
+	}
+	return nil, false
+}
+
+// Get the connection class of a local user, if they have one.
+func userClass(uid string) (*Class, bool) {
+	u, ok := user.Lookup(uid)
+	if !ok {
+		return nil, false
+	}
+	return findClass(u.Class())
+}
+
+// Get the network a clone limit applies to: the /24 of an IPv4 address or
+// the /64 of an IPv6 address.
+func cloneNet(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String()
+}
+
+// Put a newly connected local user in the first class which matches their
+// address.  If there is no such class, or it is full, the reason the client
+// is refused is returned.  When no classes are configured, everyone is let
+// in.
+func admitClient(u *user.User) (reason string, ok bool) {
+	conf := Config()
+	if len(conf.Class) == 0 {
+		return "", true
+	}
+
+	var class *Class
+	for _, c := range conf.Class {
+		if c.MatchHost(u.Host(), u.IP()) {
+			class = c
+			break
+		}
+	}
+	if class == nil {
+		return "No connection class for your host", false
+	}
+
+	classMutex.Lock()
+	defer classMutex.Unlock()
+
+	addr := net.ParseIP(u.IP())
+	clients, perIP, perNet := 0, 0, 0
+	for uid := range user.Iter() {
+		if uid[:3] != conf.SID || uid == u.ID() {
+			continue
+		}
+		other, ok := user.Lookup(uid)
+		if !ok || other.Class() != class.Name {
+			continue
+		}
+		clients++
+		if other.IP() == u.IP() {
+			perIP++
+		}
+		if otherAddr := net.ParseIP(other.IP()); addr != nil && otherAddr != nil && cloneNet(addr) == cloneNet(otherAddr) {
+			perNet++
+		}
+	}
+
+	switch {
+	case class.MaxClients > 0 && clients >= class.MaxClients:
+		return "Too many connections in class " + class.Name, false
+	case class.MaxPerIP > 0 && perIP >= class.MaxPerIP:
+		return "Too many connections from your IP (max " + strconv.Itoa(class.MaxPerIP) + ")", false
+	case class.MaxPerNet > 0 && perNet >= class.MaxPerNet:
+		return "Too many connections from your network (max " + strconv.Itoa(class.MaxPerNet) + ")", false
+	}
+	u.SetClass(class.Name)
+	return "", true
+}
+
+// A floodBucket limits how quickly a client may send messages: each message
+// takes a token, and tokens are refilled at the class's flood rate up to its
+// flood burst.
+type floodBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Take a token from the bucket for a message received at the given time.
+// If there are none left, false is returned.
+func (b *floodBucket) take(class *Class, now time.Time) bool {
+	if class.FloodBurst <= 0 {
+		return true
+	}
+	if b.last.IsZero() {
+		b.tokens = float64(class.FloodBurst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * float64(class.FloodRate)
+		if max := float64(class.FloodBurst); b.tokens > max {
+			b.tokens = max
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// The state kept for each local client connection.
+type clientState struct {
+	heard   time.Time // when the client last sent a message
+	pinged  bool      // whether it has been sent a PING since then
+	dropped bool      // whether it is being disconnected
+	flood   floodBucket
+	sasl    string // the chunks of an unfinished AUTHENTICATE response
+}
+
+// Disconnect a local client with the given reason.  This must not be called
+// from the goroutine which sends messages to clients.
+func dropClient(uid, reason string, ircd *IRCd) {
+	if u, ok := user.Lookup(uid); ok && u.Type() == user.RegisteredAsUser {
+		exitUser(uid, reason, reason, "", ircd)
+		return
+	}
+	ircd.ToClient <- &parser.Message{
+		Command: parser.CMD_ERROR,
+		Args: []string{
+			"Closing Link (" + reason + ")",
+		},
+		DestIDs: []string{uid},
+	}
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+var cloneNetTests = []struct {
+	IP, Net string
+}{
+	{"192.0.2.17", "192.0.2.0"},
+	{"192.0.2.200", "192.0.2.0"},
+	{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::"},
+}
+
+func TestCloneNet(t *testing.T) {
+	for _, test := range cloneNetTests {
+		if got, want := cloneNet(net.ParseIP(test.IP)), test.Net; got != want {
+			t.Errorf("cloneNet(%s) = %s, want %s", test.IP, got, want)
+		}
+	}
+}
+
+func TestAdmitClient(t *testing.T) {
+	SetConfig(&Configuration{
+		SID: "000",
+		Class: []*Class{
+			{Name: "local", Host: []string{"127.*"}, MaxClients: 1},
+			{Name: "users", Host: []string{"192.0.2.*", "198.51.100.*"}, MaxPerIP: 2, MaxPerNet: 3},
+		},
+	})
+	defer SetConfig(nil)
+
+	tests := []struct {
+		ID, IP string
+		Class  string // "" if refused
+	}{
+		{"000AAACL1", "127.0.0.1", "local"},
+		{"000AAACL2", "127.0.0.1", ""}, // class full
+		{"000AAACL3", "192.0.2.1", "users"},
+		{"000AAACL4", "192.0.2.1", "users"},
+		{"000AAACL5", "192.0.2.1", ""}, // too many from the IP
+		{"000AAACL6", "192.0.2.2", "users"},
+		{"000AAACL7", "192.0.2.3", ""}, // too many from the /24
+		{"000AAACL8", "198.51.100.1", "users"},
+		{"000AAACL9", "203.0.113.1", ""}, // no class
+	}
+	for _, test := range tests {
+		u := user.Get(test.ID)
+		defer user.Delete(test.ID)
+		u.SetHost(test.IP, test.IP)
+
+		reason, ok := admitClient(u)
+		if got, want := ok, len(test.Class) > 0; got != want {
+			t.Errorf("%s from %s: admitted = %v (%q), want %v", test.ID, test.IP, got, reason, want)
+			continue
+		}
+		if got, want := u.Class(), test.Class; got != want {
+			t.Errorf("%s from %s: class = %q, want %q", test.ID, test.IP, got, want)
+		}
+	}
+}
+
+func TestFloodBucket(t *testing.T) {
+	class := &Class{FloodBurst: 3, FloodRate: 1}
+	b := new(floodBucket)
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 3; i++ {
+		if !b.take(class, now) {
+			t.Fatalf("message %d of the burst refused", i)
+		}
+	}
+	if b.take(class, now) {
+		t.Errorf("message after the burst allowed")
+	}
+	if !b.take(class, now.Add(1500*time.Millisecond)) {
+		t.Errorf("message after refill refused")
+	}
+	if b.take(class, now.Add(1600*time.Millisecond)) {
+		t.Errorf("second message after partial refill allowed")
+	}
+}
@@ -2,53 +2,168 @@ package core
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/xml"
 	"errors"
-	"io/ioutil"
+	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
 )
 
 // a Password stores Passwords for Oper and User directives.
 type Password struct {
-	Type     string `xml:"attr"`
-	Password string `xml:"chardata"`
+	Type     string `xml:"type,attr" toml:"type,omitempty" yaml:"type,omitempty"`
+	Password string `xml:",chardata" toml:"password,omitempty" yaml:"password,omitempty"`
 }
 
-// An Oper is an operator configuration directive.
+// An Oper is an operator configuration directive.  The operator's
+// privileges are those of its OperClass.
 type Oper struct {
-	Name     string `xml:"attr"`
-	Password *Password
-	Host     []string
-	Flag     []string
+	Name     string    `xml:"name,attr" toml:"name,omitempty" yaml:"name,omitempty"`
+	Class    string    `xml:"class,attr" toml:"class,omitempty" yaml:"class,omitempty"`
+	Password *Password `xml:"password" toml:"password,omitempty" yaml:"password,omitempty"`
+	Host     []string  `xml:"host" toml:"host,omitempty" yaml:"host,omitempty"`
+	Flag     []string  `xml:"flag" toml:"flag,omitempty" yaml:"flag,omitempty"`
+}
+
+// Check checks a password against the configured one.  The password type
+// is "plain" (the default), "bcrypt" or "sha256" (the hex digest).
+func (p *Password) Check(password string) bool {
+	switch strings.ToLower(p.Type) {
+	case "", "plain":
+		return subtle.ConstantTimeCompare([]byte(p.Password), []byte(password)) == 1
+	case "bcrypt":
+		return bcrypt.CompareHashAndPassword([]byte(p.Password), []byte(password)) == nil
+	case "sha256":
+		sum := sha256.Sum256([]byte(password))
+		want := strings.ToLower(strings.TrimSpace(p.Password))
+		return subtle.ConstantTimeCompare([]byte(want), []byte(hex.EncodeToString(sum[:]))) == 1
+	}
+	return false
+}
+
+// MatchHost returns true if a user with the given username, hostname and IP
+// address matches one of the operator's host masks.  Masks containing an @
+// are matched against user@host and user@ip, others against the host and IP.
+func (o *Oper) MatchHost(username, host, ip string) bool {
+	for _, mask := range o.Host {
+		if i := strings.Index(mask, "@"); i >= 0 {
+			if !parser.Match(mask[:i], username) {
+				continue
+			}
+			mask = mask[i+1:]
+		}
+		if matchHostMask(mask, host, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match a host mask against a hostname and IP address.  A mask is either a
+// glob (as in *.example.com) or, if it contains a /, an address range in
+// CIDR notation (as in 10.0.0.0/8), which only matches the IP address.
+func matchHostMask(mask, host, ip string) bool {
+	if strings.Contains(mask, "/") {
+		_, ipnet, err := net.ParseCIDR(mask)
+		addr := net.ParseIP(ip)
+		return err == nil && addr != nil && ipnet.Contains(addr)
+	}
+	return parser.Match(mask, host) || parser.Match(mask, ip)
+}
+
+// OperFlags are the flags an operator directive may have.
+var OperFlags = []string{
+	"admin", // the operator is also given user mode +a
+	"oper",
+}
+
+// HasFlag returns true if the operator has the given flag (e.g. "admin").
+func (o *Oper) HasFlag(flag string) bool {
+	for _, f := range o.Flag {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// An OperClass is a named set of operator privileges (see core.Privileges)
+// which can be shared by several operators.
+type OperClass struct {
+	Name      string   `xml:"name,attr" toml:"name,omitempty" yaml:"name,omitempty"`
+	Privilege []string `xml:"privilege" toml:"privilege,omitempty" yaml:"privilege,omitempty"`
 }
 
-// A Class is a user/server connection class directive.
+// A Class is a user/server connection class directive.  Clients are put in
+// the first class with a host mask matching their address; zero limits are
+// not enforced.  Usernames are prefixed with ~ unless the class has the
+// noident flag, since they have not been checked with ident.
 type Class struct {
-	Name string `xml:"attr"`
-	Host []string
-	Flag []string
+	Name string   `xml:"name,attr" toml:"name,omitempty" yaml:"name,omitempty"`
+	Host []string `xml:"host" toml:"host,omitempty" yaml:"host,omitempty"`
+	Flag []string `xml:"flag" toml:"flag,omitempty" yaml:"flag,omitempty"`
+
+	MaxClients int `xml:"maxclients" toml:"maxclients,omitempty" yaml:"maxclients,omitempty"` // the most clients in the class
+	MaxPerIP   int `xml:"maxperip" toml:"maxperip,omitempty" yaml:"maxperip,omitempty"`       // the most clients in the class from one IP address
+	MaxPerNet  int `xml:"maxpernet" toml:"maxpernet,omitempty" yaml:"maxpernet,omitempty"`    // the most from one /24 (IPv4) or /64 (IPv6)
+	PingFreq   int `xml:"pingfreq" toml:"pingfreq,omitempty" yaml:"pingfreq,omitempty"`       // seconds of silence before a client is sent a PING
+	SendQ      int `xml:"sendq" toml:"sendq,omitempty" yaml:"sendq,omitempty"`                // the most bytes waiting to be sent to a client
+	FloodBurst int `xml:"floodburst" toml:"floodburst,omitempty" yaml:"floodburst,omitempty"` // the most messages a client may send at once
+	FloodRate  int `xml:"floodrate" toml:"floodrate,omitempty" yaml:"floodrate,omitempty"`    // messages per second a client may send after a burst
+}
+
+// MatchHost returns true if a client with the given hostname and IP address
+// matches one of the class's host masks.
+func (c *Class) MatchHost(host, ip string) bool {
+	for _, mask := range c.Host {
+		if matchHostMask(mask, host, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFlag returns true if the class has the given flag (e.g. "noident").
+func (c *Class) HasFlag(flag string) bool {
+	for _, f := range c.Flag {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
 }
 
 // A Link represents the configuration information for a remote
-// server link.
+// server link.  The password is the one both servers send in PASS.
 type Link struct {
-	Name string `xml:"attr"`
-	Host []string
-	Flag []string
+	Name     string    `xml:"name,attr" toml:"name,omitempty" yaml:"name,omitempty"`
+	Password *Password `xml:"password" toml:"password,omitempty" yaml:"password,omitempty"`
+	Host     []string  `xml:"host" toml:"host,omitempty" yaml:"host,omitempty"`
+	Flag     []string  `xml:"flag" toml:"flag,omitempty" yaml:"flag,omitempty"`
 }
 
 // A Ports direcive stores a port range and whether or not it is an SSL port.
+// In TOML and YAML, the port range is given as "range".
 type Ports struct {
-	SSL        string `xml:"attr"`
-	PortString string `xml:"chardata"`
+	SSL        string `xml:"ssl,attr" toml:"ssl,omitempty" yaml:"ssl,omitempty"`
+	PortString string `xml:",chardata" toml:"range,omitempty" yaml:"range,omitempty"`
 }
 
 // GetPortList gets the port list specified by the range(s) in this ports directive.
-// The following port range formats are understood:
-//   6667           // A single port
-//   6666-6669      // A port range
-//   6666-6669,6697 // Comma-separated ranges
+// Ports must be between 1 and 65535.  The following port range formats are
+// understood:
+//
+//	6667           // A single port
+//	6666-6669      // A port range
+//	6666-6669,6697 // Comma-separated ranges
 func (p *Ports) GetPortList() (ports []int, err error) {
 	ranges := strings.Split(p.PortString, ",")
 	for _, rng := range ranges {
@@ -60,6 +175,9 @@ func (p *Ports) GetPortList() (ports []int, err error) {
 		if err != nil {
 			return nil, err
 		}
+		if low < 1 || low > 65535 {
+			return nil, errors.New("Port out of range: " + strconv.Itoa(low))
+		}
 		if len(extremes) == 1 {
 			ports = append(ports, low)
 			continue
@@ -68,6 +186,9 @@ func (p *Ports) GetPortList() (ports []int, err error) {
 		if err != nil {
 			return nil, err
 		}
+		if high < 1 || high > 65535 {
+			return nil, errors.New("Port out of range: " + strconv.Itoa(high))
+		}
 		if low > high {
 			return nil, errors.New("Inverted range: " + rng)
 		}
@@ -88,23 +209,54 @@ func (p *Ports) AreSSL() bool {
 	return false
 }
 
+// A TLS directive names the PEM certificate and key files used for the ssl
+// ports.
+type TLS struct {
+	Cert string `xml:"cert" toml:"cert,omitempty" yaml:"cert,omitempty"`
+	Key  string `xml:"key" toml:"key,omitempty" yaml:"key,omitempty"`
+}
+
+// A History directive configures the message history kept for CHATHISTORY.
+type History struct {
+	Length     int  `xml:"length" toml:"length,omitempty" yaml:"length,omitempty"`             // the number of messages kept per channel or conversation
+	Limit      int  `xml:"limit" toml:"limit,omitempty" yaml:"limit,omitempty"`                // the most messages returned for one request
+	JoinedOnly bool `xml:"joinedonly" toml:"joinedonly,omitempty" yaml:"joinedonly,omitempty"` // whether members only see messages from after they joined
+}
+
 // A Network represents the configuration data for the network on which
 // this server is running.
 type Network struct {
-	Name        string "attr"
-	Description string
-	Link        []*Link
+	Name        string  `xml:"name,attr" toml:"name,omitempty" yaml:"name,omitempty"`
+	Description string  `xml:"description" toml:"description,omitempty" yaml:"description,omitempty"`
+	Link        []*Link `xml:"link" toml:"link,omitempty" yaml:"link,omitempty"`
 }
 
 // A Configuration stores the configuration information for this server.
 type Configuration struct {
-	Name     string "attr"
-	SID      string "attr"
-	Admin    string
-	Network  *Network
-	Ports    []*Ports
-	Class    []*Class
-	Operator []*Oper
+	Name      string       `xml:"name,attr" toml:"name,omitempty" yaml:"name,omitempty"`
+	SID       string       `xml:"sid,attr" toml:"sid,omitempty" yaml:"sid,omitempty"`
+	Admin     string       `xml:"admin" toml:"admin,omitempty" yaml:"admin,omitempty"`
+	Network   *Network     `xml:"network" toml:"network,omitempty" yaml:"network,omitempty"`
+	Ports     []*Ports     `xml:"ports" toml:"ports,omitempty" yaml:"ports,omitempty"`
+	TLS       *TLS         `xml:"tls" toml:"tls,omitempty" yaml:"tls,omitempty"`
+	Class     []*Class     `xml:"class" toml:"class,omitempty" yaml:"class,omitempty"`
+	OperClass []*OperClass `xml:"operclass" toml:"operclass,omitempty" yaml:"operclass,omitempty"`
+	Operator  []*Oper      `xml:"operator" toml:"operator,omitempty" yaml:"operator,omitempty"`
+	Accounts  string       `xml:"accounts" toml:"accounts,omitempty" yaml:"accounts,omitempty"` // the SASL account file (see package account)
+	Motd      string       `xml:"motd" toml:"motd,omitempty" yaml:"motd,omitempty"`             // the message of the day file (reread when it changes)
+	Bans      string       `xml:"bans" toml:"bans,omitempty" yaml:"bans,omitempty"`             // the K-, D- and X-line file (see package ban)
+	History   *History     `xml:"history" toml:"history,omitempty" yaml:"history,omitempty"`
+}
+
+// Privileges returns the privileges of the given operator, which are those
+// of its oper class.  An operator whose class does not exist has none.
+func (c *Configuration) Privileges(o *Oper) []string {
+	for _, class := range c.OperClass {
+		if class.Name == o.Class {
+			return class.Privilege
+		}
+	}
+	return nil
 }
 
 // A suitable default XML configuration file on which an admin should
@@ -113,9 +265,14 @@ var DefaultXML = `` +
 	`<server name="blight.local" sid="8LI">
 	<ports>6666-6669</ports>
 	<ports ssl="true">6696-6699,9999</ports>
+	<tls>
+		<cert>ircd.crt</cert>
+		<key>ircd.key</key>
+	</tls>
 	<network name="IRCD-Blight">
 		<description>An unconfigured IRC network.</description>
 		<link name="blight2.local">
+			<password type="plain">linkpass</password>
 			<host>blight2.localdomain.local</host>
 			<host>127.0.0.1</host>
 			<flag>leaf</flag>
@@ -125,8 +282,30 @@ var DefaultXML = `` +
 	<class name="users">
 		<host>*</host>
 		<flag>noident</flag>
+		<maxclients>1000</maxclients>
+		<maxperip>3</maxperip>
+		<maxpernet>10</maxpernet>
+		<pingfreq>120</pingfreq>
+		<sendq>100000</sendq>
+		<floodburst>10</floodburst>
+		<floodrate>1</floodrate>
 	</class>
-	<operator name="god">
+	<operclass name="netadmin">
+		<privilege>kill:global</privilege>
+		<privilege>kline</privilege>
+		<privilege>rehash</privilege>
+		<privilege>die</privilege>
+		<privilege>restart</privilege>
+		<privilege>routing</privilege>
+		<privilege>wallops</privilege>
+		<privilege>override</privilege>
+		<privilege>auspex</privilege>
+	</operclass>
+	<operclass name="helper">
+		<privilege>kill:local</privilege>
+		<privilege>wallops</privilege>
+	</operclass>
+	<operator name="god" class="netadmin">
 		<password type="plain">blight</password>
 		<host>127.0.0.1</host>
 		<host>*.google.com</host>
@@ -136,7 +315,24 @@ var DefaultXML = `` +
 </server>
 `
 
-var Config *Configuration
+// The configuration in use, which REHASH replaces while hooks are reading it.
+var config atomic.Value
+
+// Config returns the configuration in use (nil if none has been loaded).
+// Since it may be replaced at any time, a hook which uses several settings
+// should call it once.
+func Config() *Configuration {
+	conf, _ := config.Load().(*Configuration)
+	return conf
+}
+
+// SetConfig replaces the configuration in use.
+func SetConfig(conf *Configuration) {
+	config.Store(conf)
+}
+
+// The file the configuration was loaded from, which is reread by REHASH.
+var ConfigFile string
 
 // LoadConfigFile loads an XML configuration string of the format shown in DefaultXML
 // as the configuration for the server.
@@ -145,22 +341,20 @@ func LoadConfigString(confxml string) error {
 	if err != nil {
 		return err
 	}
-	Config = conf
+	SetConfig(conf)
 	return nil
 }
 
-// LoadConfigFile loads an XML configuration file of the format shown in DefaultXML
-// as the configuration for the server.
+// LoadConfigFile loads a configuration file of the format shown in DefaultXML
+// as the configuration for the server.  Files ending in .toml or .yaml (or
+// .yml) are read as TOML or YAML instead of XML (see ConfigFormat).
 func LoadConfigFile(filename string) error {
-	confxml, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return err
-	}
-	conf, err := parseXMLConfig([]byte(confxml))
+	conf, err := readConfigFile(filename)
 	if err != nil {
 		return err
 	}
-	Config = conf
+	SetConfig(conf)
+	ConfigFile = filename
 	return nil
 }
 
@@ -1,8 +1,12 @@
 package core
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"reflect"
 	"testing"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 var testDefaultConfig = &Configuration{
@@ -14,6 +18,10 @@ var testDefaultConfig = &Configuration{
 		Description: "An unconfigured IRC network.",
 		Link: []*Link{&Link{
 			Name: "blight2.local",
+			Password: &Password{
+				Type:     "plain",
+				Password: "linkpass",
+			},
 			Host: []string{
 				"blight2.localdomain.local",
 				"127.0.0.1",
@@ -32,6 +40,10 @@ var testDefaultConfig = &Configuration{
 			SSL:        "true",
 		},
 	},
+	TLS: &TLS{
+		Cert: "ircd.crt",
+		Key:  "ircd.key",
+	},
 	Class: []*Class{&Class{
 		Name: "users",
 		Host: []string{
@@ -40,9 +52,40 @@ var testDefaultConfig = &Configuration{
 		Flag: []string{
 			"noident",
 		},
+		MaxClients: 1000,
+		MaxPerIP:   3,
+		MaxPerNet:  10,
+		PingFreq:   120,
+		SendQ:      100000,
+		FloodBurst: 10,
+		FloodRate:  1,
 	}},
+	OperClass: []*OperClass{
+		&OperClass{
+			Name: "netadmin",
+			Privilege: []string{
+				"kill:global",
+				"kline",
+				"rehash",
+				"die",
+				"restart",
+				"routing",
+				"wallops",
+				"override",
+				"auspex",
+			},
+		},
+		&OperClass{
+			Name: "helper",
+			Privilege: []string{
+				"kill:local",
+				"wallops",
+			},
+		},
+	},
 	Operator: []*Oper{&Oper{
-		Name: "god",
+		Name:  "god",
+		Class: "netadmin",
 		Password: &Password{
 			Type:     "plain",
 			Password: "blight",
@@ -64,8 +107,7 @@ func TestDefaultConfig(t *testing.T) {
 		t.Fatalf("ErrorMessage: %s", err)
 	}
 	if want := testDefaultConfig; !reflect.DeepEqual(got, want) {
-		// TODO(kevlar): this is broken?
-		t.Logf("config = %#v, want %#v", got, want)
+		t.Errorf("config = %#v, want %#v", got, want)
 	}
 }
 
@@ -108,3 +150,67 @@ func TestPortRanges(t *testing.T) {
 		}
 	}
 }
+
+var badPortRanges = []string{
+	"0",
+	"65536",
+	"6669-6666",
+	"6660-70000",
+	"6667,irc",
+}
+
+func TestBadPortRanges(t *testing.T) {
+	for _, rng := range badPortRanges {
+		directive := &Ports{
+			PortString: rng,
+		}
+		if ports, err := directive.GetPortList(); err == nil {
+			t.Errorf("GetPortList(%q) = %v, want error", rng, ports)
+		}
+	}
+}
+
+func TestPasswordCheck(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	sum := sha256.Sum256([]byte("secret"))
+
+	tests := []*Password{
+		{Type: "", Password: "secret"},
+		{Type: "plain", Password: "secret"},
+		{Type: "bcrypt", Password: string(hash)},
+		{Type: "sha256", Password: hex.EncodeToString(sum[:])},
+	}
+	for _, p := range tests {
+		if !p.Check("secret") {
+			t.Errorf("%q: Check(correct) = false, want true", p.Type)
+		}
+		if p.Check("wrong") {
+			t.Errorf("%q: Check(wrong) = true, want false", p.Type)
+		}
+	}
+	if p := (&Password{Type: "rot13", Password: "frperg"}); p.Check("secret") {
+		t.Errorf("unknown type: Check succeeded unexpectedly")
+	}
+}
+
+var matchHostTests = []struct {
+	User, Host, IP string
+	Match          bool
+}{
+	{"god", "localhost", "127.0.0.1", true},
+	{"god", "mail.google.com", "1.2.3.4", true},
+	{"god", "example.com", "1.2.3.4", false},
+	{"admin", "example.com", "10.0.0.1", true},
+	{"other", "example.com", "10.0.0.1", false},
+	{"god", "example.net", "192.168.1.20", true},
+	{"god", "example.net", "192.168.2.20", false},
+}
+
+func TestMatchHost(t *testing.T) {
+	oper := &Oper{Host: []string{"127.0.0.1", "*.google.com", "admin@10.*", "god@192.168.1.0/24"}}
+	for _, test := range matchHostTests {
+		if got, want := oper.MatchHost(test.User, test.Host, test.IP), test.Match; got != want {
+			t.Errorf("MatchHost(%q, %q, %q) = %v, want %v", test.User, test.Host, test.IP, got, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration file formats
+const (
+	FormatXML  = "xml"
+	FormatTOML = "toml"
+	FormatYAML = "yaml"
+)
+
+// ConfigFormat returns the format of a configuration file from its
+// extension: .toml is TOML, .yaml and .yml are YAML and anything else is XML.
+func ConfigFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".toml":
+		return FormatTOML
+	case ".yaml", ".yml":
+		return FormatYAML
+	}
+	return FormatXML
+}
+
+// Read a configuration file in the format given by its extension.  Unlike
+// XML, unknown TOML keys and YAML fields are errors.
+func readConfigFile(filename string) (*Configuration, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(ConfigFormat(filename), data)
+}
+
+// Parse a configuration in the given format.
+func parseConfig(format string, data []byte) (conf *Configuration, err error) {
+	switch format {
+	case FormatXML:
+		return parseXMLConfig(data)
+	case FormatTOML:
+		conf = &Configuration{}
+		meta, err := toml.Decode(string(data), conf)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := []string{}
+			for _, key := range undecoded {
+				keys = append(keys, key.String())
+			}
+			sort.Strings(keys)
+			return nil, errors.New("unknown keys: " + strings.Join(keys, ", "))
+		}
+		return conf, nil
+	case FormatYAML:
+		conf = &Configuration{}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(conf); err != nil {
+			return nil, err
+		}
+		return conf, nil
+	}
+	return nil, errors.New("unknown configuration format " + format)
+}
+
+// Encode a configuration in the given format, which must be TOML or YAML.
+func encodeConfig(format string, conf *Configuration) ([]byte, error) {
+	switch format {
+	case FormatTOML:
+		buf := new(bytes.Buffer)
+		if err := toml.NewEncoder(buf).Encode(conf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case FormatYAML:
+		buf := new(bytes.Buffer)
+		enc := yaml.NewEncoder(buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(conf); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, errors.New("cannot write " + format + " configuration files")
+}
+
+// ConvertConfigFile converts an XML configuration file to TOML or YAML, as
+// given by the extension of the file to write.
+func ConvertConfigFile(from, to string) error {
+	if ConfigFormat(from) != FormatXML {
+		return errors.New(from + " is not an XML configuration file")
+	}
+	conf, err := readConfigFile(from)
+	if err != nil {
+		return err
+	}
+	data, err := encodeConfig(ConfigFormat(to), conf)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(to, data, 0600)
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var configFormatTests = []struct {
+	File, Format string
+}{
+	{"/etc/ircd.conf", FormatXML},
+	{"ircd.xml", FormatXML},
+	{"ircd.toml", FormatTOML},
+	{"ircd.YAML", FormatYAML},
+	{"conf.d/ircd.yml", FormatYAML},
+}
+
+func TestConfigFormat(t *testing.T) {
+	for _, test := range configFormatTests {
+		if got, want := ConfigFormat(test.File), test.Format; got != want {
+			t.Errorf("ConfigFormat(%q) = %q, want %q", test.File, got, want)
+		}
+	}
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatTOML, FormatYAML} {
+		data, err := encodeConfig(format, testDefaultConfig)
+		if err != nil {
+			t.Errorf("%s: encode: %s", format, err)
+			continue
+		}
+		got, err := parseConfig(format, data)
+		if err != nil {
+			t.Errorf("%s: parse: %s\n%s", format, err, data)
+			continue
+		}
+		if want := testDefaultConfig; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: config = %#v, want %#v", format, got, want)
+		}
+		if errs := validateValues(got); len(errs) > 0 {
+			t.Errorf("%s: validateValues = %v, want no errors", format, errs)
+		}
+	}
+}
+
+func TestConvertConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "convertconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	from := filepath.Join(dir, "ircd.conf")
+	if err := ioutil.WriteFile(from, []byte(DefaultXML), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ircd.toml", "ircd.yaml"} {
+		to := filepath.Join(dir, name)
+		if err := ConvertConfigFile(from, to); err != nil {
+			t.Errorf("ConvertConfigFile(%q): %s", name, err)
+			continue
+		}
+		got, err := readConfigFile(to)
+		if err != nil {
+			t.Errorf("%s: read: %s", name, err)
+			continue
+		}
+		if want := testDefaultConfig; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: config = %#v, want %#v", name, got, want)
+		}
+	}
+	if err := ConvertConfigFile(from, filepath.Join(dir, "ircd.xml")); err == nil {
+		t.Errorf("converting to XML succeeded, want error")
+	}
+}
+
+var badConfigTests = []struct {
+	Format string
+	Config string
+	Error  string
+}{
+	{FormatTOML, "name = \"a.b\"\nsid = \"0AA\"\nadmn = \"me\"\n", "unknown keys: admn"},
+	{FormatTOML, "[[class]]\nname = \"users\"\nhosts = [\"*\"]\n", "unknown keys: class.hosts"},
+	{FormatYAML, "name: a.b\nadmn: me\n", "field admn not found"},
+}
+
+func TestBadConfig(t *testing.T) {
+	for _, test := range badConfigTests {
+		_, err := parseConfig(test.Format, []byte(test.Config))
+		if err == nil || !strings.Contains(err.Error(), test.Error) {
+			t.Errorf("%s %q: error = %v, want %q", test.Format, test.Config, err, test.Error)
+		}
+	}
+}
+
+func TestValidateValues(t *testing.T) {
+	conf := &Configuration{
+		Ports:     []*Ports{{PortString: "6667"}, {PortString: "6667-6668"}, {PortString: "0"}},
+		Class:     []*Class{{Name: "users", Host: []string{"*@*"}}},
+		OperClass: []*OperClass{{Name: "helper", Privilege: []string{"kill:local", "kill"}}},
+		Operator: []*Oper{{
+			Name:  "god",
+			Class: "staff",
+			Host:  []string{"god@*"},
+			Flag:  []string{"root"},
+		}},
+		Network: &Network{Link: []*Link{{Name: "hub.local", Host: []string{"hub.local"}}}},
+	}
+	var got []string
+	for _, err := range validateValues(conf) {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`duplicate port 6667`,
+		`invalid ports "0": Port out of range: 0`,
+		`class "users": host mask "*@*" may not have a username`,
+		`operator class "helper": unknown privilege "kill"`,
+		`operator "god": undefined operator class "staff"`,
+		`operator "god": unknown operator flag "root"`,
+		`link to "hub.local" has no password`,
+	}
+	if g, w := strings.Join(got, "\n"), strings.Join(want, "\n"); g != w {
+		t.Errorf("errors:\n%s\nwant:\n%s", g, w)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/kylelemons/ircd-blight/old/ircd/log"
 	"github.com/kylelemons/ircd-blight/old/ircd/parser"
 	"github.com/kylelemons/ircd-blight/old/ircd/server"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
 )
 
 var (
@@ -12,14 +13,26 @@ var (
 		Register(parser.CMD_PING, Server, OptArgs(1, 1), SPing),
 		Register(parser.CMD_PONG, Server, OptArgs(1, 1), SPing),
 	}
+	numerichooks = registerNumerics()
+	encaphooks   = []*Hook{
+		Register(parser.CMD_ENCAP, Server, MinArgs(2), Encap),
+	}
 )
 
+// Numerics from other servers are replies to be relayed to a user.
+func registerNumerics() (hooks []*Hook) {
+	for num := range parser.NumericName {
+		hooks = append(hooks, Register(num, Server, MinArgs(1), SNumeric))
+	}
+	return
+}
+
 func Ping(hook string, msg *parser.Message, ircd *IRCd) {
 	pongmsg := msg.Args[0]
 	ircd.ToClient <- &parser.Message{
 		Command: parser.CMD_PONG,
 		Args: []string{
-			Config.Name,
+			Config().Name,
 			pongmsg,
 		},
 		DestIDs: []string{
@@ -29,20 +42,21 @@ func Ping(hook string, msg *parser.Message, ircd *IRCd) {
 }
 
 func SPing(hook string, msg *parser.Message, ircd *IRCd) {
+	conf := Config()
 	source := msg.Args[0]
-	dest := Config.SID
+	dest := conf.SID
 	if len(msg.Args) > 1 {
 		dest = msg.Args[1]
 	}
 
-	if dest == Config.SID {
+	if dest == conf.SID {
 		switch hook {
 		case parser.CMD_PING:
 			ircd.ToServer <- &parser.Message{
-				Prefix:  Config.SID,
+				Prefix:  conf.SID,
 				Command: parser.CMD_PONG,
 				Args: []string{
-					Config.Name,
+					conf.Name,
 					source,
 				},
 				DestIDs: []string{
@@ -61,3 +75,117 @@ func SPing(hook string, msg *parser.Message, ircd *IRCd) {
 		}
 	}
 }
+
+// ENCAP <target mask> <subcommand> [<params>...]
+func Encap(hook string, msg *parser.Message, ircd *IRCd) {
+	target, subcommand := msg.Args[0], msg.Args[1]
+
+	// Forward
+	for sid := range server.Iter() {
+		if sid != msg.SenderID {
+			fmsg := msg.Dup()
+			fmsg.DestIDs = []string{sid}
+			ircd.ToServer <- fmsg
+		}
+	}
+
+	if !parser.Match(target, Config().Name) {
+		return
+	}
+
+	switch subcommand {
+	case "LOGIN":
+		// :<uid> ENCAP * LOGIN <account>
+		if len(msg.Args) < 3 {
+			return
+		}
+		if u, ok := user.Lookup(msg.Prefix); ok {
+			u.SetAccount(msg.Args[2])
+			notifyAccount(u, ircd)
+		}
+	case "SU":
+		// :<sid> ENCAP * SU <uid> [<account>]
+		if len(msg.Args) < 3 {
+			return
+		}
+		if u, ok := user.Lookup(msg.Args[2]); ok {
+			account := ""
+			if len(msg.Args) > 3 {
+				account = msg.Args[3]
+			}
+			u.SetAccount(account)
+			notifyAccount(u, ircd)
+		}
+	case "CHGHOST", "CHGIDENT":
+		// :<source> ENCAP * CHGHOST <uid> <host>
+		// :<source> ENCAP * CHGIDENT <uid> <ident>
+		if len(msg.Args) < 4 {
+			return
+		}
+		if u, ok := user.Lookup(msg.Args[2]); ok {
+			if subcommand == "CHGHOST" {
+				changeIdentHost(u, "", msg.Args[3], ircd)
+			} else {
+				changeIdentHost(u, msg.Args[3], "", ircd)
+			}
+		}
+	case parser.CMD_SETNAME:
+		// :<uid> ENCAP * SETNAME :<realname>
+		if len(msg.Args) < 3 {
+			return
+		}
+		if u, ok := user.Lookup(msg.Prefix); ok {
+			changeName(u, msg.Args[2], ircd)
+		}
+	case parser.CMD_KLINE, parser.CMD_DLINE, parser.CMD_XLINE,
+		parser.CMD_UNKLINE, parser.CMD_UNDLINE, parser.CMD_UNXLINE:
+		encapBan(subcommand, msg.Args[2:], msg, ircd)
+	default:
+		log.Debug.Printf("Ignoring ENCAP %s from %s", subcommand, msg.SenderID)
+	}
+}
+
+// Relay a numeric reply from another server towards the user it is for.
+func SNumeric(hook string, msg *parser.Message, ircd *IRCd) {
+	dest := msg.Args[0]
+	if !isuid(dest) {
+		log.Warn.Printf("Dropping %s from %s for %q", hook, msg.SenderID, dest)
+		return
+	}
+
+	if dest[:3] == Config().SID {
+		fmsg := msg.Dup()
+		fmsg.DestIDs = []string{dest}
+		ircd.ToClient <- fmsg
+		return
+	}
+
+	for sid := range server.IterFor([]string{dest}, msg.SenderID) {
+		log.Debug.Printf("Forwarding %s from %s to %s", hook, msg.SenderID, sid)
+		fmsg := msg.Dup()
+		fmsg.DestIDs = []string{sid}
+		ircd.ToServer <- fmsg
+	}
+}
+
+// Send a reply (usually a numeric) to the user in its DestIDs, who may be on
+// another server.  Replies to remote users are sent from this server's SID
+// with the user's ID in place of the "*" for their nick.
+func sendReply(msg *parser.Message, ircd *IRCd) {
+	conf := Config()
+	dest := msg.DestIDs[0]
+	if dest[:3] == conf.SID {
+		ircd.ToClient <- msg
+		return
+	}
+
+	msg.Prefix = conf.SID
+	if len(msg.Args) > 0 && msg.Args[0] == "*" {
+		msg.Args[0] = dest
+	}
+	for sid := range server.IterFor([]string{dest}, "") {
+		fmsg := msg.Dup()
+		fmsg.DestIDs = []string{sid}
+		ircd.ToServer <- fmsg
+	}
+}
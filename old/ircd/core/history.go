@@ -0,0 +1,345 @@
+package core
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/channel"
+	"github.com/kylelemons/ircd-blight/old/ircd/history"
+	"github.com/kylelemons/ircd-blight/old/ircd/log"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+var (
+	historyhooks = []*Hook{
+		Register(parser.CMD_CHATHISTORY, User, MinArgs(4), Chathistory),
+	}
+	historysupport = []string{
+		Support("CHATHISTORY", func() string { return strconv.Itoa(historyLimit()) }),
+		Support("MSGREFTYPES", func() string { return "timestamp,msgid" }),
+	}
+	chathistory = Capability("draft/chathistory", nil)
+)
+
+var (
+	// The most messages returned for one CHATHISTORY request, unless the
+	// configuration's <history> sets a limit.
+	HistoryLimit = 100
+
+	// Whether channel members can only see the messages sent since they
+	// joined, unless the configuration's <history> says so.
+	HistoryJoinedOnly = false
+
+	// How long a private conversation is kept after its last message, and
+	// how often conversations are checked for expiry.
+	HistoryIdle  = 24 * time.Hour
+	HistoryCheck = 10 * time.Minute
+)
+
+// Get the most messages returned for one CHATHISTORY request.
+func historyLimit() int {
+	if conf := Config(); conf != nil && conf.History != nil && conf.History.Limit > 0 {
+		return conf.History.Limit
+	}
+	return HistoryLimit
+}
+
+// Get whether channel members can only see the messages sent since they
+// joined.
+func historyJoinedOnly() bool {
+	if conf := Config(); conf != nil && conf.History != nil && conf.History.JoinedOnly {
+		return true
+	}
+	return HistoryJoinedOnly
+}
+
+// Get the party a user's private conversations are kept under: their account
+// if they are logged in, so that they can see them from any session, and
+// otherwise their UID, which is never reused.  Nicks are not used, since
+// anyone could take a nick and read its owner's conversations.
+func historyParty(u *user.User) string {
+	if account := u.Account(); len(account) > 0 {
+		return "account:" + account
+	}
+	return u.ID()
+}
+
+// Get the nick of a user online with the given history party.
+func partyNick(party string) (nick string, ok bool) {
+	if !strings.HasPrefix(party, "account:") {
+		nick, _, _, _, ok = user.GetInfo(party)
+		return
+	}
+	for uid := range user.Iter() {
+		if ok {
+			continue // Iter must be drained
+		}
+		if u, found := user.Lookup(uid); found && historyParty(u) == party {
+			nick, ok = u.Nick(), true
+		}
+	}
+	return
+}
+
+// Forget the private conversations which have gone quiet or which were with
+// a user (not an account) who has since left.
+func expireHistory() {
+	gone := func(party string) bool {
+		if strings.HasPrefix(party, "account:") {
+			return false
+		}
+		_, ok := user.Lookup(party)
+		return !ok
+	}
+	if n := history.ExpireConversations(time.Now().Add(-HistoryIdle), gone); n > 0 {
+		log.Debug.Printf("Expired the history of %d conversations", n)
+	}
+}
+
+// Record a PRIVMSG or NOTICE in the history with the given key and get the
+// tags it should be delivered with: the client tags plus its msgid and time.
+func recordHistory(key, command, sender, target, text string, tags map[string]string) map[string]string {
+	prefix := sender
+	if u, ok := user.Lookup(sender); ok {
+		prefix = u.Hostmask()
+	}
+
+	entry := history.Entry{
+		ID:      history.NewID(),
+		Time:    time.Now().UTC(),
+		Prefix:  prefix,
+		Command: command,
+		Target:  target,
+		Text:    text,
+		Tags:    tags,
+	}
+	history.Get(key, true).Add(entry)
+
+	out := make(map[string]string, len(tags)+2)
+	for key, value := range tags {
+		out[key] = value
+	}
+	out["msgid"] = entry.ID
+	out["time"] = entry.Time.Format(history.TimeFormat)
+	return out
+}
+
+// Get the messages in a log as they should be replayed to uid.
+func historyMessages(entries history.Log, uid string) []*parser.Message {
+	msgs := make([]*parser.Message, 0, len(entries))
+	for _, e := range entries {
+		tags := make(map[string]string, len(e.Tags)+2)
+		for key, value := range e.Tags {
+			tags[key] = value
+		}
+		tags["msgid"] = e.ID
+		tags["time"] = e.Time.Format(history.TimeFormat)
+		msgs = append(msgs, &parser.Message{
+			Tags:    tags,
+			Prefix:  e.Prefix,
+			Command: e.Command,
+			Args: []string{
+				e.Target,
+				e.Text,
+			},
+			DestIDs: []string{uid},
+		})
+	}
+	return msgs
+}
+
+// Get the history of a channel or private conversation that u may see.
+func historyFor(u *user.User, target string) (entries history.Log, ok bool) {
+	key, since := "", time.Time{}
+	if parser.ValidChannel(target) {
+		c, err := channel.Get(target, false)
+		if err != nil {
+			return nil, false
+		}
+		joined, on := c.Joined(u.ID())
+		if !on {
+			return nil, false
+		}
+		if historyJoinedOnly() {
+			since = joined
+		}
+		key = history.ChannelKey(c.Name())
+	} else if parser.ValidNick(target) {
+		// Only conversations with whoever has the nick now can be seen
+		id, err := user.GetID(target)
+		if err != nil {
+			return nil, true
+		}
+		other, ok := user.Lookup(id)
+		if !ok {
+			return nil, true
+		}
+		key = history.ConversationKey(historyParty(u), historyParty(other))
+	} else {
+		return nil, false
+	}
+
+	if b := history.Get(key, false); b != nil {
+		entries = b.Since(since)
+	}
+	return entries, true
+}
+
+// CHATHISTORY LATEST <target> <*|msgref> <limit>
+// CHATHISTORY BEFORE|AFTER|AROUND <target> <msgref> <limit>
+// CHATHISTORY BETWEEN <target> <msgref> <msgref> <limit>
+// CHATHISTORY TARGETS <timestamp> <timestamp> <limit>
+//
+// Message references are msgid=<id> or timestamp=<time>.  The messages are
+// sent in a chathistory BATCH.
+func Chathistory(hook string, msg *parser.Message, ircd *IRCd) {
+	uid := msg.SenderID
+	u, ok := user.Lookup(uid)
+	if !ok {
+		log.Warn.Printf("CHATHISTORY from unknown user %s", uid)
+		return
+	}
+
+	subcommand := strings.ToUpper(msg.Args[0])
+	fail := func(code string, context ...string) {
+		args := append([]string{parser.CMD_CHATHISTORY, code, subcommand}, context...)
+		ircd.ToClient <- &parser.Message{
+			Command: parser.CMD_FAIL,
+			Args:    append(args, "Messages could not be retrieved"),
+			DestIDs: []string{uid},
+		}
+	}
+
+	// The number of message references each subcommand takes
+	nrefs := map[string]int{
+		"LATEST":  1,
+		"BEFORE":  1,
+		"AFTER":   1,
+		"AROUND":  1,
+		"BETWEEN": 2,
+		"TARGETS": 2,
+	}
+	n, known := nrefs[subcommand]
+	if !known {
+		fail("UNKNOWN_COMMAND")
+		return
+	}
+	want := n + 3 // the subcommand, target, references and limit
+	if subcommand == "TARGETS" {
+		want = n + 2 // TARGETS has no target
+	}
+	if len(msg.Args) != want {
+		fail("INVALID_PARAMS")
+		return
+	}
+
+	limit, err := strconv.Atoi(msg.Args[len(msg.Args)-1])
+	if err != nil || limit < 1 {
+		fail("INVALID_PARAMS", msg.Args[len(msg.Args)-1])
+		return
+	}
+	if max := historyLimit(); limit > max {
+		limit = max
+	}
+
+	if subcommand == "TARGETS" {
+		from, ok1 := history.ParseRef(msg.Args[1])
+		to, ok2 := history.ParseRef(msg.Args[2])
+		if !ok1 || !ok2 || from.Time.IsZero() || to.Time.IsZero() {
+			fail("INVALID_PARAMS")
+			return
+		}
+		for _, m := range historyTargets(u, from.Time, to.Time, limit) {
+			ircd.ToClient <- m
+		}
+		return
+	}
+
+	refs := []history.Ref{}
+	for _, arg := range msg.Args[2 : len(msg.Args)-1] {
+		ref, ok := history.ParseRef(arg)
+		if !ok || ref.IsZero() && subcommand != "LATEST" {
+			fail("INVALID_PARAMS", arg)
+			return
+		}
+		refs = append(refs, ref)
+	}
+
+	target := msg.Args[1]
+	entries, ok := historyFor(u, target)
+	if !ok {
+		fail("INVALID_TARGET", target)
+		return
+	}
+
+	switch subcommand {
+	case "LATEST":
+		entries = entries.Latest(refs[0], limit)
+	case "BEFORE":
+		entries = entries.Before(refs[0], limit)
+	case "AFTER":
+		entries = entries.After(refs[0], limit)
+	case "AROUND":
+		entries = entries.Around(refs[0], limit)
+	case "BETWEEN":
+		entries = entries.Between(refs[0], refs[1], limit)
+	}
+
+	for _, m := range wrapBatch("chathistory", nil, []string{target}, historyMessages(entries, uid), uid) {
+		ircd.ToClient <- m
+	}
+}
+
+// Get the batch listing the channels and conversations of u with messages
+// between the two times, up to limit, in the order of their latest message.
+func historyTargets(u *user.User, from, to time.Time, limit int) []*parser.Message {
+	type target struct {
+		name   string
+		latest time.Time
+	}
+	if from.After(to) {
+		from, to = to, from
+	}
+
+	targets := []target{}
+	add := func(name string, b *history.Buffer) {
+		if b == nil {
+			return
+		}
+		if latest := b.Latest(); latest.After(from) && latest.Before(to) {
+			targets = append(targets, target{name, latest})
+		}
+	}
+	for _, c := range channel.UserChannels(u.ID()) {
+		add(c.Name(), history.Get(history.ChannelKey(c.Name()), false))
+	}
+	for party, b := range history.Conversations(historyParty(u)) {
+		if nick, ok := partyNick(party); ok {
+			add(nick, b)
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].latest.Before(targets[j].latest)
+	})
+	if len(targets) > limit {
+		targets = targets[:limit]
+	}
+
+	msgs := make([]*parser.Message, 0, len(targets))
+	for _, t := range targets {
+		msgs = append(msgs, &parser.Message{
+			Command: parser.CMD_CHATHISTORY,
+			Args: []string{
+				"TARGETS",
+				t.name,
+				"timestamp=" + t.latest.Format(history.TimeFormat),
+			},
+			DestIDs: []string{u.ID()},
+		})
+	}
+	return wrapBatch("draft/chathistory-targets", nil, nil, msgs, u.ID())
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/channel"
+	"github.com/kylelemons/ircd-blight/old/ircd/history"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+func TestChathistory(t *testing.T) {
+	user.Get("000AAACH1")
+	defer user.Delete("000AAACH1")
+	user.Get("000AAACH2")
+	defer user.Delete("000AAACH2")
+
+	c, _ := channel.Get("#chathistory", true)
+	c.Join("000AAACH1")
+	defer channel.PartAll("000AAACH1")
+	for i := 0; i < 5; i++ {
+		recordHistory(history.ChannelKey(c.Name()), parser.CMD_PRIVMSG, "000AAACH1", c.Name(), strconv.Itoa(i), nil)
+	}
+	time.Sleep(2 * time.Millisecond)
+	c.Join("000AAACH2")
+	defer channel.PartAll("000AAACH2")
+	recordHistory(history.ChannelKey(c.Name()), parser.CMD_PRIVMSG, "000AAACH2", c.Name(), "5", nil)
+
+	query := func(uid string, args ...string) (cmds, texts string) {
+		for _, msg := range runHook(Chathistory, parser.CMD_CHATHISTORY, uid, args...) {
+			cmds += msg.Command + " "
+			if msg.Command == parser.CMD_PRIVMSG {
+				texts += msg.Args[1]
+			}
+		}
+		return
+	}
+
+	if _, got := query("000AAACH1", "LATEST", "#chathistory", "*", "3"); got != "345" {
+		t.Errorf("LATEST * 3: got %q, want %q", got, "345")
+	}
+	if cmds, _ := query("000AAACH1", "LATEST", "#chathistory", "*", "1"); cmds != "BATCH PRIVMSG BATCH " {
+		t.Errorf("LATEST * 1: got %q, want a batch", cmds)
+	}
+	if cmds, _ := query("000AAACH1", "LATEST", "#nosuchchannel", "*", "1"); cmds != "FAIL " {
+		t.Errorf("unknown channel: got %q, want FAIL", cmds)
+	}
+	if cmds, _ := query("000AAACH1", "BEFORE", "#chathistory", "*", "1"); cmds != "FAIL " {
+		t.Errorf("BEFORE *: got %q, want FAIL", cmds)
+	}
+
+	HistoryJoinedOnly = true
+	defer func() { HistoryJoinedOnly = false }()
+	if _, got := query("000AAACH2", "LATEST", "#chathistory", "*", "10"); got != "5" {
+		t.Errorf("joined only: got %q, want %q", got, "5")
+	}
+}
+
+func TestConversationHistory(t *testing.T) {
+	alice := user.Get("000AAACH3")
+	defer user.Delete("000AAACH3")
+	alice.SetNick("alice")
+	bob := user.Get("000AAACH4")
+	defer user.Delete("000AAACH4")
+	bob.SetNick("bob")
+
+	key := history.ConversationKey(historyParty(alice), historyParty(bob))
+	recordHistory(key, parser.CMD_PRIVMSG, "000AAACH3", "bob", "secret", nil)
+	defer history.Delete(key)
+
+	query := func(u *user.User, target string) int {
+		entries, _ := historyFor(u, target)
+		return len(entries)
+	}
+	if got := query(bob, "alice"); got != 1 {
+		t.Errorf("bob's history with alice has %d messages, want 1", got)
+	}
+
+	// Someone else taking alice's nick must not see her conversations
+	alice.SetNick("alice2")
+	mallory := user.Get("000AAACH5")
+	defer user.Delete("000AAACH5")
+	mallory.SetNick("alice")
+	if got := query(mallory, "bob"); got != 0 {
+		t.Errorf("new alice's history with bob has %d messages, want 0", got)
+	}
+	if got := query(alice, "bob"); got != 1 {
+		t.Errorf("renamed alice's history with bob has %d messages, want 1", got)
+	}
+
+	// Once alice has gone, the conversation is forgotten
+	user.Delete("000AAACH3")
+	expireHistory()
+	if history.Get(key, false) != nil {
+		t.Errorf("conversation with a user who has left was not expired")
+	}
+}
@@ -91,10 +91,18 @@ func DispatchClient(message *parser.Message, ircd *IRCd) {
 	case user.RegisteredAsUser:
 		mask |= User
 	}
+	label, labeled := message.Tags["label"]
+	if u, ok := user.Lookup(message.SenderID); labeled && (!ok || !u.HasCap(labeledresponse)) {
+		labeled = false
+	}
 	for _, hook := range registeredHooks[hookName] {
 		if hook.When&mask == mask {
 			// TODO(kevlar): Check callconstraints
-			go hook.Func(hookName, message, ircd)
+			if labeled {
+				go runLabeled(hook.Func, hookName, message, ircd, label)
+			} else {
+				go hook.Func(hookName, message, ircd)
+			}
 			hook.Calls++
 		}
 	}
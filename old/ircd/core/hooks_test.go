@@ -82,3 +82,22 @@ func TestRegisterDispatch(t *testing.T) {
 	}
 	*/
 }
+
+// Call a hook with a message from sender and collect what it sends to
+// clients.  Anything it sends to servers is discarded.
+func runHook(fn func(string, *parser.Message, *IRCd), cmd, sender string, args ...string) (msgs []*parser.Message) {
+	ircd := &IRCd{
+		ToClient: make(chan *parser.Message, 100),
+		ToServer: make(chan *parser.Message, 100),
+	}
+	fn(cmd, &parser.Message{
+		SenderID: sender,
+		Command:  cmd,
+		Args:     args,
+	}, ircd)
+	close(ircd.ToClient)
+	for msg := range ircd.ToClient {
+		msgs = append(msgs, msg)
+	}
+	return
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"github.com/kylelemons/ircd-blight/old/ircd/log"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/server"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+var (
+	identityhooks = []*Hook{
+		Register(parser.CMD_SETNAME, User, NArgs(1), Setname),
+		Register(parser.CMD_CHGHOST, Server, NArgs(2), Chghost),
+	}
+	accountnotify = Capability("account-notify", nil)
+	chghostcap    = Capability("chghost", nil)
+	setnamecap    = Capability("setname", nil)
+)
+
+// Get the local users who should see a change to uid announced with the
+// given capability: those sharing a channel with them and, if they are
+// local, the user themselves.
+func capAudience(uid, capname string) []string {
+	peers := capPeers(uid, capname)
+	if uid[:3] == Config().SID {
+		if u, ok := user.Lookup(uid); ok && u.HasCap(capname) {
+			peers = append(peers, uid)
+		}
+	}
+	return peers
+}
+
+// Tell account-notify clients on a channel with the user that they logged
+// in to or out of an account.
+func notifyAccount(u *user.User, ircd *IRCd) {
+	uid := u.ID()
+	account := u.Account()
+	if len(account) == 0 {
+		account = "*"
+	}
+
+	if peers := capPeers(uid, accountnotify); len(peers) > 0 {
+		ircd.ToClient <- &parser.Message{
+			Prefix:  uid,
+			Command: parser.CMD_ACCOUNT,
+			Args: []string{
+				account,
+			},
+			DestIDs: peers,
+		}
+	}
+}
+
+// Change the username and/or visible hostname of a user (an empty value is
+// left unchanged) and tell chghost clients that can see them.  The change is
+// not propagated to other servers.
+func changeIdentHost(u *user.User, ident, host string, ircd *IRCd) {
+	oldmask := u.Hostmask()
+	if len(ident) == 0 {
+		ident = u.User()
+	}
+	if len(host) == 0 {
+		host = u.Host()
+	}
+	if ident == u.User() && host == u.Host() {
+		return
+	}
+	u.SetIdentHost(ident, host)
+	log.Info.Printf("[%s] Changed %s to %s", u.ID(), oldmask, u.Hostmask())
+
+	if peers := capAudience(u.ID(), chghostcap); len(peers) > 0 {
+		ircd.ToClient <- &parser.Message{
+			Prefix:  oldmask,
+			Command: parser.CMD_CHGHOST,
+			Args: []string{
+				ident,
+				host,
+			},
+			DestIDs: peers,
+		}
+	}
+}
+
+// Change the real name of a user and tell setname clients that can see them.
+// The change is not propagated to other servers.
+func changeName(u *user.User, name string, ircd *IRCd) error {
+	if err := u.SetName(name); err != nil {
+		return err
+	}
+
+	if peers := capAudience(u.ID(), setnamecap); len(peers) > 0 {
+		ircd.ToClient <- &parser.Message{
+			Prefix:  u.ID(),
+			Command: parser.CMD_SETNAME,
+			Args: []string{
+				name,
+			},
+			DestIDs: peers,
+		}
+	}
+	return nil
+}
+
+// SETNAME :<realname>
+func Setname(hook string, msg *parser.Message, ircd *IRCd) {
+	uid, name := msg.SenderID, msg.Args[0]
+	u, ok := user.Lookup(uid)
+	if !ok {
+		log.Warn.Printf("SETNAME from unknown user %s", uid)
+		return
+	}
+
+	// SETNAME only exists for clients which have asked for it
+	if !u.HasCap(setnamecap) {
+		ircd.ToClient <- parser.NewNumeric(parser.ERR_UNKNOWNCOMMAND, parser.CMD_SETNAME).Message(uid)
+		return
+	}
+
+	if err := changeName(u, name, ircd); err != nil {
+		ircd.ToClient <- &parser.Message{
+			Command: parser.CMD_FAIL,
+			Args: []string{
+				parser.CMD_SETNAME,
+				"INVALID_REALNAME",
+				"Realname is not valid",
+			},
+			DestIDs: []string{uid},
+		}
+		return
+	}
+
+	for sid := range server.Iter() {
+		ircd.ToServer <- &parser.Message{
+			Prefix:  uid,
+			Command: parser.CMD_ENCAP,
+			Args: []string{
+				"*",
+				parser.CMD_SETNAME,
+				name,
+			},
+			DestIDs: []string{sid},
+		}
+	}
+}
+
+// Server CHGHOST <uid> <host>
+func Chghost(hook string, msg *parser.Message, ircd *IRCd) {
+	uid, host := msg.Args[0], msg.Args[1]
+
+	// Forward
+	for sid := range server.Iter() {
+		if sid != msg.SenderID {
+			fmsg := msg.Dup()
+			fmsg.DestIDs = []string{sid}
+			ircd.ToServer <- fmsg
+		}
+	}
+
+	u, ok := user.Lookup(uid)
+	if !ok {
+		log.Warn.Printf("CHGHOST for unknown user %s", uid)
+		return
+	}
+	changeIdentHost(u, "", host, ircd)
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/channel"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+func TestJoinMessages(t *testing.T) {
+	joiner := user.Get("000AAAEJ1")
+	defer user.Delete("000AAAEJ1")
+	joiner.SetUser("joiner", "Joining User")
+	joiner.SetAccount("alice")
+	user.Get("000AAAEJ2")
+	defer user.Delete("000AAAEJ2")
+	extended := user.Get("000AAAEJ3")
+	defer user.Delete("000AAAEJ3")
+	extended.SetCap(extendedjoin, true)
+
+	c, _ := channel.Get("#extendedjoin", true)
+	msgs := joinMessages(c, "000AAAEJ1", []string{"000AAAEJ2", "000AAAEJ3"})
+	if got, want := len(msgs), 2; got != want {
+		t.Fatalf("got %d messages, want %d", got, want)
+	}
+	if got, want := msgs[0].Args, []string{"#extendedjoin"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("plain JOIN args = %q, want %q", got, want)
+	}
+	want := []string{"#extendedjoin", "alice", "Joining User"}
+	if got := msgs[1].Args; len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("extended JOIN args = %q, want %q", got, want)
+	}
+	if got := msgs[1].DestIDs; len(got) != 1 || got[0] != "000AAAEJ3" {
+		t.Errorf("extended JOIN sent to %q, want [000AAAEJ3]", got)
+	}
+
+	joiner.SetAccount("")
+	msgs = joinMessages(c, "000AAAEJ1", []string{"000AAAEJ3"})
+	if got := msgs[0].Args; len(got) != 3 || got[1] != "*" {
+		t.Errorf("logged out: extended JOIN args = %q, want account *", got)
+	}
+}
+
+func TestSetname(t *testing.T) {
+	SetConfig(&Configuration{SID: "000"})
+	defer SetConfig(nil)
+
+	u := user.Get("000AAASN1")
+	defer user.Delete("000AAASN1")
+	u.SetUser("user", "Old Name")
+
+	msgs := runHook(Setname, parser.CMD_SETNAME, "000AAASN1", "New Name")
+	if len(msgs) != 1 || msgs[0].Command != parser.ERR_UNKNOWNCOMMAND {
+		t.Errorf("without the setname cap: got %v, want ERR_UNKNOWNCOMMAND", msgs)
+	}
+	if _, _, name, _, _ := user.GetInfo("000AAASN1"); name != "Old Name" {
+		t.Errorf("without the setname cap: name changed to %q", name)
+	}
+
+	u.SetCap(setnamecap, true)
+	runHook(Setname, parser.CMD_SETNAME, "000AAASN1", "New Name")
+	if _, _, name, _, _ := user.GetInfo("000AAASN1"); name != "New Name" {
+		t.Errorf("with the setname cap: name = %q, want %q", name, "New Name")
+	}
+}
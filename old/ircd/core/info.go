@@ -0,0 +1,259 @@
+package core
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/channel"
+	"github.com/kylelemons/ircd-blight/old/ircd/log"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/server"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+var (
+	infohooks = []*Hook{
+		Register(parser.CMD_LUSERS, User|Server, OptArgs(0, 2), Informational),
+		Register(parser.CMD_MOTD, User|Server, OptArgs(0, 1), Informational),
+		Register(parser.CMD_ADMIN, User|Server, OptArgs(0, 1), Informational),
+		Register(parser.CMD_VERSION, User|Server, OptArgs(0, 1), Informational),
+		Register(parser.CMD_INFO, User|Server, OptArgs(0, 1), Informational),
+		Register(parser.CMD_TIME, User|Server, OptArgs(0, 1), Informational),
+	}
+)
+
+var (
+	// The replies to each informational command, for the given user
+	informational = map[string]func(uid string) []*parser.Message{
+		parser.CMD_LUSERS:  lusersReplies,
+		parser.CMD_MOTD:    motdReplies,
+		parser.CMD_ADMIN:   adminReplies,
+		parser.CMD_VERSION: versionReplies,
+		parser.CMD_INFO:    infoReplies,
+		parser.CMD_TIME:    timeReplies,
+	}
+
+	// The lines sent in reply to INFO
+	InfoLines = []string{
+		"ircd-blight is an IRC server written in Go.",
+		"",
+		"It speaks the TS6 server protocol and supports",
+		"many IRCv3 extensions.",
+	}
+
+	// The MOTD is reread when the file changes
+	motdMutex = new(sync.Mutex)
+	motdFile  string
+	motdMod   time.Time
+	motdLines []string
+)
+
+// LUSERS [<mask> [<server>]]
+// MOTD [<server>]
+// ADMIN [<server>]
+// VERSION [<server>]
+// INFO [<server>]
+// TIME [<server>]
+//
+// If a server is given (or the nick of a user on it), the request is passed
+// on to that server, which replies directly to the user.
+func Informational(hook string, msg *parser.Message, ircd *IRCd) {
+	requester := msg.SenderID
+	if len(msg.SenderID) == 3 {
+		requester = msg.Prefix
+	}
+
+	// The server is always the last argument; LUSERS has a mask first
+	target := -1
+	switch {
+	case hook == parser.CMD_LUSERS && len(msg.Args) == 2:
+		target = 1
+	case hook != parser.CMD_LUSERS && len(msg.Args) == 1:
+		target = 0
+	}
+
+	if target >= 0 {
+		sid, ok := msg.Args[target], true
+		if !parser.ValidServerPrefix(sid) {
+			sid, ok = findServer(sid)
+		}
+		if !ok {
+			sendReply(parser.NewNumeric(parser.ERR_NOSUCHSERVER, msg.Args[target]).Message(requester), ircd)
+			return
+		}
+		if sid != Config().SID {
+			args := append([]string(nil), msg.Args...)
+			args[target] = sid
+			for link := range server.IterFor([]string{sid}, msg.SenderID) {
+				log.Debug.Printf("Forwarding %s from %s to %s", hook, requester, link)
+				ircd.ToServer <- &parser.Message{
+					Prefix:  requester,
+					Command: hook,
+					Args:    args,
+					DestIDs: []string{link},
+				}
+			}
+			return
+		}
+	}
+
+	for _, reply := range informational[hook](requester) {
+		sendReply(reply, ircd)
+	}
+}
+
+// Build a numeric reply to uid with the given text in place of its usual
+// text.
+func numericText(num, uid, text string, args ...string) *parser.Message {
+	msg := parser.NewNumeric(num, args...).Message(uid)
+	msg.Args[len(msg.Args)-1] = text
+	return msg
+}
+
+// Construct the LUSERS replies for uid.
+func lusersReplies(uid string) []*parser.Message {
+	stats := user.Count()
+	servers, links := server.Count(), 0
+	for range server.Iter() {
+		links++
+	}
+	itoa := strconv.Itoa
+
+	replies := []*parser.Message{
+		numericText(parser.RPL_LUSERCLIENT, uid, "There are "+itoa(stats.Users-stats.Invisible)+
+			" users and "+itoa(stats.Invisible)+" invisible on "+itoa(servers+1)+" servers"),
+	}
+	if stats.Opers > 0 {
+		replies = append(replies, parser.NewNumeric(parser.RPL_LUSEROP, itoa(stats.Opers)).Message(uid))
+	}
+	if stats.Unknown > 0 {
+		replies = append(replies, parser.NewNumeric(parser.RPL_LUSERUNKNOWN, itoa(stats.Unknown)).Message(uid))
+	}
+	if chans := channel.Count(); chans > 0 {
+		replies = append(replies, parser.NewNumeric(parser.RPL_LUSERCHANNELS, itoa(chans)).Message(uid))
+	}
+	return append(replies,
+		numericText(parser.RPL_LUSERME, uid, "I have "+itoa(stats.Local)+" clients and "+itoa(links)+" servers"),
+		numericText(parser.RPL_LOCALUSERS, uid, "Current local users "+itoa(stats.Local)+", max "+itoa(stats.MaxLocal),
+			itoa(stats.Local), itoa(stats.MaxLocal)),
+		numericText(parser.RPL_GLOBALUSERS, uid, "Current global users "+itoa(stats.Users)+", max "+itoa(stats.MaxGlobal),
+			itoa(stats.Users), itoa(stats.MaxGlobal)),
+	)
+}
+
+// Get the lines of the MOTD file, rereading it if it has changed since it
+// was last read.  If there is no MOTD, nil is returned.
+func motd() []string {
+	motdMutex.Lock()
+	defer motdMutex.Unlock()
+
+	filename := Config().Motd
+	if len(filename) == 0 {
+		return nil
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		log.Warn.Printf("Could not read MOTD: %s", err)
+		return nil
+	}
+	if filename == motdFile && fi.ModTime().Equal(motdMod) {
+		return motdLines
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Warn.Printf("Could not read MOTD: %s", err)
+		return nil
+	}
+	defer file.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		log.Warn.Printf("Could not read MOTD: %s", err)
+		return nil
+	}
+
+	log.Info.Printf("Loaded MOTD from %s", filename)
+	motdFile, motdMod, motdLines = filename, fi.ModTime(), lines
+	return lines
+}
+
+// Construct the MOTD replies for uid.
+func motdReplies(uid string) []*parser.Message {
+	lines := motd()
+	if lines == nil {
+		return []*parser.Message{parser.NewNumeric(parser.ERR_NOMOTD).Message(uid)}
+	}
+
+	replies := make([]*parser.Message, 0, len(lines)+2)
+	replies = append(replies, numericText(parser.RPL_MOTDSTART, uid, "- "+Config().Name+" Message of the day - "))
+	for _, line := range lines {
+		replies = append(replies, numericText(parser.RPL_MOTD, uid, "- "+line))
+	}
+	return append(replies, parser.NewNumeric(parser.RPL_ENDOFMOTD).Message(uid))
+}
+
+// Construct the ADMIN replies for uid.
+func adminReplies(uid string) []*parser.Message {
+	conf := Config()
+	if len(conf.Admin) == 0 {
+		return []*parser.Message{parser.NewNumeric(parser.ERR_NOADMININFO, conf.Name).Message(uid)}
+	}
+
+	network := ""
+	if conf.Network != nil {
+		network = conf.Network.Name
+	}
+	return []*parser.Message{
+		parser.NewNumeric(parser.RPL_ADMINME, conf.Name).Message(uid),
+		numericText(parser.RPL_ADMINLOC1, uid, serverDescription(conf.SID)),
+		numericText(parser.RPL_ADMINLOC2, uid, network),
+		numericText(parser.RPL_ADMINEMAIL, uid, conf.Admin),
+	}
+}
+
+// Construct the VERSION replies (followed by RPL_ISUPPORT) for uid.
+func versionReplies(uid string) []*parser.Message {
+	conf := Config()
+	version := &parser.Message{
+		Command: parser.RPL_VERSION,
+		Args: []string{
+			"*",
+			"ircd-blight/" + REPO_VERSION + ".",
+			conf.Name,
+			"TS6 " + conf.SID,
+		},
+		DestIDs: []string{uid},
+	}
+	return append([]*parser.Message{version}, supportMessages(uid)...)
+}
+
+// Construct the INFO replies for uid.
+func infoReplies(uid string) []*parser.Message {
+	lines := append(append([]string(nil), InfoLines...),
+		"",
+		"Version: "+REPO_VERSION,
+		"Started: "+Started.UTC().Format(time.RFC1123),
+	)
+	replies := make([]*parser.Message, 0, len(lines)+1)
+	for _, line := range lines {
+		replies = append(replies, numericText(parser.RPL_INFO, uid, line))
+	}
+	return append(replies, parser.NewNumeric(parser.RPL_ENDOFINFO).Message(uid))
+}
+
+// Construct the TIME reply for uid.
+func timeReplies(uid string) []*parser.Message {
+	now := time.Now()
+	reply := parser.NewNumeric(parser.RPL_TIME, Config().Name).Message(uid)
+	reply.Args[len(reply.Args)-1] = now.Format(time.RFC1123)
+	return []*parser.Message{reply}
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+)
+
+func TestMotdReplies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "motd")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "motd.txt")
+
+	SetConfig(&Configuration{Name: "test.server", Motd: filename})
+	defer SetConfig(nil)
+
+	if got := motdReplies("000AAAAAA"); len(got) != 1 || got[0].Command != parser.ERR_NOMOTD {
+		t.Errorf("missing file: got %v, want ERR_NOMOTD", got)
+	}
+
+	ioutil.WriteFile(filename, []byte("Hello\r\nWorld\n"), 0644)
+	got := motdReplies("000AAAAAA")
+	if len(got) != 4 || got[1].Args[1] != "- Hello" || got[3].Command != parser.RPL_ENDOFMOTD {
+		t.Errorf("two lines: got %v, want start, 2 lines, end", got)
+	}
+
+	// Changing the file reloads it
+	ioutil.WriteFile(filename, []byte("Goodbye\n"), 0644)
+	later := time.Now().Add(time.Second)
+	os.Chtimes(filename, later, later)
+	if got := motdReplies("000AAAAAA"); len(got) != 3 || got[1].Args[1] != "- Goodbye" {
+		t.Errorf("after change: got %v, want start, Goodbye, end", got)
+	}
+}
@@ -19,5 +19,36 @@ type IRCd struct {
 	fromClient chan *parser.Message
 	fromServer chan *parser.Message
 
+	// The ports clients connect to (changed by REHASH)
+	listener *conn.Listener
+
 	running *sync.WaitGroup
 }
+
+var (
+	// The connections of local clients.  Only manageClients changes this,
+	// so it only locks connMutex to do so; others must lock it to read.
+	connMutex  = new(sync.RWMutex)
+	localConns = make(map[string]*conn.Conn)
+)
+
+// Record (or, if c is nil, forget) a local client's connection.
+func setConn(uid string, c *conn.Conn) {
+	connMutex.Lock()
+	defer connMutex.Unlock()
+	if c == nil {
+		delete(localConns, uid)
+		return
+	}
+	localConns[uid] = c
+}
+
+// Get the number of bytes waiting to be sent to a local client.
+func queued(uid string) int {
+	connMutex.RLock()
+	defer connMutex.RUnlock()
+	if c, ok := localConns[uid]; ok {
+		return c.Queued()
+	}
+	return 0
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/mode"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+)
+
+var (
+	supportTokens = map[string]func() string{}
+)
+
+var (
+	basesupport = []string{
+		Support("CHANMODES", mode.ChannelModes.ChanModes),
+		Support("PREFIX", mode.ChannelModes.PrefixString),
+		Support("CHANTYPES", func() string { return "#" }),
+		Support("NICKLEN", func() string { return strconv.Itoa(parser.NickLen) }),
+		Support("CHANNELLEN", func() string { return strconv.Itoa(parser.ChannelLen) }),
+		Support("TOPICLEN", func() string { return strconv.Itoa(TopicLen) }),
+		Support("NETWORK", func() string {
+			if conf := Config(); conf != nil && conf.Network != nil {
+				return conf.Network.Name
+			}
+			return ""
+		}),
+		Support("CASEMAPPING", func() string { return "rfc1459" }),
+		Support("MAXTARGETS", func() string { return strconv.Itoa(MaxTargets) }),
+	}
+)
+
+var (
+	// The longest topic that can be set; longer topics are truncated.
+	TopicLen = 390
+
+	// The most targets a PRIVMSG or NOTICE may have.
+	MaxTargets = 4
+
+	// The most tokens sent in one RPL_ISUPPORT line.
+	SupportPerLine = 13
+)
+
+// Support registers a token to be advertised in RPL_ISUPPORT.  If value is
+// non-nil, it is called each time the token is sent to compute its value;
+// if the value is empty, the token is left out.
+// The token is returned so that it can be registered in a var block.
+func Support(token string, value func() string) string {
+	supportTokens[token] = value
+	return token
+}
+
+// Get the RPL_ISUPPORT tokens (e.g. "NETWORK=Blight") in sorted order.
+func supportList() []string {
+	tokens := make([]string, 0, len(supportTokens))
+	for token, value := range supportTokens {
+		if value != nil {
+			v := value()
+			if len(v) == 0 {
+				continue
+			}
+			token += "=" + v
+		}
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// Construct the RPL_ISUPPORT messages for the given users, with at most
+// SupportPerLine tokens in each.
+func supportMessages(destIDs ...string) []*parser.Message {
+	msgs := []*parser.Message{}
+	tokens := supportList()
+	for len(tokens) > 0 {
+		n := SupportPerLine
+		if n > len(tokens) {
+			n = len(tokens)
+		}
+		msg := parser.NewNumeric(parser.RPL_ISUPPORT, "").Message(destIDs...)
+		text := msg.Args[len(msg.Args)-1]
+		msg.Args = append([]string{"*"}, tokens[:n]...)
+		msg.Args = append(msg.Args, text)
+		msgs = append(msgs, msg)
+		tokens = tokens[n:]
+	}
+	return msgs
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+)
+
+func TestSupportMessages(t *testing.T) {
+	SetConfig(&Configuration{Network: &Network{Name: "Test"}})
+	defer SetConfig(nil)
+
+	for i := 0; i < 20; i++ {
+		Support("X-TEST"+strconv.Itoa(i), nil)
+	}
+	defer func() {
+		for i := 0; i < 20; i++ {
+			delete(supportTokens, "X-TEST"+strconv.Itoa(i))
+		}
+	}()
+
+	tokens := map[string]bool{}
+	msgs := supportMessages("000AAAAAA")
+	for i, msg := range msgs {
+		if msg.Command != parser.RPL_ISUPPORT {
+			t.Errorf("#%d: command = %s, want %s", i, msg.Command, parser.RPL_ISUPPORT)
+		}
+		// The nick and the trailing text surround the tokens
+		n := len(msg.Args) - 2
+		if n < 1 || n > SupportPerLine {
+			t.Errorf("#%d: %d tokens, want 1-%d", i, n, SupportPerLine)
+		}
+		for _, token := range msg.Args[1 : len(msg.Args)-1] {
+			tokens[token] = true
+		}
+	}
+	if got, want := len(tokens), len(supportTokens); got != want {
+		t.Errorf("got %d tokens, want %d", got, want)
+	}
+	for _, want := range []string{"PREFIX=(ohv)@%+", "CHANMODES=beI,k,l,mnprst", "NETWORK=Test", "CASEMAPPING=rfc1459"} {
+		if !tokens[want] {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
+
+func TestSupportWithoutNetwork(t *testing.T) {
+	SetConfig(&Configuration{})
+	defer SetConfig(nil)
+
+	for _, token := range supportList() {
+		if strings.HasPrefix(token, "NETWORK") {
+			t.Errorf("got %s without a network name", token)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+var (
+	servertime      = Capability("server-time", nil)
+	echomessage     = Capability("echo-message", nil)
+	labeledresponse = Capability("labeled-response", nil)
+	batchcap        = Capability("batch", nil)
+)
+
+var (
+	// Tags that are sent to clients without message-tags if they have
+	// enabled the corresponding capability.
+	tagCaps = map[string]string{
+		"time":  servertime,
+		"label": labeledresponse,
+		"batch": batchcap,
+	}
+
+	// The last BATCH reference number used
+	lastBatch uint64
+)
+
+// Get the message as it should be written to a local client.  Tags are only
+// sent to clients that have enabled message-tags or the capability for the
+// individual tag, and clients with server-time get a time tag (stamp) if
+// the message does not have one already.
+func clientMessage(msg *parser.Message, id, stamp string) *parser.Message {
+	u, ok := user.Lookup(id)
+	if !ok || len(msg.Tags) == 0 && !u.HasCap(servertime) {
+		return msg
+	}
+
+	alltags := u.HasCap(messagetags)
+	tags := make(map[string]string, len(msg.Tags)+1)
+	for key, value := range msg.Tags {
+		if alltags || u.HasCap(tagCaps[key]) {
+			tags[key] = value
+		}
+	}
+	if _, set := tags["time"]; !set && u.HasCap(servertime) {
+		tags["time"] = stamp
+	}
+
+	out := *msg
+	out.Tags = tags
+	return &out
+}
+
+// Get a new BATCH reference.
+func newBatchRef() string {
+	return strconv.FormatUint(atomic.AddUint64(&lastBatch, 1), 36)
+}
+
+// Wrap messages to a local user in a BATCH of the given type.  The batch
+// start and end are tagged with startTags (e.g. a label).
+func wrapBatch(typ string, startTags map[string]string, params []string, msgs []*parser.Message, uid string) []*parser.Message {
+	ref := newBatchRef()
+	wrapped := make([]*parser.Message, 0, len(msgs)+2)
+	wrapped = append(wrapped, &parser.Message{
+		Tags:    startTags,
+		Command: parser.CMD_BATCH,
+		Args:    append([]string{"+" + ref, typ}, params...),
+		DestIDs: []string{uid},
+	})
+	for _, msg := range msgs {
+		if msg.Tags == nil {
+			msg.Tags = make(map[string]string)
+		}
+		msg.Tags["batch"] = ref
+		wrapped = append(wrapped, msg)
+	}
+	return append(wrapped, &parser.Message{
+		Command: parser.CMD_BATCH,
+		Args:    []string{"-" + ref},
+		DestIDs: []string{uid},
+	})
+}
+
+// Run a hook for a message with a label tag from a client with
+// labeled-response.  The replies to the sender are collected and sent when
+// the hook returns: an ACK if there are none, the reply with the label if
+// there is one, and a labeled-response BATCH otherwise.  Messages for other
+// users are passed on as they are sent.
+func runLabeled(fn func(string, *parser.Message, *IRCd), hook string, msg *parser.Message, ircd *IRCd, label string) {
+	sender := msg.SenderID
+
+	labeled := *ircd
+	labeled.ToClient = make(chan *parser.Message)
+	replies := []*parser.Message{}
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for out := range labeled.ToClient {
+			reply, others := splitReply(out, sender)
+			if others != nil {
+				ircd.ToClient <- others
+			}
+			if reply != nil {
+				replies = append(replies, reply)
+			}
+		}
+	}()
+	fn(hook, msg, &labeled)
+	close(labeled.ToClient)
+	<-done
+
+	labelTags := map[string]string{"label": label}
+	switch len(replies) {
+	case 0:
+		ircd.ToClient <- &parser.Message{
+			Tags:    labelTags,
+			Command: parser.CMD_ACK,
+			DestIDs: []string{sender},
+		}
+	case 1:
+		if replies[0].Tags == nil {
+			replies[0].Tags = make(map[string]string)
+		}
+		replies[0].Tags["label"] = label
+		ircd.ToClient <- replies[0]
+	default:
+		for _, reply := range wrapBatch("labeled-response", labelTags, nil, replies, sender) {
+			ircd.ToClient <- reply
+		}
+	}
+}
+
+// Split the copy of a message for uid off from the copy for everyone else.
+// Either may be nil.
+func splitReply(msg *parser.Message, uid string) (reply, others *parser.Message) {
+	if msg.Command == parser.INT_DELUSER {
+		return nil, msg
+	}
+
+	rest := make([]string, 0, len(msg.DestIDs))
+	for _, id := range msg.DestIDs {
+		if id == uid {
+			reply = msg.Dup()
+			reply.DestIDs = []string{uid}
+			continue
+		}
+		rest = append(rest, id)
+	}
+	if reply == nil {
+		return nil, msg
+	}
+	if len(rest) > 0 {
+		others = msg
+		others.DestIDs = rest
+	}
+	return
+}
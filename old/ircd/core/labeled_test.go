@@ -0,0 +1,85 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+func TestClientMessage(t *testing.T) {
+	user.Get("000AAATG1")
+	defer user.Delete("000AAATG1")
+	timed := user.Get("000AAATG2")
+	defer user.Delete("000AAATG2")
+	timed.SetCap(servertime, true)
+	tagged := user.Get("000AAATG3")
+	defer user.Delete("000AAATG3")
+	tagged.SetCap(messagetags, true)
+
+	msg := &parser.Message{
+		Tags:    map[string]string{"+typing": "active"},
+		Command: parser.CMD_TAGMSG,
+		Args:    []string{"#chan"},
+	}
+	stamp := "2012-06-30T23:59:60.419Z"
+
+	if got := clientMessage(msg, "000AAATG1", stamp).Tags; len(got) != 0 {
+		t.Errorf("without caps: tags = %q, want none", got)
+	}
+	if got := clientMessage(msg, "000AAATG2", stamp).Tags; len(got) != 1 || got["time"] != stamp {
+		t.Errorf("server-time: tags = %q, want only time=%s", got, stamp)
+	}
+	if got := clientMessage(msg, "000AAATG3", stamp).Tags; len(got) != 1 || got["+typing"] != "active" {
+		t.Errorf("message-tags: tags = %q, want only +typing=active", got)
+	}
+	if got, want := len(msg.Tags), 1; got != want {
+		t.Errorf("original modified: %d tags, want %d", got, want)
+	}
+}
+
+var runLabeledTests = []struct {
+	Desc     string
+	Replies  int
+	Commands []string
+}{
+	{"no reply", 0, []string{parser.CMD_ACK}},
+	{"one reply", 1, []string{parser.CMD_PONG}},
+	{"two replies", 2, []string{parser.CMD_BATCH, parser.CMD_PONG, parser.CMD_PONG, parser.CMD_BATCH}},
+}
+
+func TestRunLabeled(t *testing.T) {
+	for _, test := range runLabeledTests {
+		fn := func(hook string, msg *parser.Message, ircd *IRCd) {
+			for i := 0; i < test.Replies; i++ {
+				ircd.ToClient <- &parser.Message{
+					Command: parser.CMD_PONG,
+					DestIDs: []string{msg.SenderID, "000AAAOTH"},
+				}
+			}
+		}
+		labeled := func(hook string, msg *parser.Message, ircd *IRCd) {
+			runLabeled(fn, hook, msg, ircd, "xyz")
+		}
+
+		commands := []string{}
+		for _, msg := range runHook(labeled, parser.CMD_PING, "000AAALBL") {
+			if msg.DestIDs[0] != "000AAALBL" {
+				continue
+			}
+			commands = append(commands, msg.Command)
+			if len(commands) == 1 && msg.Tags["label"] != "xyz" {
+				t.Errorf("%s: first reply %q is not labeled", test.Desc, msg)
+			}
+		}
+		if got, want := len(commands), len(test.Commands); got != want {
+			t.Errorf("%s: got %v, want %v", test.Desc, commands, test.Commands)
+			continue
+		}
+		for i := range commands {
+			if got, want := commands[i], test.Commands[i]; got != want {
+				t.Errorf("%s: reply %d is %s, want %s", test.Desc, i, got, want)
+			}
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package core
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/kylelemons/ircd-blight/old/ircd/channel"
+	"github.com/kylelemons/ircd-blight/old/ircd/history"
 	"github.com/kylelemons/ircd-blight/old/ircd/log"
 	"github.com/kylelemons/ircd-blight/old/ircd/parser"
 	"github.com/kylelemons/ircd-blight/old/ircd/server"
@@ -14,63 +16,124 @@ var (
 	msghooks = []*Hook{
 		Register(parser.CMD_PRIVMSG, User|Server, NArgs(2), Privmsg),
 		Register(parser.CMD_NOTICE, User|Server, NArgs(2), Privmsg),
+		Register(parser.CMD_TAGMSG, User|Server, NArgs(1), Privmsg),
 	}
+	awayhooks = []*Hook{
+		Register(parser.CMD_AWAY, User|Server, OptArgs(0, 1), Away),
+	}
+	messagetags = Capability("message-tags", nil)
+	awaynotify  = Capability("away-notify", nil)
 )
 
+// PRIVMSG <target>[,<target>...] :<text>
+// NOTICE <target>[,<target>...] :<text>
+// TAGMSG <target>[,<target>...]
+//
+// Client-only tags are passed on to recipients and to other servers.  TAGMSG
+// is only delivered to local clients with message-tags.
 func Privmsg(hook string, msg *parser.Message, ircd *IRCd) {
-	quiet := hook == parser.CMD_NOTICE
-	recipients, text := strings.Split(msg.Args[0], ","), msg.Args[1]
+	conf := Config()
+	quiet := hook != parser.CMD_PRIVMSG
+	tagmsg := hook == parser.CMD_TAGMSG
+	recipients, tags := strings.Split(msg.Args[0], ","), msg.ClientTags()
+	args := func(target string) []string {
+		if tagmsg {
+			return []string{target}
+		}
+		return []string{target, msg.Args[1]}
+	}
 	sender := msg.SenderID
 	if len(msg.Prefix) == 9 {
 		sender = msg.Prefix
 	}
-	local := []string{}
+	// Only local senders are limited; servers have already done so
+	if len(recipients) > MaxTargets && len(msg.SenderID) == 9 {
+		if !quiet {
+			num := parser.NewNumeric(parser.ERR_TOOMANYTARGETS, recipients[MaxTargets]).Message(msg.SenderID)
+			num.Args[len(num.Args)-1] = "Too many recipients. Only " + strconv.Itoa(MaxTargets) + " processed"
+			ircd.ToClient <- num
+		}
+		recipients = recipients[:MaxTargets]
+	}
+	echo := false
+	if u, ok := user.Lookup(msg.SenderID); ok {
+		u.Touch()
+		echo = u.HasCap(echomessage)
+	}
+	// Local senders with echo-message get a copy of each delivered message
+	echoTo := func(target string, tags map[string]string) {
+		if echo {
+			ircd.ToClient <- &parser.Message{
+				Tags:    tags,
+				Prefix:  sender,
+				Command: hook,
+				Args:    args(target),
+				DestIDs: []string{sender},
+			}
+		}
+	}
 	remote := []string{}
 	for _, name := range recipients {
-		if parser.ValidChannel(name) {
-			channel, err := channel.Get(name, false)
+		if rank, channame := channel.SplitStatus(name); parser.ValidChannel(channame) {
+			channel, err := channel.Get(channame, false)
 			if num, ok := err.(*parser.Numeric); ok {
 				if !quiet {
 					ircd.ToClient <- num.Message(msg.SenderID)
 				}
 				continue
 			}
+			// Only local senders are checked; servers have already done so
+			if len(msg.SenderID) == 9 {
+				if num, ok := channel.CanSend(sender).(*parser.Numeric); ok {
+					if !quiet {
+						ircd.ToClient <- num.Message(msg.SenderID)
+					}
+					continue
+				}
+			}
+			// STATUSMSG targets (e.g. @#chan) keep their prefix
+			target := name[:len(name)-len(channame)] + channel.Name()
 			local := []string{}
 			remote := []string{}
-			for _, uid := range channel.UserIDs() {
+			for _, uid := range channel.UserIDsWithRank(rank) {
 				if uid != sender {
-					if uid[:3] == Config.SID {
+					if uid[:3] == conf.SID {
 						local = append(local, uid)
 					} else {
 						remote = append(remote, uid)
 					}
 				}
 			}
+			if tagmsg {
+				local = withCap(local, messagetags)
+			}
+			// Messages to the whole channel are kept for CHATHISTORY
+			msgtags := tags
+			if !tagmsg && rank == 0 {
+				msgtags = recordHistory(history.ChannelKey(channel.Name()), hook, sender, target, msg.Args[1], tags)
+			}
 			if len(remote) > 0 {
 				for sid := range server.IterFor(remote, msg.SenderID) {
-					log.Debug.Printf("Forwarding PRIVMSG from %s to %s", msg.SenderID, sid)
+					log.Debug.Printf("Forwarding %s from %s to %s", hook, msg.SenderID, sid)
 					ircd.ToServer <- &parser.Message{
+						Tags:    tags,
 						Prefix:  sender,
 						Command: hook,
-						Args: []string{
-							channel.Name(),
-							text,
-						},
+						Args:    args(target),
 						DestIDs: []string{sid},
 					}
 				}
 			}
 			if len(local) > 0 {
 				ircd.ToClient <- &parser.Message{
+					Tags:    msgtags,
 					Prefix:  sender,
 					Command: hook,
-					Args: []string{
-						channel.Name(),
-						text,
-					},
+					Args:    args(target),
 					DestIDs: local,
 				}
 			}
+			echoTo(target, msgtags)
 			continue
 		}
 
@@ -81,36 +144,109 @@ func Privmsg(hook string, msg *parser.Message, ircd *IRCd) {
 			}
 			continue
 		}
-		if id[:3] == Config.SID {
-			local = append(local, id)
-		} else {
+		if !quiet && len(msg.SenderID) == 9 {
+			if u, ok := user.Lookup(id); ok && len(u.Away()) > 0 {
+				reply := parser.NewNumeric(parser.RPL_AWAY, id).Message(msg.SenderID)
+				reply.Args[len(reply.Args)-1] = u.Away()
+				ircd.ToClient <- reply
+			}
+		}
+		localid := id[:3] == conf.SID
+		// Conversations with a local user are kept for CHATHISTORY
+		msgtags := tags
+		if !tagmsg && (localid || sender[:3] == conf.SID) {
+			from, ok1 := user.Lookup(sender)
+			to, ok2 := user.Lookup(id)
+			if ok1 && ok2 {
+				key := history.ConversationKey(historyParty(from), historyParty(to))
+				msgtags = recordHistory(key, hook, sender, to.Nick(), msg.Args[1], tags)
+			}
+		}
+		echoTo(id, msgtags)
+		switch {
+		case !localid:
 			remote = append(remote, id)
+		case !tagmsg || len(withCap([]string{id}, messagetags)) > 0:
+			ircd.ToClient <- &parser.Message{
+				Tags:    msgtags,
+				Prefix:  sender,
+				Command: hook,
+				Args:    args("*"),
+				DestIDs: []string{id},
+			}
 		}
 	}
 	if len(remote) > 0 {
 		for _, remoteid := range remote {
 			for sid := range server.IterFor([]string{remoteid}, "") {
 				ircd.ToServer <- &parser.Message{
+					Tags:    tags,
 					Prefix:  sender,
 					Command: hook,
-					Args: []string{
-						remoteid,
-						text,
-					},
+					Args:    args(remoteid),
 					DestIDs: []string{sid},
 				}
 			}
 		}
 	}
-	if len(local) > 0 {
+}
+
+// Get the users who have enabled the given capability.
+func withCap(uids []string, capname string) []string {
+	with := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		if u, ok := user.Lookup(uid); ok && u.HasCap(capname) {
+			with = append(with, uid)
+		}
+	}
+	return with
+}
+
+// AWAY [:<message>]
+func Away(hook string, msg *parser.Message, ircd *IRCd) {
+	uid := msg.SenderID
+	if len(msg.SenderID) == 3 {
+		uid = msg.Prefix
+	}
+	u, ok := user.Lookup(uid)
+	if !ok {
+		log.Warn.Printf("AWAY from unknown user %s", uid)
+		return
+	}
+
+	message, args := "", []string{}
+	if len(msg.Args) > 0 && len(msg.Args[0]) > 0 {
+		message, args = msg.Args[0], msg.Args[:1]
+	}
+	u.SetAway(message)
+
+	if uid == msg.SenderID {
+		if len(message) > 0 {
+			ircd.ToClient <- parser.NewNumeric(parser.RPL_NOWAWAY).Message(uid)
+		} else {
+			ircd.ToClient <- parser.NewNumeric(parser.RPL_UNAWAY).Message(uid)
+		}
+	}
+
+	// Forward to other servers
+	for sid := range server.Iter() {
+		if sid != msg.SenderID {
+			ircd.ToServer <- &parser.Message{
+				Prefix:  uid,
+				Command: parser.CMD_AWAY,
+				Args:    args,
+				DestIDs: []string{sid},
+			}
+		}
+	}
+
+	// Notify away-notify clients on a channel with the user
+	if peers := capPeers(uid, awaynotify); len(peers) > 0 {
 		ircd.ToClient <- &parser.Message{
-			Prefix:  sender,
-			Command: hook,
-			Args: []string{
-				"*",
-				text,
-			},
-			DestIDs: local,
+			Prefix:  uid,
+			Command: parser.CMD_AWAY,
+			Args:    args,
+			DestIDs: peers,
 		}
 	}
 }
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+func TestRemoteTags(t *testing.T) {
+	SetConfig(&Configuration{SID: "000"})
+	defer SetConfig(nil)
+
+	user.Get("000AAATG1").SetNick("tagger")
+	defer user.Delete("000AAATG1")
+	user.Get("1BBAAATG2").SetNick("faraway")
+	defer user.Delete("1BBAAATG2")
+
+	for _, test := range []struct {
+		Command string
+		Args    []string
+	}{
+		{parser.CMD_TAGMSG, []string{"faraway"}},
+		{parser.CMD_PRIVMSG, []string{"faraway", "hello"}},
+	} {
+		ircd := &IRCd{
+			ToClient: make(chan *parser.Message, 10),
+			ToServer: make(chan *parser.Message, 10),
+		}
+		Privmsg(test.Command, &parser.Message{
+			Tags:     map[string]string{"+typing": "active", "label": "1"},
+			SenderID: "000AAATG1",
+			Command:  test.Command,
+			Args:     test.Args,
+		}, ircd)
+		close(ircd.ToServer)
+
+		msg, ok := <-ircd.ToServer
+		if !ok {
+			t.Errorf("%s: nothing sent to the remote user's server", test.Command)
+			continue
+		}
+		if got, want := msg.DestIDs, []string{"1BB"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: sent to %q, want %q", test.Command, got, want)
+		}
+		if got, want := msg.Tags, map[string]string{"+typing": "active"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: tags = %q, want %q", test.Command, got, want)
+		}
+	}
+}
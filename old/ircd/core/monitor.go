@@ -0,0 +1,210 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+var (
+	monitorhooks = []*Hook{
+		Register(parser.CMD_MONITOR, User, OptArgs(1, 1), Monitor),
+		Register(parser.CMD_ISON, User, MinArgs(1), Ison),
+		Register(parser.CMD_USERHOST, User, MinArgs(1), Userhost),
+	}
+	monitorsupport = Support("MONITOR", func() string {
+		return strconv.Itoa(MonitorLimit)
+	})
+)
+
+var (
+	// The maximum number of nicks a user may MONITOR.
+	MonitorLimit = 100
+)
+
+// MONITOR <+|-> <target>[,<target>...]
+// MONITOR <C|L|S>
+func Monitor(hook string, msg *parser.Message, ircd *IRCd) {
+	uid := msg.SenderID
+	targets := []string{}
+	if len(msg.Args) > 1 {
+		for _, target := range strings.Split(msg.Args[1], ",") {
+			if len(target) > 0 {
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	switch strings.ToUpper(msg.Args[0]) {
+	case "+":
+		for i, target := range targets {
+			if !user.AddMonitor(uid, target, MonitorLimit) {
+				full := parser.NewNumeric(parser.ERR_MONLISTFULL, strconv.Itoa(MonitorLimit), strings.Join(targets[i:], ","))
+				ircd.ToClient <- full.Message(uid)
+				targets = targets[:i]
+				break
+			}
+		}
+		for _, reply := range monitorStatus(uid, targets) {
+			ircd.ToClient <- reply
+		}
+	case "-":
+		for _, target := range targets {
+			user.DelMonitor(uid, target)
+		}
+	case "C":
+		user.ClearMonitor(uid)
+	case "L":
+		for _, reply := range monitorReplies(parser.RPL_MONLIST, uid, user.MonitorList(uid)) {
+			ircd.ToClient <- reply
+		}
+		ircd.ToClient <- parser.NewNumeric(parser.RPL_ENDOFMONLIST).Message(uid)
+	case "S":
+		for _, reply := range monitorStatus(uid, user.MonitorList(uid)) {
+			ircd.ToClient <- reply
+		}
+	}
+}
+
+// Construct the RPL_MONONLINE and RPL_MONOFFLINE replies for the given nicks.
+func monitorStatus(destID string, nicks []string) []*parser.Message {
+	online, offline := []string{}, []string{}
+	for _, nick := range nicks {
+		uid, err := user.GetID(nick)
+		if u, ok := user.Lookup(uid); err == nil && ok {
+			online = append(online, u.Hostmask())
+		} else {
+			offline = append(offline, nick)
+		}
+	}
+	replies := monitorReplies(parser.RPL_MONONLINE, destID, online)
+	return append(replies, monitorReplies(parser.RPL_MONOFFLINE, destID, offline)...)
+}
+
+// Construct the replies listing the targets, split so that each line stays
+// well within the protocol limit.
+func monitorReplies(num, destID string, targets []string) []*parser.Message {
+	const maxlen = 400
+
+	replies := []*parser.Message{}
+	list := ""
+	flush := func() {
+		if len(list) == 0 {
+			return
+		}
+		replies = append(replies, &parser.Message{
+			Command: num,
+			Args: []string{
+				"*",
+				list,
+			},
+			DestIDs: []string{destID},
+		})
+		list = ""
+	}
+	for _, target := range targets {
+		if len(list) > 0 && len(list)+1+len(target) > maxlen {
+			flush()
+		}
+		if len(list) > 0 {
+			list += ","
+		}
+		list += target
+	}
+	flush()
+	return replies
+}
+
+// Tell the local users monitoring any of the given users that they are
+// online.
+func monitorOnline(ircd *IRCd, users ...*user.User) {
+	targets := make(map[string]string, len(users))
+	for _, u := range users {
+		targets[u.Nick()] = u.Hostmask()
+	}
+	notifyMonitors(parser.RPL_MONONLINE, targets, ircd)
+}
+
+// Tell the local users monitoring any of the given nicks that they are
+// offline.
+func monitorOffline(ircd *IRCd, nicks ...string) {
+	targets := make(map[string]string, len(nicks))
+	for _, nick := range nicks {
+		targets[nick] = nick
+	}
+	notifyMonitors(parser.RPL_MONOFFLINE, targets, ircd)
+}
+
+// Send the targets (targets[nick] = text) to the users monitoring them.
+func notifyMonitors(num string, targets map[string]string, ircd *IRCd) {
+	notify := make(map[string][]string)
+	for nick, text := range targets {
+		for _, uid := range user.Watchers(nick) {
+			notify[uid] = append(notify[uid], text)
+		}
+	}
+	for uid, texts := range notify {
+		for _, reply := range monitorReplies(num, uid, texts) {
+			ircd.ToClient <- reply
+		}
+	}
+}
+
+// ISON <nick>{ <nick>}
+func Ison(hook string, msg *parser.Message, ircd *IRCd) {
+	online := []string{}
+	for _, nick := range strings.Fields(strings.Join(msg.Args, " ")) {
+		uid, err := user.GetID(nick)
+		if u, ok := user.Lookup(uid); err == nil && ok {
+			online = append(online, u.Nick())
+		}
+	}
+	ircd.ToClient <- &parser.Message{
+		Command: parser.RPL_ISON,
+		Args: []string{
+			"*",
+			strings.Join(online, " "),
+		},
+		DestIDs: []string{msg.SenderID},
+	}
+}
+
+// USERHOST <nick>{ <nick>}
+func Userhost(hook string, msg *parser.Message, ircd *IRCd) {
+	// Only the first five nicks are answered
+	nicks := strings.Fields(strings.Join(msg.Args, " "))
+	if len(nicks) > 5 {
+		nicks = nicks[:5]
+	}
+
+	replies := []string{}
+	for _, nick := range nicks {
+		uid, err := user.GetID(nick)
+		u, ok := user.Lookup(uid)
+		if err != nil || !ok {
+			continue
+		}
+
+		reply := u.Nick()
+		if u.HasMode('o') {
+			reply += "*"
+		}
+		if len(u.Away()) > 0 {
+			reply += "=-"
+		} else {
+			reply += "=+"
+		}
+		reply += u.User() + "@" + u.Host()
+		replies = append(replies, reply)
+	}
+	ircd.ToClient <- &parser.Message{
+		Command: parser.RPL_USERHOST,
+		Args: []string{
+			"*",
+			strings.Join(replies, " "),
+		},
+		DestIDs: []string{msg.SenderID},
+	}
+}
@@ -0,0 +1,419 @@
+package core
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/log"
+	"github.com/kylelemons/ircd-blight/old/ircd/mode"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/server"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+var (
+	operhooks = []*Hook{
+		Register(parser.CMD_OPER, User, NArgs(2), OperUp),
+		Register(parser.CMD_MODE, Server, MinArgs(2), SMode),
+		Register(parser.CMD_KILL, User, OptArgs(1, 1), Privileged(PrivKillLocal, Kill)),
+		Register(parser.CMD_KILL, Server, NArgs(2), SKill),
+		Register(parser.CMD_WALLOPS, User, NArgs(1), Privileged(PrivWallops, Wallops)),
+		Register(parser.CMD_WALLOPS, Server, NArgs(1), Wallops),
+		Register(parser.CMD_DIE, User, OptArgs(0, 1), Privileged(PrivDie, Die)),
+		Register(parser.CMD_RESTART, User, OptArgs(0, 1), Privileged(PrivRestart, Restart)),
+		Register(parser.CMD_SQUIT, User, OptArgs(1, 1), Privileged(PrivRouting, OperSQuit)),
+		Register(parser.CMD_CONNECT, User, OptArgs(1, 2), Privileged(PrivRouting, Connect)),
+	}
+)
+
+// How long DIE and RESTART give the ERRORs they send to be delivered.
+const ShutdownDelay = time.Second
+
+// Operator privileges, which are granted to opers by their oper class.
+const (
+	PrivKillLocal  = "kill:local"  // KILL local users
+	PrivKillGlobal = "kill:global" // KILL any user (implies kill:local)
+	PrivKline      = "kline"       // set and remove K-, D- and X-lines
+	PrivRehash     = "rehash"      // REHASH the configuration
+	PrivDie        = "die"         // DIE (shut down the server)
+	PrivRestart    = "restart"     // RESTART the server
+	PrivRouting    = "routing"     // CONNECT and SQUIT servers
+	PrivWallops    = "wallops"     // send WALLOPS
+	PrivOverride   = "override"    // ignore channel restrictions (TOPIC, KICK)
+	PrivAuspex     = "auspex"      // see secret and private channels
+)
+
+var (
+	// All of the known operator privileges.
+	Privileges = []string{
+		PrivKillLocal,
+		PrivKillGlobal,
+		PrivKline,
+		PrivRehash,
+		PrivDie,
+		PrivRestart,
+		PrivRouting,
+		PrivWallops,
+		PrivOverride,
+		PrivAuspex,
+	}
+)
+
+// Check whether the user with the given ID is an oper with the given
+// privilege.  Only the privileges of local opers are known.
+func hasPrivilege(uid, priv string) bool {
+	u, ok := user.Lookup(uid)
+	if !ok || !u.HasMode('o') {
+		return false
+	}
+	if priv == PrivKillLocal && u.HasPrivilege(PrivKillGlobal) {
+		return true
+	}
+	return u.HasPrivilege(priv)
+}
+
+// Wrap a hook so that it is only called for users with the given privilege.
+// Others get ERR_NOPRIVILEGES if they are not an oper at all and ERR_NOPRIVS
+// if they are.  Messages from servers are always passed through, since the
+// originating server has already checked them.
+func Privileged(priv string, fn func(string, *parser.Message, *IRCd)) func(string, *parser.Message, *IRCd) {
+	return func(hook string, msg *parser.Message, ircd *IRCd) {
+		uid := msg.SenderID
+		if len(uid) == 3 || hasPrivilege(uid, priv) {
+			fn(hook, msg, ircd)
+			return
+		}
+		if u, ok := user.Lookup(uid); !ok || !u.HasMode('o') {
+			log.Info.Printf("[%s] Denied %s: not an oper", uid, hook)
+			ircd.ToClient <- parser.NewNumeric(parser.ERR_NOPRIVILEGES).Message(uid)
+			return
+		}
+		log.Info.Printf("[%s] Denied %s: no %s privilege", uid, hook, priv)
+		ircd.ToClient <- parser.NewNumeric(parser.ERR_NOPRIVS, priv).Message(uid)
+	}
+}
+
+// Find the operator block with the given name.
+func findOper(name string) (*Oper, bool) {
+	for _, oper := range Config().Operator {
+		if oper.Name == name {
+			return oper, true
+		}
+	}
+	return nil, false
+}
+
+// OPER <name> <password>
+func OperUp(hook string, msg *parser.Message, ircd *IRCd) {
+	uid, name, password := msg.SenderID, msg.Args[0], msg.Args[1]
+	u, ok := user.Lookup(uid)
+	if !ok {
+		log.Warn.Printf("OPER from unknown user %s", uid)
+		return
+	}
+	who := u.Nick() + " (" + u.User() + "@" + u.Host() + ")"
+
+	oper, ok := findOper(name)
+	if !ok || !oper.MatchHost(u.User(), u.Host(), u.IP()) {
+		log.Warn.Printf("[%s] Failed OPER as %q: no matching host", uid, name)
+		serverNotice(SnoOper, "Failed OPER attempt as "+name+" by "+who+" (no matching host)", ircd)
+		ircd.ToClient <- parser.NewNumeric(parser.ERR_NOOPERHOST).Message(uid)
+		return
+	}
+	if oper.Password == nil || !oper.Password.Check(password) {
+		log.Warn.Printf("[%s] Failed OPER as %q: password incorrect", uid, name)
+		serverNotice(SnoOper, "Failed OPER attempt as "+name+" by "+who+" (password incorrect)", ircd)
+		ircd.ToClient <- parser.NewNumeric(parser.ERR_PASSWDMISMATCH).Message(uid)
+		return
+	}
+
+	modes := "+os"
+	if oper.HasFlag("admin") {
+		modes += "a"
+	}
+	changes, _ := mode.UserModes.ParseModeChange([]string{modes})
+	applied := u.ApplyModes(changes)
+	u.SetPrivileges(Config().Privileges(oper))
+	if len(u.Snomask()) == 0 {
+		u.SetSnomask(DefaultSnomask)
+	}
+
+	log.Info.Printf("[%s] Opered up as %s (class %q)", uid, name, oper.Class)
+	serverNotice(SnoOper, who+" is now an operator ("+name+")", ircd)
+
+	if len(applied) > 0 {
+		modestr := mode.UserModes.ModeString(applied)
+		ircd.ToClient <- &parser.Message{
+			Prefix:  "*",
+			Command: parser.CMD_MODE,
+			Args: []string{
+				"*",
+				modestr,
+			},
+			DestIDs: []string{uid},
+		}
+		for sid := range server.Iter() {
+			ircd.ToServer <- &parser.Message{
+				Prefix:  uid,
+				Command: parser.CMD_MODE,
+				Args: []string{
+					uid,
+					modestr,
+				},
+				DestIDs: []string{sid},
+			}
+		}
+	}
+	ircd.ToClient <- parser.NewNumeric(parser.RPL_SNOMASK, "+"+u.Snomask()).Message(uid)
+	ircd.ToClient <- parser.NewNumeric(parser.RPL_YOUREOPER).Message(uid)
+}
+
+// Server MODE <uid> <modes> (user modes only; channel modes use TMODE)
+func SMode(hook string, msg *parser.Message, ircd *IRCd) {
+	target, modes := msg.Args[0], strings.Join(msg.Args[1:], " ")
+	if !isuid(target) {
+		log.Debug.Printf("Ignoring MODE for %s from %s", target, msg.SenderID)
+		return
+	}
+
+	// Forward
+	for sid := range server.Iter() {
+		if sid != msg.SenderID {
+			fmsg := msg.Dup()
+			fmsg.DestIDs = []string{sid}
+			ircd.ToServer <- fmsg
+		}
+	}
+
+	u, ok := user.Lookup(target)
+	if !ok {
+		log.Warn.Printf("MODE for unknown user %s", target)
+		return
+	}
+	changes, _ := mode.UserModes.ParseModeChange(strings.Fields(modes))
+	u.ApplyModes(changes)
+}
+
+// KILL <nick> [:<reason>]
+func Kill(hook string, msg *parser.Message, ircd *IRCd) {
+	uid, nick := msg.SenderID, msg.Args[0]
+	killer, _, _, _, _ := user.GetInfo(uid)
+	reason := "<No reason given>"
+	if len(msg.Args) > 1 && len(msg.Args[1]) > 0 {
+		reason = msg.Args[1]
+	}
+
+	target, err := user.GetID(nick)
+	if num, ok := err.(*parser.Numeric); ok {
+		if _, isServer := findServer(nick); isServer {
+			ircd.ToClient <- parser.NewNumeric(parser.ERR_CANTKILLSERVER).Message(uid)
+			return
+		}
+		ircd.ToClient <- num.Message(uid)
+		return
+	}
+	if target[:3] != Config().SID && !hasPrivilege(uid, PrivKillGlobal) {
+		ircd.ToClient <- parser.NewNumeric(parser.ERR_NOPRIVS, PrivKillGlobal).Message(uid)
+		return
+	}
+
+	killUser(uid, target, killer+" ("+reason+")", "", ircd)
+}
+
+// Server KILL <uid> :<oper> (<reason>) (the prefix is the killer)
+func SKill(hook string, msg *parser.Message, ircd *IRCd) {
+	killer, target, comment := msg.Prefix, msg.Args[0], msg.Args[1]
+	if len(killer) == 0 {
+		killer = msg.SenderID
+	}
+	if _, ok := user.Lookup(target); !ok {
+		log.Warn.Printf("KILL from %s for unknown user %s", msg.SenderID, target)
+		return
+	}
+	killUser(killer, target, comment, msg.SenderID, ircd)
+}
+
+// Disconnect a user from the network on behalf of killer (a UID or SID).
+// The KILL is passed on to every linked server except from; the comment is
+// the oper's nick and the reason, as in "oper (reason)".
+func killUser(killer, target, comment, from string, ircd *IRCd) {
+	conf := Config()
+	message := "Killed (" + comment + ")"
+	if u, ok := user.Lookup(target); ok {
+		log.Info.Printf("[%s] %s killed by %s: %s", target, u.Nick(), killer, comment)
+		if target[:3] == conf.SID {
+			serverNotice(SnoKill, "Received KILL message for "+u.Hostmask()+". From "+comment, ircd)
+		}
+	}
+
+	for sid := range server.Iter() {
+		if sid != from {
+			ircd.ToServer <- &parser.Message{
+				Prefix:  killer,
+				Command: parser.CMD_KILL,
+				Args: []string{
+					target,
+					comment,
+				},
+				DestIDs: []string{sid},
+			}
+		}
+	}
+
+	quitChannels(target, message, ircd)
+
+	if target[:3] != conf.SID {
+		ircd.ToClient <- &parser.Message{
+			Command: parser.INT_DELUSER,
+			DestIDs: []string{target},
+		}
+		return
+	}
+	ircd.ToClient <- &parser.Message{
+		Command: parser.CMD_ERROR,
+		Args: []string{
+			"Closing Link (" + message + ")",
+		},
+		DestIDs: []string{target},
+	}
+}
+
+// WALLOPS :<text>
+// Server WALLOPS :<text> (the prefix is the sender)
+func Wallops(hook string, msg *parser.Message, ircd *IRCd) {
+	sender, text := msg.SenderID, msg.Args[0]
+	if len(sender) == 3 && len(msg.Prefix) > 0 {
+		sender = msg.Prefix
+	}
+
+	// Forward
+	for sid := range server.Iter() {
+		if sid != msg.SenderID {
+			ircd.ToServer <- &parser.Message{
+				Prefix:  sender,
+				Command: parser.CMD_WALLOPS,
+				Args: []string{
+					text,
+				},
+				DestIDs: []string{sid},
+			}
+		}
+	}
+
+	dests := []string{}
+	for uid := range user.Iter() {
+		if uid[:3] != Config().SID {
+			continue
+		}
+		if u, ok := user.Lookup(uid); ok && u.HasMode('w') {
+			dests = append(dests, uid)
+		}
+	}
+	if len(dests) == 0 {
+		return
+	}
+	ircd.ToClient <- &parser.Message{
+		Prefix:  sender,
+		Command: parser.CMD_WALLOPS,
+		Args: []string{
+			text,
+		},
+		DestIDs: dests,
+	}
+}
+
+// DIE [:<reason>]
+func Die(hook string, msg *parser.Message, ircd *IRCd) {
+	shutdown(msg, "Server terminating", ircd)
+	os.Exit(0)
+}
+
+// RESTART [:<reason>]
+func Restart(hook string, msg *parser.Message, ircd *IRCd) {
+	shutdown(msg, "Server restarting", ircd)
+
+	// The listening sockets are closed on exec, so the new process can
+	// listen on the same ports
+	exe, err := os.Executable()
+	if err == nil {
+		err = syscall.Exec(exe, os.Args, os.Environ())
+	}
+	log.Error.Fatalf("Could not restart: %s", err)
+}
+
+// Disconnect every local client and server for DIE or RESTART, giving them
+// ShutdownDelay to receive the ERROR.  The oper's reason, if any, is added
+// to the message.
+func shutdown(msg *parser.Message, message string, ircd *IRCd) {
+	uid := msg.SenderID
+	nick, _, _, _, _ := user.GetInfo(uid)
+	if len(msg.Args) > 0 && len(msg.Args[0]) > 0 {
+		message += ": " + msg.Args[0]
+	}
+	log.Info.Printf("[%s] %s (by %s)", uid, message, nick)
+
+	for sid := range server.Iter() {
+		ircd.ToServer <- &parser.Message{
+			Command: parser.CMD_ERROR,
+			Args: []string{
+				message,
+			},
+			DestIDs: []string{sid},
+		}
+	}
+	dests := []string{}
+	for uid := range user.Iter() {
+		if uid[:3] == Config().SID {
+			dests = append(dests, uid)
+		}
+	}
+	if len(dests) > 0 {
+		ircd.ToClient <- &parser.Message{
+			Command: parser.CMD_ERROR,
+			Args: []string{
+				"Closing Link (" + message + ")",
+			},
+			DestIDs: dests,
+		}
+	}
+	time.Sleep(ShutdownDelay)
+}
+
+// SQUIT <server> [:<reason>]
+func OperSQuit(hook string, msg *parser.Message, ircd *IRCd) {
+	uid, name := msg.SenderID, msg.Args[0]
+	nick, _, _, _, _ := user.GetInfo(uid)
+	reason := "<No reason given>"
+	if len(msg.Args) > 1 && len(msg.Args[1]) > 0 {
+		reason = msg.Args[1]
+	}
+
+	sid, ok := server.Find(name)
+	if !ok {
+		ircd.ToClient <- parser.NewNumeric(parser.ERR_NOSUCHSERVER, name).Message(uid)
+		return
+	}
+	_, servername, _, _, _ := server.GetInfo(sid)
+	log.Info.Printf("[%s] SQUIT %s by %s: %s", uid, servername, nick, reason)
+
+	// The split is handled (and passed on) as if a server had sent it
+	SQuit(hook, &parser.Message{
+		SenderID: Config().SID,
+		Prefix:   uid,
+		Command:  parser.CMD_SQUIT,
+		Args: []string{
+			sid,
+			nick + " (" + reason + ")",
+		},
+	}, ircd)
+}
+
+// CONNECT <server> [<port>]
+//
+// Outgoing server links are not supported yet, so this only tells the oper
+// so.
+func Connect(hook string, msg *parser.Message, ircd *IRCd) {
+	ircd.ToClient <- notice(msg.SenderID, "CONNECT "+msg.Args[0]+": outgoing server links are not supported")
+}
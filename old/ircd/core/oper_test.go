@@ -0,0 +1,161 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/channel"
+	"github.com/kylelemons/ircd-blight/old/ircd/mode"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+func TestOperUp(t *testing.T) {
+	SetConfig(&Configuration{
+		SID:       "000",
+		OperClass: []*OperClass{{Name: "helper", Privilege: []string{PrivKillLocal}}},
+		Operator: []*Oper{{
+			Name:     "god",
+			Class:    "helper",
+			Password: &Password{Password: "blight"},
+			Host:     []string{"127.0.0.1"},
+			Flag:     []string{"admin"},
+		}},
+	})
+	defer SetConfig(nil)
+
+	u := user.Get("000AAAOPR")
+	defer user.Delete("000AAAOPR")
+	u.SetHost("localhost", "127.0.0.1")
+
+	oper := func(name, password string) (last string) {
+		for _, msg := range runHook(OperUp, parser.CMD_OPER, "000AAAOPR", name, password) {
+			last = msg.Command
+		}
+		return
+	}
+
+	if got, want := oper("nobody", "blight"), parser.ERR_NOOPERHOST; got != want {
+		t.Errorf("unknown oper: got %s, want %s", got, want)
+	}
+	if got, want := oper("god", "wrong"), parser.ERR_PASSWDMISMATCH; got != want {
+		t.Errorf("wrong password: got %s, want %s", got, want)
+	}
+	if u.HasMode('o') {
+		t.Errorf("+o after failed attempts")
+	}
+	if got, want := oper("god", "blight"), parser.RPL_YOUREOPER; got != want {
+		t.Errorf("correct password: got %s, want %s", got, want)
+	}
+	if !u.HasMode('o') || !u.HasMode('a') || !u.HasMode('s') {
+		t.Errorf("modes = %q, want +o, +a and +s", u.Modes())
+	}
+	if got, want := u.Snomask(), DefaultSnomask; got != want {
+		t.Errorf("snomask = %q, want %q", got, want)
+	}
+	if !hasPrivilege("000AAAOPR", PrivKillLocal) || hasPrivilege("000AAAOPR", PrivKillGlobal) {
+		t.Errorf("privileges: want kill:local only")
+	}
+}
+
+func TestPrivileged(t *testing.T) {
+	u := user.Get("000AAAPRV")
+	defer user.Delete("000AAAPRV")
+
+	call := func(priv string) (reply string) {
+		fn := Privileged(priv, func(string, *parser.Message, *IRCd) {
+			reply = "called"
+		})
+		for _, msg := range runHook(fn, parser.CMD_WALLOPS, "000AAAPRV") {
+			reply = msg.Command
+		}
+		return
+	}
+
+	if got, want := call(PrivWallops), parser.ERR_NOPRIVILEGES; got != want {
+		t.Errorf("non-oper: got %s, want %s", got, want)
+	}
+	changes, _ := mode.UserModes.ParseModeChange([]string{"+o"})
+	u.ApplyModes(changes)
+	u.SetPrivileges([]string{PrivKillGlobal})
+	if got, want := call(PrivWallops), parser.ERR_NOPRIVS; got != want {
+		t.Errorf("oper without privilege: got %s, want %s", got, want)
+	}
+	if got, want := call(PrivKillLocal), "called"; got != want {
+		t.Errorf("oper with kill:global: got %s, want %s", got, want)
+	}
+}
+
+func TestKill(t *testing.T) {
+	SetConfig(&Configuration{SID: "000"})
+	defer SetConfig(nil)
+
+	oper := user.Get("000AAAKL1")
+	defer user.Delete("000AAAKL1")
+	oper.SetNick("killer")
+	changes, _ := mode.UserModes.ParseModeChange([]string{"+o"})
+	oper.ApplyModes(changes)
+	oper.SetPrivileges([]string{PrivKillLocal})
+
+	victim := user.Get("000AAAKL2")
+	defer user.Delete("000AAAKL2")
+	victim.SetNick("victim")
+	user.Get("123AAAKL3").SetNick("faraway")
+	defer user.Delete("123AAAKL3")
+
+	c, _ := channel.Get("#kill", true)
+	c.Join("000AAAKL1")
+	c.Join("000AAAKL2")
+	defer channel.PartAll("000AAAKL1")
+	defer channel.PartAll("000AAAKL2")
+
+	kill := func(args ...string) []*parser.Message {
+		return runHook(Privileged(PrivKillLocal, Kill), parser.CMD_KILL, "000AAAKL1", args...)
+	}
+
+	if msgs := kill("faraway", "bye"); len(msgs) != 1 || msgs[0].Command != parser.ERR_NOPRIVS {
+		t.Errorf("remote KILL without kill:global: got %v, want ERR_NOPRIVS", msgs)
+	}
+	if msgs := kill("nobody"); len(msgs) != 1 || msgs[0].Command != parser.ERR_NOSUCHNICK {
+		t.Errorf("KILL of unknown nick: got %v, want ERR_NOSUCHNICK", msgs)
+	}
+
+	msgs := kill("victim", "flooding")
+	if len(msgs) != 2 {
+		t.Fatalf("KILL victim: got %d messages, want QUIT and ERROR", len(msgs))
+	}
+	if got, want := msgs[0].Args[0], "Killed (killer (flooding))"; msgs[0].Command != parser.CMD_QUIT || got != want {
+		t.Errorf("QUIT = %v, want %q", msgs[0], want)
+	}
+	if got, want := msgs[1].Args[0], "Closing Link (Killed (killer (flooding)))"; msgs[1].Command != parser.CMD_ERROR || got != want {
+		t.Errorf("ERROR = %v, want %q", msgs[1], want)
+	}
+	if c.OnChan("000AAAKL2") {
+		t.Errorf("victim is still on %s", c.Name())
+	}
+}
+
+func TestRouting(t *testing.T) {
+	SetConfig(&Configuration{SID: "000"})
+	defer SetConfig(nil)
+
+	oper := user.Get("000AAARTE")
+	defer user.Delete("000AAARTE")
+	oper.SetNick("router")
+	changes, _ := mode.UserModes.ParseModeChange([]string{"+o"})
+	oper.ApplyModes(changes)
+	oper.SetPrivileges([]string{PrivRouting})
+
+	squit := Privileged(PrivRouting, OperSQuit)
+	if msgs := runHook(squit, parser.CMD_SQUIT, "000AAARTE", "nowhere.example.com"); len(msgs) != 1 || msgs[0].Command != parser.ERR_NOSUCHSERVER {
+		t.Errorf("SQUIT of an unknown server: got %v, want ERR_NOSUCHSERVER", msgs)
+	}
+	connect := Privileged(PrivRouting, Connect)
+	if msgs := runHook(connect, parser.CMD_CONNECT, "000AAARTE", "hub.example.com"); len(msgs) != 1 || msgs[0].Command != parser.CMD_NOTICE {
+		t.Errorf("CONNECT: got %v, want a NOTICE", msgs)
+	}
+
+	oper.SetPrivileges(nil)
+	if msgs := runHook(squit, parser.CMD_SQUIT, "000AAARTE", "nowhere.example.com"); len(msgs) != 1 || msgs[0].Command != parser.ERR_NOPRIVS {
+		t.Errorf("SQUIT without routing: got %v, want ERR_NOPRIVS", msgs)
+	}
+}
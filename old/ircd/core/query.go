@@ -0,0 +1,437 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/channel"
+	"github.com/kylelemons/ircd-blight/old/ircd/log"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/server"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+var (
+	whohooks = []*Hook{
+		Register(parser.CMD_WHO, User, OptArgs(0, 2), Who),
+	}
+	whoishooks = []*Hook{
+		Register(parser.CMD_WHOIS, User, OptArgs(1, 1), Whois),
+		Register(parser.CMD_WHOIS, Server, NArgs(2), SWhois),
+		Register(parser.CMD_WHOWAS, User, OptArgs(1, 2), Whowas),
+	}
+	whosupport = Support("WHOX", nil)
+)
+
+// WHO <mask> [<flags>][%<fields>[,<token>]]
+func Who(hook string, msg *parser.Message, ircd *IRCd) {
+	mask := "*"
+	if len(msg.Args) > 0 && msg.Args[0] != "0" {
+		mask = msg.Args[0]
+	}
+
+	// WHOX field selection follows the %
+	flags, fields, token, whox := "", "", "0", false
+	if len(msg.Args) > 1 {
+		flags = msg.Args[1]
+		if i := strings.IndexByte(flags, '%'); i >= 0 {
+			flags, fields, whox = flags[:i], flags[i+1:], true
+			if j := strings.IndexByte(fields, ','); j >= 0 {
+				fields, token = fields[:j], fields[j+1:]
+			}
+		}
+	}
+	opersOnly := strings.ContainsRune(flags, 'o')
+
+	requester, ok := user.Lookup(msg.SenderID)
+	if !ok {
+		return
+	}
+
+	replies := []*parser.Message{}
+	if parser.ValidChannel(mask) {
+		if c, err := channel.Get(mask, false); err == nil {
+			member := c.OnChan(msg.SenderID)
+			if member || !c.HasMode('s') {
+				for _, uid := range c.UserIDs() {
+					u, ok := user.Lookup(uid)
+					if !ok || (!member && u.HasMode('i')) || (opersOnly && !u.HasMode('o')) {
+						continue
+					}
+					replies = append(replies, whoReply(requester, u, c, whox, fields, token))
+				}
+			}
+		}
+	} else {
+		// Invisible users are only hidden from wildcard searches
+		exact := !parser.IsMask(mask)
+		for uid := range user.Iter() {
+			u, ok := user.Lookup(uid)
+			if !ok || u.Type() != user.RegisteredAsUser || (opersOnly && !u.HasMode('o')) {
+				continue
+			}
+			if exact {
+				if parser.ToLower(u.Nick()) != parser.ToLower(mask) {
+					continue
+				}
+			} else {
+				if !whoMatches(mask, u) {
+					continue
+				}
+				if u.HasMode('i') && uid != msg.SenderID && !sharesChannel(msg.SenderID, uid) {
+					continue
+				}
+			}
+			replies = append(replies, whoReply(requester, u, nil, whox, fields, token))
+		}
+	}
+
+	for _, reply := range replies {
+		ircd.ToClient <- reply
+	}
+	ircd.ToClient <- parser.NewNumeric(parser.RPL_ENDOFWHO, mask).Message(msg.SenderID)
+}
+
+// Check whether a WHO mask matches the user's nick, username, host, real
+// name or server.
+func whoMatches(mask string, u *user.User) bool {
+	nick, username, realname, _ := u.Info()
+	servname, _ := userServer(u.ID())
+	for _, field := range []string{nick, username, u.Host(), realname, servname} {
+		if parser.Match(mask, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Construct an RPL_WHOREPLY (or RPL_WHOSPCRPL for WHOX) about u for the
+// requester.  If c is nil, the reply is not about a particular channel.
+func whoReply(requester, u *user.User, c *channel.Channel, whox bool, fields, token string) *parser.Message {
+	uid := u.ID()
+	nick, username, realname, _ := u.Info()
+	servname, hops := userServer(uid)
+
+	channame, flags := "*", "H"
+	if len(u.Away()) > 0 {
+		flags = "G"
+	}
+	if u.HasMode('o') {
+		flags += "*"
+	}
+	if c != nil {
+		channame = c.Name()
+		if status := c.Status(uid); len(status) > 0 {
+			flags += status[:1]
+		}
+	}
+
+	if !whox {
+		return &parser.Message{
+			Command: parser.RPL_WHOREPLY,
+			Args: []string{
+				"*",
+				channame,
+				username,
+				u.Host(),
+				servname,
+				nick,
+				flags,
+				strconv.Itoa(hops) + " " + realname,
+			},
+			DestIDs: []string{requester.ID()},
+		}
+	}
+
+	// The fields are always sent in this order, regardless of the request
+	args := []string{"*"}
+	for _, field := range "tcuihsnfdlaor" {
+		if !strings.ContainsRune(fields, field) {
+			continue
+		}
+		switch field {
+		case 't':
+			args = append(args, token)
+		case 'c':
+			args = append(args, channame)
+		case 'u':
+			args = append(args, username)
+		case 'i':
+			// Only operators may see other users' IP addresses
+			ip := "255.255.255.255"
+			if requester.HasMode('o') || requester == u {
+				ip = u.IP()
+			}
+			args = append(args, ip)
+		case 'h':
+			args = append(args, u.Host())
+		case 's':
+			args = append(args, servname)
+		case 'n':
+			args = append(args, nick)
+		case 'f':
+			args = append(args, flags)
+		case 'd':
+			args = append(args, strconv.Itoa(hops))
+		case 'l':
+			idle := int64(0)
+			if uid[:3] == Config().SID {
+				idle = u.Idle()
+			}
+			args = append(args, strconv.FormatInt(idle, 10))
+		case 'a':
+			account := u.Account()
+			if len(account) == 0 {
+				account = "0"
+			}
+			args = append(args, account)
+		case 'o':
+			args = append(args, "n/a")
+		case 'r':
+			args = append(args, realname)
+		}
+	}
+	return &parser.Message{
+		Command: parser.RPL_WHOSPCRPL,
+		Args:    args,
+		DestIDs: []string{requester.ID()},
+	}
+}
+
+// WHOIS [<server>] <nick>[,<nick>...]
+func Whois(hook string, msg *parser.Message, ircd *IRCd) {
+	nicks := msg.Args[len(msg.Args)-1]
+
+	// The server may also be given as the nick of a user on it
+	if len(msg.Args) == 2 {
+		sid, ok := findServer(msg.Args[0])
+		if !ok {
+			ircd.ToClient <- parser.NewNumeric(parser.ERR_NOSUCHSERVER, msg.Args[0]).Message(msg.SenderID)
+			return
+		}
+		if sid != Config().SID {
+			for link := range server.IterFor([]string{sid}, "") {
+				ircd.ToServer <- &parser.Message{
+					Prefix:  msg.SenderID,
+					Command: parser.CMD_WHOIS,
+					Args: []string{
+						sid,
+						nicks,
+					},
+					DestIDs: []string{link},
+				}
+			}
+			return
+		}
+	}
+
+	for _, reply := range whoisReplies(msg.SenderID, nicks) {
+		ircd.ToClient <- reply
+	}
+}
+
+// Server WHOIS (remote WHOIS from a user on another server)
+func SWhois(hook string, msg *parser.Message, ircd *IRCd) {
+	target, nicks := msg.Args[0], msg.Args[1]
+	if len(target) < 3 {
+		log.Warn.Printf("WHOIS from %s for bad target %q", msg.SenderID, target)
+		return
+	}
+
+	if target[:3] == Config().SID {
+		for _, reply := range whoisReplies(msg.Prefix, nicks) {
+			sendReply(reply, ircd)
+		}
+		return
+	}
+
+	for sid := range server.IterFor([]string{target}, msg.SenderID) {
+		log.Debug.Printf("Forwarding WHOIS from %s to %s", msg.SenderID, sid)
+		fmsg := msg.Dup()
+		fmsg.DestIDs = []string{sid}
+		ircd.ToServer <- fmsg
+	}
+}
+
+// Construct the WHOIS replies about each of the comma-separated nicks for the
+// requester.
+func whoisReplies(requesterID, nicks string) []*parser.Message {
+	conf := Config()
+	replies := []*parser.Message{}
+	auspex := hasPrivilege(requesterID, PrivAuspex)
+	for _, nick := range strings.Split(nicks, ",") {
+		uid, err := user.GetID(nick)
+		u, ok := user.Lookup(uid)
+		if err != nil || !ok {
+			replies = append(replies, parser.NewNumeric(parser.ERR_NOSUCHNICK, nick).Message(requesterID))
+			replies = append(replies, parser.NewNumeric(parser.RPL_ENDOFWHOIS, nick).Message(requesterID))
+			continue
+		}
+		nick, username, realname, _ := u.Info()
+
+		replies = append(replies, &parser.Message{
+			Command: parser.RPL_WHOISUSER,
+			Args: []string{
+				"*",
+				nick,
+				username,
+				u.Host(),
+				"*",
+				realname,
+			},
+			DestIDs: []string{requesterID},
+		})
+
+		// Secret and private channels are only shown to their members (and
+		// to opers with auspex)
+		chans := []string{}
+		for _, c := range channel.UserChannels(uid) {
+			hidden := c.HasMode('s') || c.HasMode('p')
+			if hidden && uid != requesterID && !c.OnChan(requesterID) && !auspex {
+				continue
+			}
+			status := c.Status(uid)
+			if len(status) > 0 {
+				status = status[:1]
+			}
+			chans = append(chans, status+c.Name())
+		}
+		// :<server> 319 <requester> <nick> :<channels>\r\n
+		requester, _, _, _, _ := user.GetInfo(requesterID)
+		maxlen := 510 - len(":"+conf.Name+" "+parser.RPL_WHOISCHANNELS+" "+requester+" "+nick+" :")
+		line := ""
+		for i, name := range chans {
+			if len(line) > 0 {
+				line += " "
+			}
+			line += name
+			if i == len(chans)-1 || len(line)+1+len(chans[i+1]) > maxlen {
+				reply := parser.NewNumeric(parser.RPL_WHOISCHANNELS, nick).Message(requesterID)
+				reply.Args[len(reply.Args)-1] = line
+				replies = append(replies, reply)
+				line = ""
+			}
+		}
+
+		servname, _ := userServer(uid)
+		reply := parser.NewNumeric(parser.RPL_WHOISSERVER, nick, servname).Message(requesterID)
+		reply.Args[len(reply.Args)-1] = serverDescription(uid[:3])
+		replies = append(replies, reply)
+
+		if away := u.Away(); len(away) > 0 {
+			reply := parser.NewNumeric(parser.RPL_AWAY, nick).Message(requesterID)
+			reply.Args[len(reply.Args)-1] = away
+			replies = append(replies, reply)
+		}
+
+		if u.HasMode('o') {
+			replies = append(replies, parser.NewNumeric(parser.RPL_WHOISOPERATOR, nick).Message(requesterID))
+		}
+
+		if account := u.Account(); len(account) > 0 {
+			replies = append(replies, parser.NewNumeric(parser.RPL_WHOISACCOUNT, nick, account).Message(requesterID))
+		}
+
+		// Idle times are only known for local users
+		if uid[:3] == conf.SID {
+			idle, signon := strconv.FormatInt(u.Idle(), 10), strconv.FormatInt(u.Signon(), 10)
+			replies = append(replies, parser.NewNumeric(parser.RPL_WHOISIDLE, nick, idle, signon).Message(requesterID))
+		}
+
+		replies = append(replies, parser.NewNumeric(parser.RPL_ENDOFWHOIS, nick).Message(requesterID))
+	}
+	return replies
+}
+
+// WHOWAS <nick>[,<nick>...] [<count>]
+func Whowas(hook string, msg *parser.Message, ircd *IRCd) {
+	conf := Config()
+	count := 0
+	if len(msg.Args) > 1 {
+		count, _ = strconv.Atoi(msg.Args[1])
+	}
+
+	for _, nick := range strings.Split(msg.Args[0], ",") {
+		entries := user.GetWhoWas(nick, count)
+		if len(entries) == 0 {
+			ircd.ToClient <- parser.NewNumeric(parser.ERR_WASNOSUCHNICK, nick).Message(msg.SenderID)
+		}
+		for _, entry := range entries {
+			ircd.ToClient <- &parser.Message{
+				Command: parser.RPL_WHOWASUSER,
+				Args: []string{
+					"*",
+					entry.Nick,
+					entry.User,
+					entry.Host,
+					"*",
+					entry.Name,
+				},
+				DestIDs: []string{msg.SenderID},
+			}
+
+			servname := entry.SID
+			if entry.SID == conf.SID {
+				servname = conf.Name
+			} else if _, name, _, _, ok := server.GetInfo(entry.SID); ok {
+				servname = name
+			}
+			reply := parser.NewNumeric(parser.RPL_WHOISSERVER, entry.Nick, servname).Message(msg.SenderID)
+			reply.Args[len(reply.Args)-1] = time.Unix(entry.Time, 0).UTC().Format(time.RFC1123)
+			ircd.ToClient <- reply
+		}
+		ircd.ToClient <- parser.NewNumeric(parser.RPL_ENDOFWHOWAS, nick).Message(msg.SenderID)
+	}
+}
+
+// Get the SID of the server with the given name (or mask), or of the server
+// the user with the given nick is on.
+func findServer(name string) (sid string, ok bool) {
+	conf := Config()
+	if parser.Match(name, conf.Name) {
+		return conf.SID, true
+	}
+	if uid, err := user.GetID(name); err == nil {
+		return uid[:3], true
+	}
+	return server.Find(name)
+}
+
+// Get the description of a server.
+func serverDescription(sid string) string {
+	conf := Config()
+	if sid == conf.SID {
+		if conf.Network != nil {
+			return conf.Network.Description
+		}
+		return ""
+	}
+	if s := server.Get(sid, false); s != nil {
+		return s.Description()
+	}
+	return ""
+}
+
+// Get the name of the server a user is on and the number of hops to it.
+func userServer(uid string) (name string, hops int) {
+	conf := Config()
+	if uid[:3] == conf.SID {
+		return conf.Name, 0
+	}
+	if s := server.Get(uid[:3], false); s != nil {
+		_, name, _, _ = s.Info()
+		return name, s.Hops()
+	}
+	return "*", 0
+}
+
+// Check whether two users share a channel.
+func sharesChannel(a, b string) bool {
+	for _, c := range channel.UserChannels(a) {
+		if c.OnChan(b) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/channel"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+func TestWhoisChannels(t *testing.T) {
+	SetConfig(&Configuration{SID: "000", Name: "irc.example.com"})
+	defer SetConfig(nil)
+
+	u := user.Get("000AAAWHO")
+	defer user.Delete("000AAAWHO")
+	u.SetNick("joiner")
+	defer channel.PartAll("000AAAWHO")
+
+	want := []string{}
+	for i := 0; i < 40; i++ {
+		name := fmt.Sprintf("#a-rather-long-channel-name-%02d", i)
+		c, _ := channel.Get(name, true)
+		c.Join("000AAAWHO")
+		want = append(want, name)
+	}
+
+	got := []string{}
+	for _, reply := range whoisReplies("000AAAWHO", "joiner") {
+		if reply.Command != parser.RPL_WHOISCHANNELS {
+			continue
+		}
+		line := ":irc.example.com " + reply.Command + " joiner joiner :" + reply.Args[len(reply.Args)-1]
+		if len(line) > 510 {
+			t.Errorf("RPL_WHOISCHANNELS is %d bytes long, want at most 510", len(line))
+		}
+		got = append(got, strings.Fields(reply.Args[len(reply.Args)-1])...)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d channels, want %d", len(got), len(want))
+	}
+	seen := map[string]bool{}
+	for _, name := range got {
+		seen[name] = true
+	}
+	for _, name := range want {
+		if !seen[name] {
+			t.Errorf("%s missing from RPL_WHOISCHANNELS", name)
+		}
+	}
+}
+
+func TestSWhoisBadTarget(t *testing.T) {
+	if msgs := runHook(SWhois, parser.CMD_WHOIS, "123", "", "joiner"); len(msgs) != 0 {
+		t.Errorf("WHOIS for an empty target: got %v, want nothing", msgs)
+	}
+}
@@ -2,10 +2,13 @@ package core
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kylelemons/ircd-blight/old/ircd/channel"
 	"github.com/kylelemons/ircd-blight/old/ircd/log"
+	"github.com/kylelemons/ircd-blight/old/ircd/mode"
 	"github.com/kylelemons/ircd-blight/old/ircd/parser"
 	"github.com/kylelemons/ircd-blight/old/ircd/server"
 	"github.com/kylelemons/ircd-blight/old/ircd/user"
@@ -18,7 +21,10 @@ var (
 		Register(parser.CMD_SERVER, Registration, AnyArgs, ConnReg),
 		Register(parser.CMD_PASS, Registration, AnyArgs, ConnReg),
 		Register(parser.CMD_CAPAB, Registration, AnyArgs, ConnReg),
+		Register(parser.CMD_NICK, User, NArgs(1), Nick),
+		Register(parser.CMD_NICK, Server, NArgs(2), Nick),
 		Register(parser.CMD_UID, Server, NArgs(9), Uid),
+		Register(parser.CMD_EUID, Server, NArgs(11), Uid),
 		Register(parser.CMD_SID, Server, NArgs(4), Sid),
 	}
 	quithooks = []*Hook{
@@ -53,6 +59,11 @@ func ConnReg(hook string, msg *parser.Message, ircd *IRCd) {
 		if u != nil {
 			username, realname := msg.Args[0], msg.Args[3]
 			err = u.SetUser(username, realname)
+
+			// Without ident, the username is only what the client says
+			if class, ok := findClass(u.Class()); ok && err == nil && !class.HasFlag("noident") {
+				u.SetIdentHost("~"+username, u.Host())
+			}
 		}
 	case parser.CMD_PASS:
 		if s != nil {
@@ -93,32 +104,8 @@ func ConnReg(hook string, msg *parser.Message, ircd *IRCd) {
 			}
 		}
 
-		nickname, username, realname, _ := u.Info()
-		if nickname != "*" && username != "" {
-			// Notify servers
-			for sid := range server.Iter() {
-				ircd.ToServer <- &parser.Message{
-					Prefix:  Config.SID,
-					Command: parser.CMD_UID,
-					Args: []string{
-						nickname,
-						"1",
-						u.TS(),
-						"+i",
-						username,
-						"some.host",
-						"127.0.0.1",
-						u.ID(),
-						realname,
-					},
-					DestIDs: []string{sid},
-				}
-			}
-
-			// Process signon
-			sendSignon(u, ircd)
-			return
-		}
+		registerUser(u, ircd)
+		return
 	}
 
 	if s != nil {
@@ -145,7 +132,7 @@ func ConnReg(hook string, msg *parser.Message, ircd *IRCd) {
 			// Notify servers
 			for sid := range server.Iter() {
 				ircd.ToServer <- &parser.Message{
-					Prefix:  Config.SID,
+					Prefix:  Config().SID,
 					Command: parser.CMD_SID,
 					Args: []string{
 						serv,
@@ -163,54 +150,188 @@ func ConnReg(hook string, msg *parser.Message, ircd *IRCd) {
 	}
 }
 
+// Handle NICK changes from registered users
+func Nick(hook string, msg *parser.Message, ircd *IRCd) {
+	conf := Config()
+	changer := msg.SenderID
+	if len(msg.SenderID) == 3 {
+		changer = msg.Prefix
+	}
+
+	u, ok := user.Lookup(changer)
+	if !ok {
+		log.Warn.Printf("NICK from unknown user %s", changer)
+		return
+	}
+
+	// The prefix has to be given explicitly, since the ID would be rendered
+	// with the new nick.
+	oldmask, oldnick := u.Hostmask(), u.Nick()
+	if err := u.SetNick(msg.Args[0]); err != nil {
+		if num, ok := err.(*parser.Numeric); ok && len(msg.SenderID) == 9 {
+			ircd.ToClient <- num.Message(msg.SenderID)
+		} else {
+			log.Warn.Printf("NICK %s from %s failed: %s", msg.Args[0], changer, err)
+		}
+		return
+	}
+	newnick := u.Nick()
+	if newnick == oldnick {
+		return
+	}
+
+	if parser.ToLower(newnick) != parser.ToLower(oldnick) {
+		monitorOffline(ircd, oldnick)
+		monitorOnline(ircd, u)
+	}
+
+	// Forward to other servers
+	for sid := range server.Iter() {
+		if sid != msg.SenderID {
+			ircd.ToServer <- &parser.Message{
+				Prefix:  changer,
+				Command: parser.CMD_NICK,
+				Args: []string{
+					newnick,
+					u.TS(),
+				},
+				DestIDs: []string{sid},
+			}
+		}
+	}
+
+	// Notify the user and everyone on a channel with them
+	peers := make(map[string]bool)
+	if changer[:3] == conf.SID {
+		peers[changer] = true
+	}
+	for _, c := range channel.UserChannels(changer) {
+		for _, uid := range c.UserIDs() {
+			if uid[:3] == conf.SID {
+				peers[uid] = true
+			}
+		}
+	}
+	if len(peers) > 0 {
+		notify := []string{}
+		for peer := range peers {
+			notify = append(notify, peer)
+		}
+		ircd.ToClient <- &parser.Message{
+			Prefix:  oldmask,
+			Command: parser.CMD_NICK,
+			Args: []string{
+				newnick,
+			},
+			DestIDs: notify,
+		}
+	}
+}
+
+// Register a local user once NICK and USER have been received, unless
+// registration is suspended for capability negotiation.
+func registerUser(u *user.User, ircd *IRCd) {
+	nickname, username, _, _ := u.Info()
+	if nickname == "*" || username == "" || u.Negotiating() {
+		return
+	}
+
+	if b, banned := checkBans(u); banned {
+		rejectBanned(u, b, ircd)
+		return
+	}
+
+	// Only the first caller gets to register the user
+	if err := u.SetType(user.RegisteredAsUser); err != nil {
+		return
+	}
+
+	// Process signon
+	sendSignon(u, ircd)
+
+	// Notify servers
+	for sid := range server.Iter() {
+		for _, msg := range uidMessages(u, sid) {
+			ircd.ToServer <- msg
+		}
+	}
+}
+
 func sendSignon(u *user.User, ircd *IRCd) {
+	conf := Config()
 	log.Info.Printf("[%s] ** Registered\n", u.ID())
-	u.SetType(user.RegisteredAsUser)
 
 	destIDs := []string{u.ID()}
 	// RPL_WELCOME
+	// (without a network, this server is the whole network)
+	network := conf.Name
+	if conf.Network != nil && len(conf.Network.Name) > 0 {
+		network = conf.Network.Name
+	}
 	msg := parser.NewNumeric(parser.RPL_WELCOME).Message()
-	msg.Args[1] = "Welcome to the " + Config.Network.Name + " network, " + u.Nick() + "!"
+	msg.Args[1] = "Welcome to the " + network + " network, " + u.Nick() + "!"
 	msg.DestIDs = destIDs
 	ircd.ToClient <- msg
 
 	// RPL_YOURHOST
 	msg = parser.NewNumeric(parser.RPL_YOURHOST).Message()
-	msg.Args[1] = fmt.Sprintf("Your host is %s, running ircd-blight/%s", Config.Name, REPO_VERSION)
+	msg.Args[1] = fmt.Sprintf("Your host is %s, running ircd-blight/%s", conf.Name, REPO_VERSION)
 	msg.DestIDs = destIDs
 	ircd.ToClient <- msg
 
 	// RPL_CREATED
+	msg = parser.NewNumeric(parser.RPL_CREATED).Message()
+	msg.Args[1] = "This server was created " + Started.UTC().Format(time.RFC1123)
+	msg.DestIDs = destIDs
+	ircd.ToClient <- msg
+
 	// RPL_MYINFO
-	// RPL_ISUPPORT
+	ircd.ToClient <- &parser.Message{
+		Command: parser.RPL_MYINFO,
+		Args: []string{
+			"*",
+			conf.Name,
+			"ircd-blight/" + REPO_VERSION,
+			mode.UserModes.Chars(),
+			mode.ChannelModes.Chars(),
+			mode.ChannelModes.ArgChars(),
+		},
+		DestIDs: destIDs,
+	}
 
-	// RPL_LUSERCLIENT
-	// RPL_LUSEROP
-	// RPL_LUSERUNKNOWN
-	// RPL_LUSERCHANNELS
-	// RPL_LUSERME
+	// RPL_ISUPPORT
+	for _, msg := range supportMessages(destIDs...) {
+		ircd.ToClient <- msg
+	}
 
-	// RPL_LOCALUSERS
-	// RPL_GLOBALUSERS
+	// RPL_LUSERCLIENT ... RPL_GLOBALUSERS
+	for _, msg := range lusersReplies(u.ID()) {
+		ircd.ToClient <- msg
+	}
 
-	// RPL_NOMOTD
-	msg = parser.NewNumeric(parser.ERR_NOMOTD).Message()
-	msg.DestIDs = destIDs
-	ircd.ToClient <- msg
+	// RPL_MOTDSTART ... RPL_ENDOFMOTD (or ERR_NOMOTD)
+	for _, msg := range motdReplies(u.ID()) {
+		ircd.ToClient <- msg
+	}
 
+	changes, _ := mode.UserModes.ParseModeChange([]string{"+i"})
+	u.ApplyModes(changes)
 	msg = &parser.Message{
 		Command: parser.CMD_MODE,
 		Prefix:  "*",
 		Args: []string{
 			"*",
-			"+i",
+			u.Modes(),
 		},
 		DestIDs: destIDs,
 	}
 	ircd.ToClient <- msg
+
+	monitorOnline(ircd, u)
 }
 
 func sendServerSignon(s *server.Server, ircd *IRCd) {
+	conf := Config()
 	log.Info.Printf("{%s} ** Registered As Server\n", s.ID())
 	s.SetType(server.RegisteredAsServer)
 
@@ -224,7 +345,7 @@ func sendServerSignon(s *server.Server, ircd *IRCd) {
 			"testpass", // TODO
 			"TS",
 			"6",
-			Config.SID,
+			conf.SID,
 		},
 		DestIDs: destIDs,
 	}
@@ -234,7 +355,7 @@ func sendServerSignon(s *server.Server, ircd *IRCd) {
 		Command: parser.CMD_CAPAB,
 		Args: []string{
 			//"QS EX CHW IE KLN KNOCK TB UNKLN CLUSTER ENCAP SERVICES RSFNC SAVE EUID EOPMOD BAN MLOCK",
-			"QS ENCAP", // TODO
+			"QS ENCAP TB EUID", // TODO
 		},
 		DestIDs: destIDs,
 	}
@@ -243,7 +364,7 @@ func sendServerSignon(s *server.Server, ircd *IRCd) {
 	msg = &parser.Message{
 		Command: parser.CMD_SERVER,
 		Args: []string{
-			Config.Name,
+			conf.Name,
 			"1",
 			"IRCd",
 		},
@@ -254,72 +375,84 @@ func sendServerSignon(s *server.Server, ircd *IRCd) {
 
 func Burst(serv *server.Server, ircd *IRCd) {
 	destIDs := []string{serv.ID()}
-	sid := Config.SID
+	sid := Config().SID
 	var msg *parser.Message
 
 	// SID/SERVER
 	// UID/EUID
 	for uid := range user.Iter() {
 		u := user.Get(uid)
-		nick, username, name, typ := u.Info()
-		if typ != user.RegisteredAsUser {
+		if u.Type() != user.RegisteredAsUser {
 			continue
 		}
+		for _, msg := range uidMessages(u, serv.ID()) {
+			ircd.ToServer <- msg
+		}
+
+		if away := u.Away(); len(away) > 0 {
+			ircd.ToServer <- &parser.Message{
+				Prefix:  uid,
+				Command: parser.CMD_AWAY,
+				Args: []string{
+					away,
+				},
+				DestIDs: destIDs,
+			}
+		}
+	}
+	// Optional: ENCAP REALHOST
+	// SJOIN
+	for channame := range channel.Iter() {
+		chanobj, _ := channel.Get(channame, false)
+		args := []string{chanobj.TS(), channame}
+		args = append(args, chanobj.Modes()...)
+		args = append(args, strings.Join(chanobj.UserIDsWithPrefix(), " "))
 		msg = &parser.Message{
 			Prefix:  sid,
-			Command: parser.CMD_UID,
-			Args: []string{
-				nick,
-				// hopcount
-				"1",
-				u.TS(),
-				// umodes
-				"+i",
-				username,
-				// visible hostname
-				"some.host",
-				// IP addr
-				"127.0.0.1",
-				uid,
-				name,
-			},
+			Command: parser.CMD_SJOIN,
+			Args:    args,
 			DestIDs: destIDs,
 		}
 		ircd.ToServer <- msg
 	}
-	// Optional: ENCAP REALHOST, ENCAP LOGIN, AWAY
-	// SJOIN
+	// Optional: BMAST
+	// TB
 	for channame := range channel.Iter() {
 		chanobj, _ := channel.Get(channame, false)
+		if chanobj == nil {
+			continue
+		}
+		topic, setter, ts := chanobj.Topic()
+		if len(topic) == 0 {
+			continue
+		}
 		msg = &parser.Message{
 			Prefix:  sid,
-			Command: parser.CMD_SJOIN,
+			Command: parser.CMD_TB,
 			Args: []string{
-				chanobj.TS(),
 				channame,
-				// modes, params...
-				"+", // "+nt",
-				strings.Join(chanobj.UserIDsWithPrefix(), " "),
+				strconv.FormatInt(ts, 10),
+				setter,
+				topic,
 			},
 			DestIDs: destIDs,
 		}
 		ircd.ToServer <- msg
 	}
-	// Optional: BMAST
-	// Optional: TB
 }
 
+// UID <nick> <hops> <ts> <umodes> <user> <host> <ip> <uid> :<name>
+// EUID <nick> <hops> <ts> <umodes> <user> <host> <ip> <uid> <realhost> <account> :<name>
 func Uid(hook string, msg *parser.Message, ircd *IRCd) {
 	nickname, hopcount, nickTS := msg.Args[0], msg.Args[1], msg.Args[2]
 	umode, username, hostname := msg.Args[3], msg.Args[4], msg.Args[5]
-	ip, uid, name := msg.Args[6], msg.Args[7], msg.Args[8]
-	_ = umode
+	ip, uid, name := msg.Args[6], msg.Args[7], msg.Args[len(msg.Args)-1]
 
-	err := user.Import(uid, nickname, username, hostname, ip, hopcount, nickTS, name)
+	err := user.Import(uid, nickname, username, hostname, ip, hopcount, nickTS, umode, name)
 	if err != nil {
 		// TODO: TS check - Kill remote or local? For now, we kill remote.
 		ircd.ToServer <- &parser.Message{
-			Prefix:  Config.SID,
+			Prefix:  Config().SID,
 			Command: parser.CMD_SQUIT,
 			Args: []string{
 				uid,
@@ -327,6 +460,11 @@ func Uid(hook string, msg *parser.Message, ircd *IRCd) {
 			},
 			DestIDs: []string{msg.SenderID},
 		}
+	} else if u, ok := user.Lookup(uid); ok {
+		if hook == parser.CMD_EUID && msg.Args[9] != "*" {
+			u.SetAccount(msg.Args[9])
+		}
+		monitorOnline(ircd, u)
 	}
 
 	for fwd := range server.Iter() {
@@ -338,13 +476,70 @@ func Uid(hook string, msg *parser.Message, ircd *IRCd) {
 	}
 }
 
+// Construct the messages introducing a user to a directly linked server:
+// EUID if the server supports it, or UID followed by ENCAP LOGIN otherwise.
+func uidMessages(u *user.User, sid string) []*parser.Message {
+	nick, username, name, _ := u.Info()
+	uid, account := u.ID(), u.Account()
+
+	args := []string{
+		nick,
+		// hopcount
+		"1",
+		u.TS(),
+		u.Modes(),
+		username,
+		// visible hostname
+		u.Host(),
+		// IP addr
+		u.IP(),
+		uid,
+	}
+
+	euid := false
+	if s := server.Get(sid, false); s != nil {
+		euid = s.HasCapab("EUID")
+	}
+	if euid {
+		loggedIn := account
+		if len(loggedIn) == 0 {
+			loggedIn = "*"
+		}
+		// real hostname, account
+		args = append(args, u.Host(), loggedIn)
+	}
+	args = append(args, name)
+
+	msgs := []*parser.Message{{
+		Prefix:  uid[:3],
+		Command: parser.CMD_UID,
+		Args:    args,
+		DestIDs: []string{sid},
+	}}
+	if euid {
+		msgs[0].Command = parser.CMD_EUID
+	} else if len(account) > 0 {
+		msgs = append(msgs, &parser.Message{
+			Prefix:  uid,
+			Command: parser.CMD_ENCAP,
+			Args: []string{
+				"*",
+				"LOGIN",
+				account,
+			},
+			DestIDs: []string{sid},
+		})
+	}
+	return msgs
+}
+
 func Sid(hook string, msg *parser.Message, ircd *IRCd) {
 	servname, hopcount, sid, desc := msg.Args[0], msg.Args[1], msg.Args[2], msg.Args[3]
 
 	err := server.Link(msg.Prefix, sid, servname, hopcount, desc)
 	if err != nil {
 		ircd.ToServer <- &parser.Message{
-			Prefix:  Config.SID,
+			Prefix:  Config().SID,
 			Command: parser.CMD_SQUIT,
 			Args: []string{
 				sid,
@@ -375,9 +570,16 @@ func Quit(hook string, msg *parser.Message, ircd *IRCd) {
 		quitter = msg.Prefix
 	}
 
+	exitUser(quitter, reason, "Quit: "+reason, msg.SenderID, ircd)
+}
+
+// Remove a user from the network: the QUIT (with the given reason) is sent
+// to every server except from, the message is sent to the local users who
+// shared a channel with them, and a local user is disconnected.
+func exitUser(quitter, reason, message, from string, ircd *IRCd) {
 	for sid := range server.Iter() {
 		log.Debug.Printf("Forwarding QUIT from %s to %s", quitter, sid)
-		if sid != msg.SenderID {
+		if sid != from {
 			ircd.ToServer <- &parser.Message{
 				Prefix:  quitter,
 				Command: parser.CMD_QUIT,
@@ -389,12 +591,34 @@ func Quit(hook string, msg *parser.Message, ircd *IRCd) {
 		}
 	}
 
+	quitChannels(quitter, message, ircd)
+
+	// Will be dropped if it's a remote client
+	error := &parser.Message{
+		Command: parser.CMD_ERROR,
+		Args: []string{
+			"Closing Link (" + reason + ")",
+		},
+		DestIDs: []string{
+			quitter,
+		},
+	}
+	ircd.ToClient <- error
+}
+
+// Remove a user who is leaving the network from all of their channels and
+// send the QUIT (with the given message) to the local users who shared them.
+func quitChannels(quitter, message string, ircd *IRCd) {
+	if u, ok := user.Lookup(quitter); ok && u.Type() == user.RegisteredAsUser {
+		monitorOffline(ircd, u.Nick())
+	}
+
 	members := channel.PartAll(quitter)
 	log.Debug.Printf("QUIT recipients: %#v", members)
 	peers := make(map[string]bool)
 	for _, users := range members {
 		for _, uid := range users {
-			if uid[:3] == Config.SID && uid != quitter {
+			if uid[:3] == Config().SID && uid != quitter {
 				peers[uid] = true
 			}
 		}
@@ -408,29 +632,18 @@ func Quit(hook string, msg *parser.Message, ircd *IRCd) {
 			Prefix:  quitter,
 			Command: parser.CMD_QUIT,
 			Args: []string{
-				"Quit: " + reason,
+				message,
 			},
 			DestIDs: notify,
 		}
 	}
-
-	// Will be dropped if it's a remote client
-	error := &parser.Message{
-		Command: parser.CMD_ERROR,
-		Args: []string{
-			"Closing Link (" + reason + ")",
-		},
-		DestIDs: []string{
-			quitter,
-		},
-	}
-	ircd.ToClient <- error
 }
 
 func SQuit(hook string, msg *parser.Message, ircd *IRCd) {
+	conf := Config()
 	split, reason := msg.Args[0], msg.Args[1]
 
-	if split == Config.SID {
+	if split == conf.SID {
 		split = msg.SenderID
 	}
 
@@ -448,12 +661,13 @@ func SQuit(hook string, msg *parser.Message, ircd *IRCd) {
 			Args: []string{
 				"SQUIT: " + reason,
 			},
+			DestIDs: []string{split},
 		}
 	}
 
 	sids := server.Unlink(split)
 	peers := user.Netsplit(sids)
-	notify := channel.Netsplit(Config.SID, peers)
+	notify := channel.Netsplit(conf.SID, peers)
 
 	log.Debug.Printf("NET SPLIT: %s", split)
 	log.Debug.Printf(" -   SIDs: %v", sids)
@@ -472,6 +686,15 @@ func SQuit(hook string, msg *parser.Message, ircd *IRCd) {
 			}
 		}
 	}
+	// Tell anyone monitoring them
+	nicks := make([]string, 0, len(peers))
+	for _, uid := range peers {
+		if u, ok := user.Lookup(uid); ok {
+			nicks = append(nicks, u.Nick())
+		}
+	}
+	monitorOffline(ircd, nicks...)
+
 	// Delete all of the peers
 	if len(peers) > 0 {
 		ircd.ToClient <- &parser.Message{
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+func TestSignonWithoutNetwork(t *testing.T) {
+	SetConfig(&Configuration{SID: "000", Name: "irc.example.com"})
+	defer SetConfig(nil)
+
+	ircd := &IRCd{ToClient: make(chan *parser.Message, 100)}
+	u := user.Get("000AAASGN")
+	defer user.Delete("000AAASGN")
+	u.SetNick("newbie")
+
+	sendSignon(u, ircd)
+	welcome := <-ircd.ToClient
+	if got, want := welcome.Command, parser.RPL_WELCOME; got != want {
+		t.Fatalf("first reply: got %s, want %s", got, want)
+	}
+	if got, want := welcome.Args[1], "Welcome to the irc.example.com network, newbie!"; got != want {
+		t.Errorf("RPL_WELCOME = %q, want %q", got, want)
+	}
+}
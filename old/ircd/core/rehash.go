@@ -0,0 +1,246 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/log"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+)
+
+var (
+	rehashhooks = []*Hook{
+		Register(parser.CMD_REHASH, User, OptArgs(0, 1), Privileged(PrivRehash, Rehash)),
+	}
+
+	// Only one rehash happens at a time
+	rehashMutex = new(sync.Mutex)
+)
+
+// REHASH
+func Rehash(hook string, msg *parser.Message, ircd *IRCd) {
+	uid := msg.SenderID
+	ircd.ToClient <- parser.NewNumeric(parser.RPL_REHASHING, ConfigFile).Message(uid)
+
+	changes, err := ircd.rehash(banSetter(uid))
+	if err != nil {
+		ircd.ToClient <- notice(uid, "Rehash failed: "+err.Error()+" (the old configuration is still in use)")
+		return
+	}
+	if len(changes) == 0 {
+		ircd.ToClient <- notice(uid, "Rehash complete: nothing changed")
+		return
+	}
+	for _, change := range changes {
+		ircd.ToClient <- notice(uid, "Rehash: "+change)
+	}
+}
+
+// Reload the configuration file on behalf of who (an oper's nick or the
+// reason, e.g. SIGHUP), and listen on any new ports.  The changes are
+// returned; if there is an error, the old configuration is kept.
+func (s *IRCd) rehash(who string) (changes []string, err error) {
+	rehashMutex.Lock()
+	defer rehashMutex.Unlock()
+
+	log.Info.Printf("%s is rehashing %s", who, ConfigFile)
+	serverNotice(SnoConf, who+" is rehashing server config file", s)
+
+	old, changes, err := reloadConfig()
+	if err != nil {
+		log.Error.Printf("Rehash failed: %s", err)
+		serverNotice(SnoConf, "Rehash failed: "+err.Error(), s)
+		return nil, err
+	}
+	changes = append(changes, s.updatePorts(old, Config())...)
+	updateSASLCap(Config(), s)
+
+	for _, change := range changes {
+		log.Info.Printf("Rehash: %s", change)
+	}
+	serverNotice(SnoConf, "Rehash complete: "+strconv.Itoa(len(changes))+" changes", s)
+	return changes, nil
+}
+
+// Reread, check and swap in the configuration file and the files it names
+// (all or nothing), returning the old
+// configuration and a description of what changed (other than the ports).
+// The server name and SID cannot be changed without a restart.
+func reloadConfig() (old *Configuration, changes []string, err error) {
+	if errs := ValidateConfigFile(ConfigFile); len(errs) > 0 {
+		for _, err := range errs {
+			log.Error.Printf("%s: %s", ConfigFile, err)
+		}
+		return nil, nil, errs[0]
+	}
+	conf, err := readConfigFile(ConfigFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	old = Config()
+	if conf.Name != old.Name || conf.SID != old.SID {
+		return nil, nil, errors.New("the server name and SID cannot be changed without a restart")
+	}
+	if !checkConfig(conf) {
+		return nil, nil, errors.New("invalid configuration (see the log)")
+	}
+	files, err := readConfigFiles(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Nothing is changed until everything has been read and checked
+	applyConfig(conf, files)
+	return old, configChanges(old, conf), nil
+}
+
+// Describe the differences between two configurations, except for ports.
+func configChanges(old, conf *Configuration) (changes []string) {
+	classes := func(c *Configuration) map[string]interface{} {
+		m := make(map[string]interface{})
+		for _, class := range c.Class {
+			m[class.Name] = class
+		}
+		return m
+	}
+	operclasses := func(c *Configuration) map[string]interface{} {
+		m := make(map[string]interface{})
+		for _, class := range c.OperClass {
+			m[class.Name] = class
+		}
+		return m
+	}
+	opers := func(c *Configuration) map[string]interface{} {
+		m := make(map[string]interface{})
+		for _, oper := range c.Operator {
+			m[oper.Name] = oper
+		}
+		return m
+	}
+	changes = append(changes, diffNamed("class", classes(old), classes(conf))...)
+	changes = append(changes, diffNamed("oper class", operclasses(old), operclasses(conf))...)
+	changes = append(changes, diffNamed("operator", opers(old), opers(conf))...)
+
+	files := []struct {
+		name     string
+		old, new string
+	}{
+		{"MOTD file", old.Motd, conf.Motd},
+		{"accounts file", old.Accounts, conf.Accounts},
+		{"bans file", old.Bans, conf.Bans},
+	}
+	for _, f := range files {
+		if f.old != f.new {
+			changes = append(changes, f.name+" changed from "+strconv.Quote(f.old)+" to "+strconv.Quote(f.new))
+		}
+	}
+
+	if old.Admin != conf.Admin {
+		changes = append(changes, "changed admin")
+	}
+	if !reflect.DeepEqual(old.Network, conf.Network) {
+		changes = append(changes, "changed network")
+	}
+	if !reflect.DeepEqual(old.History, conf.History) {
+		changes = append(changes, "changed history")
+	}
+	return
+}
+
+// Describe the differences between two sets of named configuration
+// directives, in order of name.
+func diffNamed(kind string, old, new map[string]interface{}) (changes []string) {
+	names := []string{}
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		before, inOld := old[name]
+		after, inNew := new[name]
+		switch {
+		case !inOld:
+			changes = append(changes, "added "+kind+" "+name)
+		case !inNew:
+			changes = append(changes, "removed "+kind+" "+name)
+		case !reflect.DeepEqual(before, after):
+			changes = append(changes, "changed "+kind+" "+name)
+		}
+	}
+	return
+}
+
+// Get the ports a configuration listens on, and whether each is an ssl port.
+func portSet(conf *Configuration) map[int]bool {
+	ports := make(map[int]bool)
+	for _, p := range conf.Ports {
+		list, err := p.GetPortList()
+		if err != nil {
+			log.Warn.Print(err)
+		}
+		for _, port := range list {
+			ports[port] = p.AreSSL()
+		}
+	}
+	return ports
+}
+
+// Start and stop listening on ports as they have changed between two
+// configurations, returning a description of what was done.
+func (s *IRCd) updatePorts(old, conf *Configuration) (changes []string) {
+	before, after := portSet(old), portSet(conf)
+
+	added, removed, reopened := []int{}, []int{}, []int{}
+	for port, ssl := range after {
+		if wasSSL, ok := before[port]; !ok {
+			added = append(added, port)
+		} else if ssl != wasSSL {
+			reopened = append(reopened, port)
+		}
+	}
+	for port := range before {
+		if _, ok := after[port]; !ok {
+			removed = append(removed, port)
+		}
+	}
+	sort.Ints(added)
+	sort.Ints(removed)
+	sort.Ints(reopened)
+
+	for _, port := range added {
+		changes = append(changes, s.openPort(port, after[port], "listening on"))
+	}
+	for _, port := range reopened {
+		// The ssl setting has changed
+		if s.listener != nil {
+			s.listener.ClosePort(port)
+		}
+		changes = append(changes, s.openPort(port, after[port], "reopened"))
+	}
+	for _, port := range removed {
+		if s.listener != nil {
+			s.listener.ClosePort(port)
+		}
+		changes = append(changes, "closed port "+strconv.Itoa(port))
+	}
+	return
+}
+
+// Listen on a port, describing what was done (or why it failed).
+func (s *IRCd) openPort(port int, ssl bool, done string) string {
+	if s.listener != nil {
+		if err := listen(s.listener, port, ssl); err != nil {
+			return "could not listen on port " + strconv.Itoa(port) + ": " + err.Error()
+		}
+	}
+	return done + " port " + strconv.Itoa(port)
+}
@@ -0,0 +1,136 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/history"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+func TestRehash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rehash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ircd.conf")
+
+	uidPrefix, historyPrefix := user.UserIDPrefix, history.IDPrefix
+	defer func() {
+		SetConfig(nil)
+		ConfigFile = ""
+		user.UserIDPrefix, history.IDPrefix = uidPrefix, historyPrefix
+	}()
+	defer Capability(saslcap, saslValue)
+
+	// The default configuration without the ssl ports, which need a certificate
+	base := strings.NewReplacer(
+		`<ports ssl="true">6696-6699,9999</ports>`, "",
+		"<cert>ircd.crt</cert>", "",
+		"<key>ircd.key</key>", "",
+	).Replace(DefaultXML)
+
+	if err := ioutil.WriteFile(file, []byte(base), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfigFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	badBans := filepath.Join(dir, "bad.bans")
+	if err := ioutil.WriteFile(badBans, []byte("not a ban\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ircd := &IRCd{
+		ToClient: make(chan *parser.Message, 20),
+		ToServer: make(chan *parser.Message, 20),
+	}
+
+	tests := []struct {
+		Desc    string
+		From    string
+		To      string
+		Changes []string
+		Err     bool
+	}{
+		{
+			Desc: "unchanged",
+		},
+		{
+			Desc: "new ports",
+			From: "<ports>6666-6669</ports>",
+			To:   "<ports>6667</ports><ports>7000</ports>",
+			Changes: []string{
+				"listening on port 7000",
+				"closed port 6666",
+				"closed port 6668",
+				"closed port 6669",
+			},
+		},
+		{
+			Desc:    "new operator",
+			From:    `<operator name="god"`,
+			To:      `<operator name="root" class="helper"><password>x</password></operator><operator name="god"`,
+			Changes: []string{"added operator root"},
+		},
+		{
+			Desc: "invalid",
+			From: "<admin>",
+			To:   "<history><length>-1</length></history><admin>",
+			Err:  true,
+		},
+		{
+			Desc: "unreadable bans file",
+			From: "<admin>",
+			To:   "<history><length>7</length></history><bans>" + badBans + "</bans><admin>",
+			Err:  true,
+		},
+		{
+			Desc: "new SID",
+			From: `sid="8LI"`,
+			To:   `sid="9LI"`,
+			Err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		old := Config()
+		conf := strings.Replace(base, test.From, test.To, 1)
+		if err := ioutil.WriteFile(file, []byte(conf), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		changes, err := ircd.rehash("test")
+		if got, want := err != nil, test.Err; got != want {
+			t.Errorf("%s: rehash error = %v, want error %v", test.Desc, err, want)
+			continue
+		}
+		if test.Err {
+			if Config() != old {
+				t.Errorf("%s: configuration replaced after a failed rehash", test.Desc)
+			}
+			if history.Length == 7 {
+				t.Errorf("%s: history length changed after a failed rehash", test.Desc)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(changes, test.Changes) {
+			t.Errorf("%s: changes = %q, want %q", test.Desc, changes, test.Changes)
+		}
+
+		// Put the default configuration back for the next test
+		if err := ioutil.WriteFile(file, []byte(base), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ircd.rehash("test"); err != nil {
+			t.Fatalf("%s: restoring the default: %s", test.Desc, err)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package core
+
+import (
+	"encoding/base64"
+	"strings"
+	"sync"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/account"
+	"github.com/kylelemons/ircd-blight/old/ircd/log"
+	"github.com/kylelemons/ircd-blight/old/ircd/mode"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/server"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+var (
+	saslhooks = []*Hook{
+		Register(parser.CMD_AUTHENTICATE, Registration|User, NArgs(1), Authenticate),
+	}
+	saslcap = Capability("sasl", saslValue)
+)
+
+var (
+	// The supported SASL mechanisms.
+	SASLMechanisms = []string{"PLAIN", "EXTERNAL"}
+
+	// The largest SASL response (in base64) that will be accepted.
+	SASLMaxLength = 8192
+)
+
+// AUTHENTICATE responses are sent in chunks of this many bytes; a shorter
+// chunk (or "+") ends the response.  The chunks are joined by
+// joinSASLChunks before Authenticate sees them.
+const saslChunk = 400
+
+// Get the SASL mechanisms on offer.  EXTERNAL needs a client certificate,
+// so it is only offered when there are ssl ports.
+func saslMechanisms() []string {
+	tls := hasTLSPorts(Config())
+	mechs := make([]string, 0, len(SASLMechanisms))
+	for _, mech := range SASLMechanisms {
+		if mech == "EXTERNAL" && !tls {
+			continue
+		}
+		mechs = append(mechs, mech)
+	}
+	return mechs
+}
+
+// Get the value of the sasl capability: the mechanisms on offer.
+func saslValue() string {
+	return strings.Join(saslMechanisms(), ",")
+}
+
+// Offer the sasl capability only while there is an accounts file to log in
+// to, telling clients with cap-notify when that changes.
+func updateSASLCap(conf *Configuration, ircd *IRCd) {
+	switch available := len(conf.Accounts) > 0; {
+	case available && !capAvailable(saslcap):
+		capNew(saslcap, saslValue, ircd)
+	case !available && capAvailable(saslcap):
+		capDel(saslcap, ircd)
+	}
+}
+
+// An in-progress SASL exchange.
+type saslSession struct {
+	mechanism string
+	response  string // the base64 response
+}
+
+var (
+	saslMutex    = new(sync.Mutex)
+	saslSessions = make(map[string]*saslSession)
+)
+
+// Join the chunks of a long AUTHENTICATE response as they arrive, keeping
+// the unfinished response in pending.  This must be called for each of a
+// client's AUTHENTICATE messages in the order they were received.  Once the
+// response is complete (or for anything else), the message to dispatch is
+// returned; otherwise nil is.  A response which grows too long is passed on
+// so that Authenticate can reject it.
+func joinSASLChunks(pending *string, msg *parser.Message) *parser.Message {
+	arg := msg.Args[0]
+	switch {
+	case arg == "*":
+		*pending = ""
+		return msg
+	case len(arg) == saslChunk && len(*pending) <= SASLMaxLength:
+		*pending += arg
+		return nil
+	case len(*pending) == 0:
+		return msg
+	}
+	if arg == "+" {
+		arg = ""
+	}
+	joined := msg.Dup()
+	joined.Args[0] = *pending + arg
+	*pending = ""
+	return joined
+}
+
+// AUTHENTICATE <mechanism>
+// AUTHENTICATE <base64 response>|+|*
+//
+// Responses longer than one chunk have already been joined (see
+// joinSASLChunks).
+func Authenticate(hook string, msg *parser.Message, ircd *IRCd) {
+	uid, arg := msg.SenderID, msg.Args[0]
+	u, ok := user.Lookup(uid)
+	if !ok {
+		log.Warn.Printf("AUTHENTICATE from unknown user %s", uid)
+		return
+	}
+
+	switch {
+	case !u.HasCap(saslcap):
+		ircd.ToClient <- parser.NewNumeric(parser.ERR_SASLFAIL).Message(uid)
+		return
+	case len(u.Account()) > 0 && arg != "*":
+		ircd.ToClient <- parser.NewNumeric(parser.ERR_SASLALREADY).Message(uid)
+		return
+	}
+
+	// The credentials are checked without holding the session lock, since
+	// hashing a password can take a while
+	session, replies := saslStep(uid, arg)
+	for _, reply := range replies {
+		ircd.ToClient <- reply
+	}
+	if session == nil {
+		return
+	}
+
+	response, err := base64.StdEncoding.DecodeString(session.response)
+	if err != nil {
+		ircd.ToClient <- parser.NewNumeric(parser.ERR_SASLFAIL).Message(uid)
+		return
+	}
+
+	var name string
+	switch session.mechanism {
+	case "PLAIN":
+		name, ok = saslPlain(response)
+	case "EXTERNAL":
+		name, ok = account.CheckCertFP(u.CertFP(), string(response))
+	}
+	if !ok {
+		log.Info.Printf("[%s] SASL %s authentication failed", uid, session.mechanism)
+		ircd.ToClient <- parser.NewNumeric(parser.ERR_SASLFAIL).Message(uid)
+		return
+	}
+	saslLogin(u, name, ircd)
+}
+
+// Add the next AUTHENTICATE argument to uid's session.  If it is the
+// response, the session is ended and returned; otherwise the replies to send
+// are returned.
+func saslStep(uid, arg string) (done *saslSession, replies []*parser.Message) {
+	saslMutex.Lock()
+	defer saslMutex.Unlock()
+
+	session := saslSessions[uid]
+	if arg == "*" {
+		delete(saslSessions, uid)
+		return nil, []*parser.Message{parser.NewNumeric(parser.ERR_SASLABORTED).Message(uid)}
+	}
+
+	// The first message chooses the mechanism
+	if session == nil {
+		mechanism, mechs := strings.ToUpper(arg), saslMechanisms()
+		for _, supported := range mechs {
+			if mechanism == supported {
+				saslSessions[uid] = &saslSession{mechanism: mechanism}
+				return nil, []*parser.Message{{
+					Command: parser.CMD_AUTHENTICATE,
+					Args:    []string{"+"},
+					DestIDs: []string{uid},
+				}}
+			}
+		}
+		return nil, []*parser.Message{
+			parser.NewNumeric(parser.RPL_SASLMECHS, strings.Join(mechs, ",")).Message(uid),
+			parser.NewNumeric(parser.ERR_SASLFAIL).Message(uid),
+		}
+	}
+
+	delete(saslSessions, uid)
+	if len(arg) > SASLMaxLength {
+		return nil, []*parser.Message{parser.NewNumeric(parser.ERR_SASLTOOLONG).Message(uid)}
+	}
+	if arg != "+" {
+		session.response = arg
+	}
+	return session, nil
+}
+
+// Check a PLAIN response: <authzid> NUL <authcid> NUL <password>.  The
+// authorization identity must be empty or the same as the authentication
+// identity.
+func saslPlain(response []byte) (name string, ok bool) {
+	fields := strings.Split(string(response), "\x00")
+	if len(fields) != 3 {
+		return "", false
+	}
+	authzid, authcid, password := fields[0], fields[1], fields[2]
+	if len(authzid) > 0 && parser.ToLower(authzid) != parser.ToLower(authcid) {
+		return "", false
+	}
+	return account.CheckPassword(authcid, password)
+}
+
+// Log the user in to the account and set +r.
+func saslLogin(u *user.User, name string, ircd *IRCd) {
+	uid := u.ID()
+	log.Info.Printf("[%s] Logged in as %s", uid, name)
+
+	u.SetAccount(name)
+	changes, _ := mode.UserModes.ParseModeChange([]string{"+r"})
+	applied := u.ApplyModes(changes)
+
+	loggedin := parser.NewNumeric(parser.RPL_LOGGEDIN, u.Hostmask(), name).Message(uid)
+	loggedin.Args[len(loggedin.Args)-1] = "You are now logged in as " + name
+	ircd.ToClient <- loggedin
+	ircd.ToClient <- parser.NewNumeric(parser.RPL_SASLSUCCESS).Message(uid)
+
+	// Users who are still registering are announced with their account
+	if u.Type() != user.RegisteredAsUser {
+		return
+	}
+
+	if len(applied) > 0 {
+		ircd.ToClient <- &parser.Message{
+			Prefix:  "*",
+			Command: parser.CMD_MODE,
+			Args: []string{
+				"*",
+				mode.UserModes.ModeString(applied),
+			},
+			DestIDs: []string{uid},
+		}
+	}
+	notifyAccount(u, ircd)
+	for sid := range server.Iter() {
+		ircd.ToServer <- &parser.Message{
+			Prefix:  uid,
+			Command: parser.CMD_ENCAP,
+			Args: []string{
+				"*",
+				"LOGIN",
+				name,
+			},
+			DestIDs: []string{sid},
+		}
+	}
+}
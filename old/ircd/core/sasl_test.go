@@ -0,0 +1,82 @@
+package core
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/account"
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+func TestAuthenticatePlain(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	// A long account name puts the password past the first chunk; the
+	// response is exactly two chunks long, so it ends with "+"
+	long := strings.Repeat("a", 600-len("\x00\x00hunter2"))
+	accounts := "alice " + string(hash) + "\n" + long + " " + string(hash)
+	if err := account.LoadFrom(strings.NewReader(accounts)); err != nil {
+		t.Fatalf("LoadFrom: %s", err)
+	}
+
+	ircd := &IRCd{ToClient: make(chan *parser.Message, 10)}
+	u := user.Get("000AAASSL")
+	defer user.Delete("000AAASSL")
+	u.SetCap(saslcap, true)
+
+	// Messages go through joinSASLChunks as they would in manageClients
+	var pending string
+	send := func(arg string) {
+		msg := joinSASLChunks(&pending, &parser.Message{
+			SenderID: "000AAASSL",
+			Command:  parser.CMD_AUTHENTICATE,
+			Args:     []string{arg},
+		})
+		if msg != nil {
+			Authenticate(parser.CMD_AUTHENTICATE, msg, ircd)
+		}
+	}
+	sendChunked := func(response string) {
+		for len(response) >= saslChunk {
+			send(response[:saslChunk])
+			response = response[saslChunk:]
+		}
+		if got, want := len(ircd.ToClient), 0; got != want {
+			t.Errorf("replies before the last chunk: %d, want %d", got, want)
+		}
+		if response == "" {
+			response = "+"
+		}
+		send(response)
+	}
+	expect := func(desc, command string) {
+		if got := (<-ircd.ToClient).Command; got != command {
+			t.Errorf("%s: got %s, want %s", desc, got, command)
+		}
+	}
+
+	// A long response is split into 400-byte chunks
+	send("PLAIN")
+	expect("AUTHENTICATE PLAIN", parser.CMD_AUTHENTICATE)
+	sendChunked(base64.StdEncoding.EncodeToString([]byte("\x00alice\x00" + strings.Repeat("x", 600))))
+	expect("wrong password", parser.ERR_SASLFAIL)
+
+	send("PLAIN")
+	expect("AUTHENTICATE PLAIN", parser.CMD_AUTHENTICATE)
+	sendChunked(base64.StdEncoding.EncodeToString([]byte("\x00" + long + "\x00hunter2")))
+	expect("right password", parser.RPL_LOGGEDIN)
+	expect("right password", parser.RPL_SASLSUCCESS)
+
+	if got, want := u.Account(), long; got != want {
+		t.Errorf("account = %q, want %q", got, want)
+	}
+	if !u.HasMode('r') {
+		t.Errorf("+r not set after login")
+	}
+
+	send("PLAIN")
+	expect("AUTHENTICATE after login", parser.ERR_SASLALREADY)
+}
@@ -1,38 +1,84 @@
 package core
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/kylelemons/ircd-blight/old/ircd/account"
+	"github.com/kylelemons/ircd-blight/old/ircd/ban"
+	"github.com/kylelemons/ircd-blight/old/ircd/channel"
 	"github.com/kylelemons/ircd-blight/old/ircd/conn"
+	"github.com/kylelemons/ircd-blight/old/ircd/history"
 	"github.com/kylelemons/ircd-blight/old/ircd/log"
 	"github.com/kylelemons/ircd-blight/old/ircd/parser"
 	"github.com/kylelemons/ircd-blight/old/ircd/server"
 	"github.com/kylelemons/ircd-blight/old/ircd/user"
 )
 
+// CheckConfig checks the loaded configuration and, if it is valid, applies
+// the settings it holds for other packages and loads the files it names.
 func CheckConfig() (okay bool) {
-	okay = true
-
-	if Config == nil {
+	conf := Config()
+	if conf == nil {
 		log.Error.Printf("No configuration loaded")
 		return false
 	}
+	if !checkConfig(conf) {
+		return false
+	}
+	files, err := readConfigFiles(conf)
+	if err != nil {
+		log.Error.Print(err)
+		return false
+	}
+
+	// The SID cannot change once the server is running (see reloadConfig)
+	user.UserIDPrefix = conf.SID
+	history.IDPrefix = conf.SID
+
+	applyConfig(conf, files)
+	return true
+}
+
+// Check a configuration, logging any problems.  Nothing is changed, so the
+// configuration does not have to be the current one.
+func checkConfig(conf *Configuration) (okay bool) {
+	okay = true
 
 	// Check hostname: require at least one .
-	if !parser.ValidServerName(Config.Name) {
-		log.Error.Printf("invalid server name %q: must match /\\w+(.\\w+)+/", Config.Name)
+	if !parser.ValidServerName(conf.Name) {
+		log.Error.Printf("invalid server name %q: must match /\\w+(.\\w+)+/", conf.Name)
 		okay = false
 	}
 
 	// Check prefix; [num][alphanum][alphanum]
-	if !parser.ValidServerPrefix(Config.SID) {
-		log.Error.Printf("invalid server prefix %q: must match /[0-9][0-9A-Z]{2}/", Config.SID)
+	if !parser.ValidServerPrefix(conf.SID) {
+		log.Error.Printf("invalid server prefix %q: must match /[0-9][0-9A-Z]{2}/", conf.SID)
+		okay = false
+	}
+
+	// Check the message history
+	if h := conf.History; h != nil && (h.Length < 0 || h.Limit < 0) {
+		log.Error.Printf("history length and limit must not be negative")
+		okay = false
+	}
+
+	// Check TLS
+	if hasTLSPorts(conf) && (conf.TLS == nil || len(conf.TLS.Cert) == 0 || len(conf.TLS.Key) == 0) {
+		log.Error.Printf("ssl ports need a TLS certificate and key")
 		okay = false
 	}
-	user.UserIDPrefix = Config.SID
 
 	// Check opers
-	if len(Config.Operator) == 0 {
+	if len(conf.Operator) == 0 {
 		log.Error.Printf("no operators defined: at least one required")
 		okay = false
 	}
@@ -40,6 +86,52 @@ func CheckConfig() (okay bool) {
 	return
 }
 
+// The files named by a configuration, read but not yet in use.
+type configFiles struct {
+	accounts account.Store    // nil if there is no accounts file
+	bans     ban.Set          // nil if there is no bans file
+	cert     *tls.Certificate // nil if there are no ssl ports
+}
+
+// Read the files named by a configuration without using them.
+func readConfigFiles(conf *Configuration) (files configFiles, err error) {
+	if len(conf.Accounts) > 0 {
+		if files.accounts, err = account.Read(conf.Accounts); err != nil {
+			return files, fmt.Errorf("could not load accounts: %s", err)
+		}
+	}
+	if len(conf.Bans) > 0 {
+		if files.bans, err = ban.Read(conf.Bans); err != nil {
+			return files, fmt.Errorf("could not load bans: %s", err)
+		}
+	}
+	if hasTLSPorts(conf) {
+		cert, err := tls.LoadX509KeyPair(conf.TLS.Cert, conf.TLS.Key)
+		if err != nil {
+			return files, fmt.Errorf("could not load TLS certificate: %s", err)
+		}
+		files.cert = &cert
+	}
+	return files, nil
+}
+
+// Put a checked configuration and the files read for it in use.  None of
+// this can fail, so a configuration is either applied completely or not at
+// all.
+func applyConfig(conf *Configuration, files configFiles) {
+	if h := conf.History; h != nil && h.Length > 0 {
+		history.SetLength(h.Length)
+	}
+	account.Use(files.accounts)
+	if files.bans != nil {
+		ban.Use(files.bans)
+	}
+	if files.cert != nil {
+		certificate.Store(files.cert)
+	}
+	SetConfig(conf)
+}
+
 func (s *IRCd) manageServers() {
 	defer s.running.Done()
 
@@ -136,7 +228,11 @@ func (s *IRCd) manageServers() {
 func (s *IRCd) manageClients() {
 	defer s.running.Done()
 
-	uid2conn := make(map[string]*conn.Conn)
+	uid2conn := localConns
+	clients := make(map[string]*clientState)
+
+	pinger := time.NewTicker(PingCheck)
+	defer pinger.Stop()
 
 	var open bool = true
 	var msg *parser.Message
@@ -157,13 +253,34 @@ func (s *IRCd) manageClients() {
 				if conn != nil {
 					log.Debug.Printf("[%s] ** Connection terminated remotely", uid)
 					user.Delete(uid)
-					delete(uid2conn, uid)
+					setConn(uid, nil)
+					delete(clients, uid)
 					conn.UnsubscribeClose(s.clientClosing)
 					conn.Close()
 				}
 				continue
 			}
 
+			if state, ok := clients[uid]; ok {
+				if state.dropped {
+					continue
+				}
+				state.heard, state.pinged = time.Now(), false
+				if class, ok := findClass(u.Class()); ok && !state.flood.take(class, state.heard) {
+					log.Info.Printf("[%s] ** Excess flood", uid)
+					state.dropped = true
+					go dropClient(uid, "Excess Flood", s)
+					continue
+				}
+				// Hooks run in no particular order, so AUTHENTICATE chunks
+				// are joined here, in the order they arrived
+				if msg.Command == parser.CMD_AUTHENTICATE && len(msg.Args) == 1 {
+					if msg = joinSASLChunks(&state.sasl, msg); msg == nil {
+						continue
+					}
+				}
+			}
+
 			log.Debug.Printf("[%s] >> %s", uid, msg)
 			DispatchClient(msg, s)
 
@@ -185,6 +302,9 @@ func (s *IRCd) manageClients() {
 			// Count the number of messages sent
 			sentcount := 0
 
+			// Every recipient with server-time sees the same time
+			stamp := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+
 			// For simplicity, * in the prefix or as the first argument
 			// is replaced by the nick of the user the message is sent to
 			setnick := len(msg.Args) > 0 && msg.Args[0] == "*"
@@ -197,14 +317,14 @@ func (s *IRCd) manageClients() {
 				msg.Prefix = ""
 			} else if len(msg.Prefix) == 0 {
 				// Make sure a prefix is specified (use the server name)
-				msg.Prefix = Config.Name
+				msg.Prefix = Config().Name
 			}
 
 			local := make([]string, 0, len(msg.DestIDs))
 			remote := make([]string, 0, len(msg.DestIDs))
 
 			for _, id := range msg.DestIDs {
-				if id[:3] != Config.SID {
+				if id[:3] != Config().SID {
 					remote = append(remote, id)
 					continue
 				}
@@ -229,11 +349,16 @@ func (s *IRCd) manageClients() {
 
 			// Examine all arguments for UIDs and replace them
 			if isuid(msg.Prefix) {
-				nick, user, _, _, ok := user.GetInfo(msg.Prefix)
+				u, ok := user.Lookup(msg.Prefix)
 				if !ok {
 					log.Warn.Printf("Nonexistent ID %s as prefix", msg.Prefix)
 				} else {
-					msg.Prefix = nick + "!" + user + "@host" // TODO(kevlar): hostname
+					msg.Prefix = u.Hostmask()
+				}
+			} else if parser.ValidServerPrefix(msg.Prefix) {
+				// Replies relayed from other servers come from their SID
+				if _, name, _, _, ok := server.GetInfo(msg.Prefix); ok {
+					msg.Prefix = name
 				}
 			}
 			for i := range msg.Args {
@@ -262,13 +387,15 @@ func (s *IRCd) manageClients() {
 						msg.Prefix = nick
 					}
 				}
-				conn.WriteMessage(msg)
-				log.Debug.Printf("[%s] << %s\n", id, msg)
+				out := clientMessage(msg, id, stamp)
+				conn.WriteMessage(out)
+				log.Debug.Printf("[%s] << %s\n", id, out)
 				sentcount++
 				if closeafter {
 					log.Debug.Printf("[%s] ** Connection terminated", id)
 					user.Delete(id)
-					delete(uid2conn, id)
+					setConn(id, nil)
+					delete(clients, id)
 					conn.UnsubscribeClose(s.clientClosing)
 					conn.Close()
 				}
@@ -280,15 +407,52 @@ func (s *IRCd) manageClients() {
 		// Connecting clients
 		case conn := <-s.newClient:
 			id := conn.ID()
-			uid2conn[id] = conn
-			user.Get(id)
+			setConn(id, conn)
+			clients[id] = &clientState{heard: time.Now()}
+			user.Get(id).SetCertFP(conn.CertFP())
+			if class, ok := userClass(id); ok && class.SendQ > 0 {
+				conn.SetSendQ(class.SendQ)
+			}
 			conn.Subscribe(s.fromClient)
 			conn.SubscribeClose(s.clientClosing)
 		// Disconnecting clients
 		case closeid := <-s.clientClosing:
 			log.Debug.Printf("[%s] ** Connection closed", closeid)
+			// A client dropped for its SendQ still has to quit (like a
+			// ping timeout), which ends with the ERROR that forgets it
+			if state, ok := clients[closeid]; ok && !state.dropped {
+				if c, ok := uid2conn[closeid]; ok && c.Err() == conn.ErrSendQ {
+					state.dropped = true
+					go dropClient(closeid, "SendQ exceeded", s)
+					continue
+				}
+			}
 			user.Delete(closeid)
-			delete(uid2conn, closeid)
+			setConn(closeid, nil)
+			delete(clients, closeid)
+		// PING clients which have gone quiet, and drop those which don't reply
+		case now := <-pinger.C:
+			for id, state := range clients {
+				class, ok := userClass(id)
+				if !ok || class.PingFreq <= 0 || state.dropped {
+					continue
+				}
+				freq := time.Duration(class.PingFreq) * time.Second
+				switch idle := now.Sub(state.heard); {
+				case idle >= 2*freq:
+					log.Info.Printf("[%s] ** Ping timeout", id)
+					state.dropped = true
+					go dropClient(id, "Ping timeout: "+strconv.Itoa(int(idle.Seconds()))+" seconds", s)
+				case idle >= freq && !state.pinged:
+					state.pinged = true
+					uid2conn[id].WriteMessage(&parser.Message{
+						Command: parser.CMD_PING,
+						Args: []string{
+							Config().Name,
+						},
+					})
+				}
+			}
 		}
 	}
 }
@@ -307,7 +471,7 @@ func (s *IRCd) manageIncoming() {
 		conn.Subscribe(inc)
 		conn.SubscribeClose(stop)
 
-		user, nick := false, false
+		user, nick, capneg := false, false, false
 		pass, server, capab := false, false, false
 		sid := ""
 
@@ -328,6 +492,8 @@ func (s *IRCd) manageIncoming() {
 					user = true
 				case parser.CMD_NICK:
 					nick = true
+				case parser.CMD_CAP:
+					capneg = true
 				case parser.CMD_CAPAB:
 					capab = true
 				case parser.CMD_SERVER:
@@ -337,9 +503,17 @@ func (s *IRCd) manageIncoming() {
 				return
 			}
 
-			if !quit && nick && user {
+			// Clients negotiating capabilities need replies before
+			// they will finish registering
+			if !quit && capneg {
+				beginNegotiation(conn.ID())
+			}
+			if !quit && (nick && user || capneg) {
 				conn.Unsubscribe(inc)
 				conn.UnsubscribeClose(stop)
+				if !s.admit(conn) {
+					return
+				}
 				s.newClient <- conn
 				for _, msg := range queued {
 					s.fromClient <- msg
@@ -369,10 +543,43 @@ func (s *IRCd) manageIncoming() {
 	}
 }
 
+// Set up the user for a new client connection and put them in a connection
+// class.  If they are refused, they are disconnected and false is returned.
+func (s *IRCd) admit(c *conn.Conn) bool {
+	// TODO(kevlar): Resolve hostnames
+	ip, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+	if strings.HasPrefix(ip, ":") {
+		// Don't let an IPv6 address look like a trailing argument
+		ip = "0" + ip
+	}
+	u := user.Get(c.ID())
+	u.SetHost(ip, ip)
+
+	reason, ok := admitClient(u)
+	if !ok {
+		log.Info.Printf("[%s] ** Refused connection from %s: %s", c.ID(), ip, reason)
+		c.WriteMessage(&parser.Message{
+			Command: parser.CMD_ERROR,
+			Args: []string{
+				"Closing Link (" + reason + ")",
+			},
+		})
+		user.Delete(c.ID())
+		c.Close()
+	}
+	return ok
+}
+
 var (
 	// TODO(kevlar): Configurable?
 	SendQ = 100
 	RecvQ = 100
+
+	// How often clients are checked to see if they need to be sent a PING
+	PingCheck = 5 * time.Second
+
+	// When the server was started (reported in RPL_CREATED)
+	Started = time.Now()
 )
 
 func (s *IRCd) Quit() {
@@ -390,13 +597,22 @@ func Start() {
 
 	listener := conn.NewListener()
 	defer listener.Close()
-	for _, ports := range Config.Ports {
+	listener.Reject = func(ip net.IP) (string, bool) {
+		if b, ok := ban.CheckIP(ip); ok {
+			log.Info.Printf("Rejected connection from %s: %s %s", ip, b.Type(), b.Mask)
+			return banMessage(b), true
+		}
+		return "", false
+	}
+	for _, ports := range Config().Ports {
 		portlist, err := ports.GetPortList()
 		if err != nil {
 			log.Warn.Print(err)
 		}
 		for _, port := range portlist {
-			listener.AddPort(port)
+			if err := listen(listener, port, ports.AreSSL()); err != nil {
+				log.Warn.Printf("Could not listen on port %d: %s", port, err)
+			}
 		}
 	}
 
@@ -412,9 +628,33 @@ func Start() {
 		fromClient: make(chan *parser.Message, SendQ),
 		fromServer: make(chan *parser.Message, SendQ),
 
+		listener: listener,
+
 		running: new(sync.WaitGroup),
 	}
 
+	updateSASLCap(Config(), s)
+
+	// Forget the history of destroyed channels and old conversations
+	channel.OnDestroy = func(name string) {
+		history.Delete(history.ChannelKey(name))
+	}
+	go func() {
+		for range time.Tick(HistoryCheck) {
+			expireHistory()
+		}
+	}()
+
+	// Reload the configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Info.Printf("Received SIGHUP")
+			s.rehash("SIGHUP")
+		}
+	}()
+
 	s.running.Add(1)
 	go s.manageClients()
 
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+	"github.com/kylelemons/ircd-blight/old/ircd/user"
+)
+
+// Server notice masks: each letter in an oper's snomask selects a kind of
+// server notice for them to receive while they are +s.
+const (
+	SnoOper = 'o' // OPER attempts, successful or not
+	SnoKill = 'k' // KILLs of local users
+	SnoBan  = 'x' // K-, D- and X-lines set, removed and enforced
+	SnoConf = 'r' // configuration reloads (REHASH)
+)
+
+var (
+	// The snomask given to users when they become opers.
+	DefaultSnomask = "korx"
+)
+
+// Send a server notice to the local opers whose snomask includes sno.
+func serverNotice(sno rune, text string, ircd *IRCd) {
+	dests := []string{}
+	for uid := range user.Iter() {
+		if uid[:3] != Config().SID {
+			continue
+		}
+		if u, ok := user.Lookup(uid); ok && u.HasMode('s') && strings.ContainsRune(u.Snomask(), sno) {
+			dests = append(dests, uid)
+		}
+	}
+	if len(dests) == 0 {
+		return
+	}
+
+	ircd.ToClient <- &parser.Message{
+		Command: parser.CMD_NOTICE,
+		Args: []string{
+			"*",
+			"*** Notice -- " + text,
+		},
+		DestIDs: dests,
+	}
+}
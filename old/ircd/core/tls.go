@@ -0,0 +1,47 @@
+package core
+
+import (
+	"crypto/tls"
+	"errors"
+	"sync/atomic"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/conn"
+)
+
+// The certificate served on TLS ports, which REHASH replaces (so that a
+// renewed certificate is used without reopening the ports).
+var certificate atomic.Value
+
+// The TLS configuration for ssl ports.  Client certificates are requested
+// but not verified: they are only used for their fingerprints (see SASL
+// EXTERNAL).
+var tlsConfig = &tls.Config{
+	GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if cert, ok := certificate.Load().(*tls.Certificate); ok && cert != nil {
+			return cert, nil
+		}
+		return nil, errors.New("no TLS certificate loaded")
+	},
+	ClientAuth: tls.RequestClientCert,
+}
+
+// Get whether a configuration has any ssl ports.
+func hasTLSPorts(conf *Configuration) bool {
+	if conf == nil {
+		return false
+	}
+	for _, p := range conf.Ports {
+		if p.AreSSL() {
+			return true
+		}
+	}
+	return false
+}
+
+// Listen on a port, with TLS if it is an ssl port.
+func listen(l *conn.Listener, port int, ssl bool) error {
+	if ssl {
+		return l.AddTLSPort(port, tlsConfig)
+	}
+	return l.AddPort(port)
+}
@@ -0,0 +1,351 @@
+// Package history keeps the most recent messages sent to each channel and
+// between each pair of users so that clients can ask for them again (see
+// the IRCv3 CHATHISTORY extension).
+//
+// Each channel or private conversation has a Buffer, identified by a key
+// from ChannelKey or ConversationKey, which holds at most Length messages.
+// The oldest messages are discarded as new ones arrive.
+package history
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+)
+
+// The format of message timestamps (the IRCv3 server-time format).
+const TimeFormat = "2006-01-02T15:04:05.000Z"
+
+var (
+	// The number of messages kept for each channel or conversation.  Set
+	// this before any messages are added, or use SetLength.
+	Length = 100
+
+	// The server prefix for all message IDs.  Set this before calling
+	// NewID.
+	IDPrefix = "000"
+)
+
+var (
+	historyMutex = new(sync.RWMutex)
+
+	// buffers[key] = buffer
+	buffers = make(map[string]*Buffer)
+
+	// Message IDs are unique to this run of the server
+	idEpoch = strconv.FormatInt(time.Now().Unix(), 36)
+	lastID  uint64
+)
+
+// An Entry is a message stored in the history.
+type Entry struct {
+	ID      string            // the msgid
+	Time    time.Time         // when the message was sent (to the millisecond)
+	Prefix  string            // the sender's hostmask (or server ID)
+	Command string            // PRIVMSG or NOTICE
+	Target  string            // the channel or nick the message was sent to
+	Text    string            // the message text
+	Tags    map[string]string // the client-only tags sent with the message
+}
+
+// Get a new unique message ID.
+func NewID() string {
+	n := atomic.AddUint64(&lastID, 1)
+	return IDPrefix + idEpoch + "-" + strconv.FormatUint(n, 36)
+}
+
+// Get the history key for a channel.
+func ChannelKey(name string) string {
+	return parser.ToLower(name)
+}
+
+// Get the history key for the private conversation between two parties.
+// A party is whatever identifies a user for as long as their conversations
+// should be kept (for example their UID), and must not contain spaces; it is
+// never a nick, which someone else could take later.  The key is the same
+// whichever way round they are given.
+func ConversationKey(party1, party2 string) string {
+	if party1 > party2 {
+		party1, party2 = party2, party1
+	}
+	return party1 + " " + party2
+}
+
+// Get the Buffer for the given key.  If it does not exist and create is
+// true, it is created; otherwise nil is returned.
+func Get(key string, create bool) *Buffer {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	if b, ok := buffers[key]; ok {
+		return b
+	} else if !create {
+		return nil
+	}
+
+	b := &Buffer{
+		mutex:   new(sync.RWMutex),
+		entries: make([]Entry, 0, Length),
+		size:    Length,
+	}
+	buffers[key] = b
+	return b
+}
+
+// Set the number of messages kept for channels and conversations from now
+// on.  Existing buffers keep their size.
+func SetLength(n int) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	Length = n
+}
+
+// Delete the Buffer with the given key, e.g. when its channel is destroyed.
+func Delete(key string) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	delete(buffers, key)
+}
+
+// Get the Buffers of the private conversations that party has taken part
+// in, indexed by the other party.
+func Conversations(party string) map[string]*Buffer {
+	historyMutex.RLock()
+	defer historyMutex.RUnlock()
+
+	convs := make(map[string]*Buffer)
+	for key, b := range buffers {
+		parties := strings.Split(key, " ")
+		switch {
+		case len(parties) != 2:
+			continue
+		case parties[0] == party:
+			convs[parties[1]] = b
+		case parties[1] == party:
+			convs[parties[0]] = b
+		}
+	}
+	return convs
+}
+
+// Delete the Buffers of the private conversations which have had no messages
+// since the given time, or which have a party for whom gone returns true.
+// The number deleted is returned.
+func ExpireConversations(since time.Time, gone func(party string) bool) (n int) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	for key, b := range buffers {
+		parties := strings.Split(key, " ")
+		if len(parties) != 2 {
+			continue
+		}
+		if b.Latest().Before(since) || gone(parties[0]) || gone(parties[1]) {
+			delete(buffers, key)
+			n++
+		}
+	}
+	return
+}
+
+// A Buffer holds the most recent messages for a channel or conversation.
+type Buffer struct {
+	mutex   *sync.RWMutex
+	entries []Entry // a ring buffer once it reaches size
+	next    int     // the next entry to overwrite when full
+	size    int
+}
+
+// Add a message to the buffer, discarding the oldest if it is full.  The
+// time is truncated to the millisecond, since that is all clients see.
+func (b *Buffer) Add(e Entry) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	e.Time = e.Time.Truncate(time.Millisecond)
+	if len(b.entries) < b.size {
+		b.entries = append(b.entries, e)
+		return
+	}
+	b.entries[b.next] = e
+	b.next = (b.next + 1) % b.size
+}
+
+// Get the time of the newest message in the buffer (zero if it is empty).
+func (b *Buffer) Latest() time.Time {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if len(b.entries) == 0 {
+		return time.Time{}
+	}
+	return b.entries[(b.next+len(b.entries)-1)%len(b.entries)].Time
+}
+
+// Get the messages sent at or after the given time (all of them if it is
+// zero), oldest first.  Like message times, t is truncated to the
+// millisecond.
+func (b *Buffer) Since(t time.Time) Log {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	t = t.Truncate(time.Millisecond)
+
+	log := make(Log, 0, len(b.entries))
+	for i := range b.entries {
+		e := b.entries[(b.next+i)%len(b.entries)]
+		if e.Time.Before(t) {
+			continue
+		}
+		log = append(log, e)
+	}
+	return log
+}
+
+// A Ref refers to a message by its ID or to a point in time.  The zero Ref
+// refers to nothing (* in CHATHISTORY).
+type Ref struct {
+	ID   string
+	Time time.Time
+}
+
+// Parse a message reference: msgid=<id>, timestamp=<time> or *.
+func ParseRef(ref string) (r Ref, ok bool) {
+	if ref == "*" {
+		return r, true
+	}
+	eq := strings.Index(ref, "=")
+	if eq < 0 {
+		return r, false
+	}
+	switch typ, value := ref[:eq], ref[eq+1:]; typ {
+	case "msgid":
+		r.ID = value
+		return r, len(value) > 0
+	case "timestamp":
+		t, err := time.Parse(TimeFormat, value)
+		if err != nil {
+			// Accept timestamps with any (or no) fractional seconds
+			if t, err = time.Parse(time.RFC3339Nano, value); err != nil {
+				return r, false
+			}
+		}
+		r.Time = t
+		return r, true
+	}
+	return r, false
+}
+
+// Get whether the reference is *.
+func (r Ref) IsZero() bool {
+	return len(r.ID) == 0 && r.Time.IsZero()
+}
+
+// A Log is a list of history entries, oldest first.
+type Log []Entry
+
+// Find a reference in the log.  The messages before it are l[:lo] and those
+// after it are l[hi:]; a msgid refers to l[lo] itself.  If the msgid is not
+// in the log, ok is false.
+func (l Log) find(r Ref) (lo, hi int, ok bool) {
+	if len(r.ID) > 0 {
+		for i, e := range l {
+			if e.ID == r.ID {
+				return i, i + 1, true
+			}
+		}
+		return 0, 0, false
+	}
+	lo = sort.Search(len(l), func(i int) bool { return !l[i].Time.Before(r.Time) })
+	hi = sort.Search(len(l), func(i int) bool { return l[i].Time.After(r.Time) })
+	return lo, hi, true
+}
+
+// Get the last limit messages of the log.
+func (l Log) last(limit int) Log {
+	if len(l) > limit {
+		return l[len(l)-limit:]
+	}
+	return l
+}
+
+// Get the first limit messages of the log.
+func (l Log) first(limit int) Log {
+	if len(l) > limit {
+		return l[:limit]
+	}
+	return l
+}
+
+// Get the most recent messages, up to limit.  If r is not *, only messages
+// after it are returned.
+func (l Log) Latest(r Ref, limit int) Log {
+	if r.IsZero() {
+		return l.last(limit)
+	}
+	_, hi, ok := l.find(r)
+	if !ok {
+		return nil
+	}
+	return l[hi:].last(limit)
+}
+
+// Get up to limit messages immediately before r.
+func (l Log) Before(r Ref, limit int) Log {
+	lo, _, ok := l.find(r)
+	if !ok {
+		return nil
+	}
+	return l[:lo].last(limit)
+}
+
+// Get up to limit messages immediately after r.
+func (l Log) After(r Ref, limit int) Log {
+	_, hi, ok := l.find(r)
+	if !ok {
+		return nil
+	}
+	return l[hi:].first(limit)
+}
+
+// Get up to limit messages around r: no more than half of them from before
+// it, and the rest from it onward.
+func (l Log) Around(r Ref, limit int) Log {
+	lo, _, ok := l.find(r)
+	if !ok {
+		return nil
+	}
+	start := lo - limit/2
+	if start < 0 {
+		start = 0
+	}
+	return l[start:].first(limit)
+}
+
+// Get up to limit messages between r1 and r2 (exclusive).  If r1 is the
+// later of the two, the messages closest to it are returned.
+func (l Log) Between(r1, r2 Ref, limit int) Log {
+	lo1, hi1, ok1 := l.find(r1)
+	lo2, hi2, ok2 := l.find(r2)
+	switch {
+	case !ok1 || !ok2:
+		return nil
+	case lo1 <= lo2:
+		if hi1 > lo2 {
+			return nil
+		}
+		return l[hi1:lo2].first(limit)
+	default:
+		if hi2 > lo1 {
+			return nil
+		}
+		return l[hi2:lo1].last(limit)
+	}
+}
@@ -0,0 +1,133 @@
+package history
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConversationKey(t *testing.T) {
+	if got, want := ConversationKey("000AAAAAB", "000AAAAAA"), ConversationKey("000AAAAAA", "000AAAAAB"); got != want {
+		t.Errorf("ConversationKey(B, A) = %q, ConversationKey(A, B) = %q", got, want)
+	}
+
+	Get(ConversationKey("000AAAAAA", "000AAAAAB"), true)
+	Get(ConversationKey("000AAAAAC", "000AAAAAA"), true)
+	defer ExpireConversations(time.Now(), func(string) bool { return true })
+	convs := Conversations("000AAAAAA")
+	if _, ok := convs["000AAAAAB"]; !ok || len(convs) != 2 {
+		t.Errorf("Conversations(A) = %v, want B and C", convs)
+	}
+}
+
+func TestExpireConversations(t *testing.T) {
+	base := time.Unix(1000, 0)
+	add := func(key string, at time.Time) {
+		Get(key, true).Add(Entry{Time: at})
+	}
+	add("#chan", base)
+	defer Delete("#chan")
+	add(ConversationKey("000AAAAAA", "000AAAAAB"), base)
+	add(ConversationKey("000AAAAAA", "000AAAAAC"), base.Add(time.Hour))
+	add(ConversationKey("000AAAAAD", "000AAAAAC"), base.Add(time.Hour))
+
+	gone := func(party string) bool { return party == "000AAAAAD" }
+	if got, want := ExpireConversations(base.Add(time.Minute), gone), 2; got != want {
+		t.Errorf("ExpireConversations deleted %d, want %d", got, want)
+	}
+	if Get("#chan", false) == nil {
+		t.Errorf("channel history was expired")
+	}
+	if convs := Conversations("000AAAAAA"); len(convs) != 1 || convs["000AAAAAC"] == nil {
+		t.Errorf("Conversations(A) = %v, want only C", convs)
+	}
+	ExpireConversations(time.Now(), func(string) bool { return true })
+}
+
+func TestRingBuffer(t *testing.T) {
+	b := &Buffer{mutex: new(sync.RWMutex), size: 3}
+	base := time.Unix(1000, 0)
+	for i := 0; i < 5; i++ {
+		b.Add(Entry{ID: strconv.Itoa(i), Time: base.Add(time.Duration(i) * time.Second)})
+	}
+
+	log := b.Since(time.Time{})
+	if got, want := ids(log), "234"; got != want {
+		t.Errorf("Since(zero) = %q, want %q", got, want)
+	}
+	if got, want := ids(b.Since(base.Add(3*time.Second))), "34"; got != want {
+		t.Errorf("Since(3s) = %q, want %q", got, want)
+	}
+	if got, want := b.Latest(), base.Add(4*time.Second); !got.Equal(want) {
+		t.Errorf("Latest() = %v, want %v", got, want)
+	}
+}
+
+func ids(log Log) (s string) {
+	for _, e := range log {
+		s += e.ID
+	}
+	return
+}
+
+var queryTests = []struct {
+	Desc  string
+	Query func(Log) Log
+	IDs   string
+}{
+	{"latest *", func(l Log) Log { return l.Latest(Ref{}, 3) }, "789"},
+	{"latest msgid", func(l Log) Log { return l.Latest(Ref{ID: "7"}, 5) }, "89"},
+	{"before msgid", func(l Log) Log { return l.Before(Ref{ID: "5"}, 2) }, "34"},
+	{"before time", func(l Log) Log { return l.Before(Ref{Time: at(5)}, 10) }, "01234"},
+	{"after msgid", func(l Log) Log { return l.After(Ref{ID: "5"}, 2) }, "67"},
+	{"after time", func(l Log) Log { return l.After(Ref{Time: at(5)}, 2) }, "67"},
+	{"around", func(l Log) Log { return l.Around(Ref{ID: "5"}, 4) }, "3456"},
+	{"around start", func(l Log) Log { return l.Around(Ref{ID: "0"}, 4) }, "0123"},
+	{"between", func(l Log) Log { return l.Between(Ref{ID: "2"}, Ref{ID: "7"}, 3) }, "345"},
+	{"between reversed", func(l Log) Log { return l.Between(Ref{ID: "7"}, Ref{ID: "2"}, 3) }, "456"},
+	{"unknown msgid", func(l Log) Log { return l.After(Ref{ID: "x"}, 3) }, ""},
+}
+
+func at(sec int) time.Time {
+	return time.Unix(2000+int64(sec), 0)
+}
+
+func TestQueries(t *testing.T) {
+	log := Log{}
+	for i := 0; i < 10; i++ {
+		log = append(log, Entry{ID: strconv.Itoa(i), Time: at(i)})
+	}
+	for _, test := range queryTests {
+		if got, want := ids(test.Query(log)), test.IDs; got != want {
+			t.Errorf("%s: got %q, want %q", test.Desc, got, want)
+		}
+	}
+}
+
+var parseRefTests = []struct {
+	Ref string
+	OK  bool
+	Out Ref
+}{
+	{"*", true, Ref{}},
+	{"msgid=abc", true, Ref{ID: "abc"}},
+	{"timestamp=2012-06-30T23:59:59.419Z", true, Ref{Time: time.Date(2012, 6, 30, 23, 59, 59, 419e6, time.UTC)}},
+	{"timestamp=2012-06-30T23:59:59Z", true, Ref{Time: time.Date(2012, 6, 30, 23, 59, 59, 0, time.UTC)}},
+	{"timestamp=yesterday", false, Ref{}},
+	{"msgid=", false, Ref{}},
+	{"abc", false, Ref{}},
+}
+
+func TestParseRef(t *testing.T) {
+	for _, test := range parseRefTests {
+		got, ok := ParseRef(test.Ref)
+		if ok != test.OK {
+			t.Errorf("ParseRef(%q) ok = %v, want %v", test.Ref, ok, test.OK)
+			continue
+		}
+		if got.ID != test.Out.ID || !got.Time.Equal(test.Out.Time) {
+			t.Errorf("ParseRef(%q) = %+v, want %+v", test.Ref, got, test.Out)
+		}
+	}
+}
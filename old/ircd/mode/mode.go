@@ -117,6 +117,73 @@ func (mm *ModeMap) For(m Mode) *ModeSpec {
 	return &mm.Modes[m.Index]
 }
 
+// StatusModes returns the status modes in this mode set, ordered from the
+// highest rank to the lowest (the order in which they were declared).
+func (mm *ModeMap) StatusModes() (specs []*ModeSpec) {
+	for i := range mm.Modes[1:] {
+		if spec := &mm.Modes[i+1]; spec.typ == StatusMode {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// Chars returns the characters of all of the modes in this mode set.
+func (mm *ModeMap) Chars() string {
+	chars := make([]rune, 0, len(mm.Modes)-1)
+	for _, spec := range mm.Modes[1:] {
+		chars = append(chars, spec.char)
+	}
+	return string(chars)
+}
+
+// ArgChars returns the characters of the modes in this mode set that take an
+// argument when they are set.
+func (mm *ModeMap) ArgChars() string {
+	chars := []rune{}
+	for i := range mm.Modes[1:] {
+		if set, _ := mm.Modes[i+1].Args(); set > 0 {
+			chars = append(chars, mm.Modes[i+1].char)
+		}
+	}
+	return string(chars)
+}
+
+// ChanModes returns the CHANMODES value for RPL_ISUPPORT: the list, key,
+// limit and flag modes in this mode set, separated by commas.  Status modes
+// are advertised separately (see PrefixString).
+func (mm *ModeMap) ChanModes() string {
+	groups := make([][]rune, 4)
+	for _, spec := range mm.Modes[1:] {
+		switch spec.typ {
+		case ListMode:
+			groups[0] = append(groups[0], spec.char)
+		case KeyMode:
+			groups[1] = append(groups[1], spec.char)
+		case LimitMode:
+			groups[2] = append(groups[2], spec.char)
+		case FlagMode:
+			groups[3] = append(groups[3], spec.char)
+		}
+	}
+	strs := make([]string, len(groups))
+	for i, group := range groups {
+		strs[i] = string(group)
+	}
+	return strings.Join(strs, ",")
+}
+
+// PrefixString returns the PREFIX value for RPL_ISUPPORT, e.g. "(ohv)@%+",
+// listing the status modes from the highest rank to the lowest.
+func (mm *ModeMap) PrefixString() string {
+	chars, prefixes := "", ""
+	for _, spec := range mm.StatusModes() {
+		chars += string(spec.char)
+		prefixes += spec.prefix
+	}
+	return "(" + chars + ")" + prefixes
+}
+
 var (
 	UserModes = MakeModeMap(
 		newModeSpec('D', UserMode, "deaf"),
@@ -127,6 +194,7 @@ var (
 		newModeSpec('w', UserMode, "wallops recipient"),
 		newModeSpec('Z', UserMode, "SSL user"),
 		newModeSpec('r', UserMode, "registered with services"),
+		newModeSpec('s', UserMode, "server notice recipient"),
 	)
 	ChannelModes = MakeModeMap(
 		newModeSpec('o', StatusMode, "channel operator"),
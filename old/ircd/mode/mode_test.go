@@ -314,3 +314,18 @@ func BenchmarkActiveModes_Apply(b *testing.B) {
 		b.Errorf("Applies did not end up at empty: %q", after)
 	}
 }
+
+func TestSupportStrings(t *testing.T) {
+	if got, want := ChannelModes.ChanModes(), "beI,k,l,mnprst"; got != want {
+		t.Errorf("ChanModes() = %q, want %q", got, want)
+	}
+	if got, want := ChannelModes.PrefixString(), "(ohv)@%+"; got != want {
+		t.Errorf("PrefixString() = %q, want %q", got, want)
+	}
+	if got, want := ChannelModes.ArgChars(), "ohvbeIkl"; got != want {
+		t.Errorf("ArgChars() = %q, want %q", got, want)
+	}
+	if got, want := UserModes.Chars(), "DSaiowZrs"; got != want {
+		t.Errorf("Chars() = %q, want %q", got, want)
+	}
+}
@@ -6,24 +6,68 @@ const (
 	CMD_USER   = "USER"
 	CMD_SERVER = "SERVER"
 	CMD_CAPAB  = "CAPAB"
+	CMD_CAP    = "CAP"
 	CMD_PASS   = "PASS"
 	CMD_ERROR  = "ERROR"
 	CMD_QUIT   = "QUIT"
 	CMD_PING   = "PING"
 	CMD_PONG   = "PONG"
 
-	CMD_OPER = "OPER"
-	CMD_MODE = "MODE"
+	CMD_AUTHENTICATE = "AUTHENTICATE"
+
+	CMD_OPER   = "OPER"
+	CMD_MODE   = "MODE"
+	CMD_KILL   = "KILL"
+	CMD_REHASH = "REHASH"
+
+	CMD_DIE     = "DIE"
+	CMD_RESTART = "RESTART"
+	CMD_CONNECT = "CONNECT"
+
+	CMD_KLINE   = "KLINE"
+	CMD_DLINE   = "DLINE"
+	CMD_XLINE   = "XLINE"
+	CMD_UNKLINE = "UNKLINE"
+	CMD_UNDLINE = "UNDLINE"
+	CMD_UNXLINE = "UNXLINE"
 
 	CMD_JOIN  = "JOIN"
 	CMD_PART  = "PART"
 	CMD_WHO   = "WHO"
+	CMD_WHOIS = "WHOIS"
 	CMD_TOPIC = "TOPIC"
 	CMD_NAMES = "NAMES"
+	CMD_KICK  = "KICK"
+	CMD_LIST  = "LIST"
+
+	CMD_WHOWAS = "WHOWAS"
+	CMD_AWAY   = "AWAY"
+
+	CMD_LUSERS  = "LUSERS"
+	CMD_MOTD    = "MOTD"
+	CMD_ADMIN   = "ADMIN"
+	CMD_VERSION = "VERSION"
+	CMD_INFO    = "INFO"
+	CMD_TIME    = "TIME"
+
+	CMD_ISON     = "ISON"
+	CMD_USERHOST = "USERHOST"
+	CMD_MONITOR  = "MONITOR"
+
+	CMD_ACCOUNT = "ACCOUNT"
+	CMD_CHGHOST = "CHGHOST"
+	CMD_SETNAME = "SETNAME"
 
 	CMD_WALLOPS = "WALLOPS"
 	CMD_PRIVMSG = "PRIVMSG"
 	CMD_NOTICE  = "NOTICE"
+	CMD_TAGMSG  = "TAGMSG"
+
+	CMD_CHATHISTORY = "CHATHISTORY"
+
+	CMD_BATCH = "BATCH"
+	CMD_ACK   = "ACK"
+	CMD_FAIL  = "FAIL"
 
 	// Server commands
 	CMD_SJOIN = "SJOIN"
@@ -34,6 +78,7 @@ const (
 	CMD_ENCAP = "ENCAP"
 	CMD_BMASK = "BMASK"
 	CMD_TB    = "TB"
+	CMD_BAN   = "BAN"
 
 	// Internal commands
 	INT_DELUSER = "deluser" // Delete all UIDs in DestIDs
@@ -9,7 +9,8 @@ const (
 	RPL_YOURHOST          = "002"
 	RPL_CREATED           = "003"
 	RPL_MYINFO            = "004"
-	RPL_BOUNCE            = "005"
+	RPL_ISUPPORT          = "005"
+	RPL_SNOMASK           = "008"
 	RPL_TRACELINK         = "200"
 	RPL_TRACECONNECTING   = "201"
 	RPL_TRACEHANDSHAKE    = "202"
@@ -28,17 +29,20 @@ const (
 	RPL_SERVLISTEND       = "235"
 	RPL_STATSUPTIME       = "242"
 	RPL_STATSOLINE        = "243"
-	RPL_ISUPPORT          = "250"
 	RPL_LUSERCLIENT       = "251"
 	RPL_LUSEROP           = "252"
 	RPL_LUSERUNKNOWN      = "253"
 	RPL_LUSERCHANNELS     = "254"
 	RPL_LUSERME           = "255"
 	RPL_ADMINME           = "256"
+	RPL_ADMINLOC1         = "257"
+	RPL_ADMINLOC2         = "258"
 	RPL_ADMINEMAIL        = "259"
 	RPL_TRACELOG          = "261"
 	RPL_TRACEEND          = "262"
 	RPL_TRYAGAIN          = "263"
+	RPL_LOCALUSERS        = "265"
+	RPL_GLOBALUSERS       = "266"
 	RPL_AWAY              = "301"
 	RPL_USERHOST          = "302"
 	RPL_ISON              = "303"
@@ -56,8 +60,10 @@ const (
 	RPL_LISTEND           = "323"
 	RPL_CHANNELMODEIS     = "324"
 	RPL_UNIQOPIS          = "325"
+	RPL_WHOISACCOUNT      = "330"
 	RPL_NOTOPIC           = "331"
 	RPL_TOPIC             = "332"
+	RPL_TOPICWHOTIME      = "333"
 	RPL_INVITING          = "341"
 	RPL_SUMMONING         = "342"
 	RPL_INVITELIST        = "346"
@@ -67,6 +73,7 @@ const (
 	RPL_VERSION           = "351"
 	RPL_WHOREPLY          = "352"
 	RPL_NAMREPLY          = "353"
+	RPL_WHOSPCRPL         = "354"
 	RPL_LINKS             = "364"
 	RPL_ENDOFLINKS        = "365"
 	RPL_ENDOFNAMES        = "366"
@@ -95,6 +102,7 @@ const (
 	ERR_TOOMANYTARGETS    = "407"
 	ERR_NOSUCHSERVICE     = "408"
 	ERR_NOORIGIN          = "409"
+	ERR_INVALIDCAPCMD     = "410"
 	ERR_NORECIPIENT       = "411"
 	ERR_NOTEXTTOSEND      = "412"
 	ERR_NOTOPLEVEL        = "413"
@@ -138,6 +146,19 @@ const (
 	ERR_NOOPERHOST        = "491"
 	ERR_UMODEUNKNOWNFLAG  = "501"
 	ERR_USERSDONTMATCH    = "502"
+	ERR_NOPRIVS           = "723"
+	RPL_MONONLINE         = "730"
+	RPL_MONOFFLINE        = "731"
+	RPL_MONLIST           = "732"
+	RPL_ENDOFMONLIST      = "733"
+	ERR_MONLISTFULL       = "734"
+	RPL_LOGGEDIN          = "900"
+	RPL_SASLSUCCESS       = "903"
+	ERR_SASLFAIL          = "904"
+	ERR_SASLTOOLONG       = "905"
+	ERR_SASLABORTED       = "906"
+	ERR_SASLALREADY       = "907"
+	RPL_SASLMECHS         = "908"
 	RPL_CUSTOM            = "999"
 )
 
@@ -155,8 +176,10 @@ var NumericName = map[string]string{
 	ERR_CHANOPRIVSNEEDED:  "ERR_CHANOPRIVSNEEDED",
 	ERR_ERRONEUSNICKNAME:  "ERR_ERRONEUSNICKNAME",
 	ERR_FILEERROR:         "ERR_FILEERROR",
+	ERR_INVALIDCAPCMD:     "ERR_INVALIDCAPCMD",
 	ERR_INVITEONLYCHAN:    "ERR_INVITEONLYCHAN",
 	ERR_KEYSET:            "ERR_KEYSET",
+	ERR_MONLISTFULL:       "ERR_MONLISTFULL",
 	ERR_NEEDMOREPARAMS:    "ERR_NEEDMOREPARAMS",
 	ERR_NICKCOLLISION:     "ERR_NICKCOLLISION",
 	ERR_NICKNAMEINUSE:     "ERR_NICKNAMEINUSE",
@@ -169,6 +192,7 @@ var NumericName = map[string]string{
 	ERR_NOORIGIN:          "ERR_NOORIGIN",
 	ERR_NOPERMFORHOST:     "ERR_NOPERMFORHOST",
 	ERR_NOPRIVILEGES:      "ERR_NOPRIVILEGES",
+	ERR_NOPRIVS:           "ERR_NOPRIVS",
 	ERR_NORECIPIENT:       "ERR_NORECIPIENT",
 	ERR_NOSUCHCHANNEL:     "ERR_NOSUCHCHANNEL",
 	ERR_NOSUCHNICK:        "ERR_NOSUCHNICK",
@@ -180,6 +204,10 @@ var NumericName = map[string]string{
 	ERR_NOTREGISTERED:     "ERR_NOTREGISTERED",
 	ERR_PASSWDMISMATCH:    "ERR_PASSWDMISMATCH",
 	ERR_RESTRICTED:        "ERR_RESTRICTED",
+	ERR_SASLABORTED:       "ERR_SASLABORTED",
+	ERR_SASLALREADY:       "ERR_SASLALREADY",
+	ERR_SASLFAIL:          "ERR_SASLFAIL",
+	ERR_SASLTOOLONG:       "ERR_SASLTOOLONG",
 	ERR_SUMMONDISABLED:    "ERR_SUMMONDISABLED",
 	ERR_TOOMANYCHANNELS:   "ERR_TOOMANYCHANNELS",
 	ERR_TOOMANYTARGETS:    "ERR_TOOMANYTARGETS",
@@ -196,10 +224,11 @@ var NumericName = map[string]string{
 	ERR_WILDTOPLEVEL:      "ERR_WILDTOPLEVEL",
 	ERR_YOUREBANNEDCREEP:  "ERR_YOUREBANNEDCREEP",
 	RPL_ADMINEMAIL:        "RPL_ADMINEMAIL",
+	RPL_ADMINLOC1:         "RPL_ADMINLOC1",
+	RPL_ADMINLOC2:         "RPL_ADMINLOC2",
 	RPL_ADMINME:           "RPL_ADMINME",
 	RPL_AWAY:              "RPL_AWAY",
 	RPL_BANLIST:           "RPL_BANLIST",
-	RPL_BOUNCE:            "RPL_BOUNCE",
 	RPL_CHANNELMODEIS:     "RPL_CHANNELMODEIS",
 	RPL_CREATED:           "RPL_CREATED",
 	RPL_CUSTOM:            "RPL_CUSTOM",
@@ -208,6 +237,7 @@ var NumericName = map[string]string{
 	RPL_ENDOFINFO:         "RPL_ENDOFINFO",
 	RPL_ENDOFINVITELIST:   "RPL_ENDOFINVITELIST",
 	RPL_ENDOFLINKS:        "RPL_ENDOFLINKS",
+	RPL_ENDOFMONLIST:      "RPL_ENDOFMONLIST",
 	RPL_ENDOFMOTD:         "RPL_ENDOFMOTD",
 	RPL_ENDOFNAMES:        "RPL_ENDOFNAMES",
 	RPL_ENDOFSTATS:        "RPL_ENDOFSTATS",
@@ -216,6 +246,7 @@ var NumericName = map[string]string{
 	RPL_ENDOFWHOIS:        "RPL_ENDOFWHOIS",
 	RPL_ENDOFWHOWAS:       "RPL_ENDOFWHOWAS",
 	RPL_EXCEPTLIST:        "RPL_EXCEPTLIST",
+	RPL_GLOBALUSERS:       "RPL_GLOBALUSERS",
 	RPL_INFO:              "RPL_INFO",
 	RPL_INVITELIST:        "RPL_INVITELIST",
 	RPL_INVITING:          "RPL_INVITING",
@@ -224,11 +255,16 @@ var NumericName = map[string]string{
 	RPL_LINKS:             "RPL_LINKS",
 	RPL_LIST:              "RPL_LIST",
 	RPL_LISTEND:           "RPL_LISTEND",
+	RPL_LOCALUSERS:        "RPL_LOCALUSERS",
+	RPL_LOGGEDIN:          "RPL_LOGGEDIN",
 	RPL_LUSERCHANNELS:     "RPL_LUSERCHANNELS",
 	RPL_LUSERCLIENT:       "RPL_LUSERCLIENT",
 	RPL_LUSERME:           "RPL_LUSERME",
 	RPL_LUSEROP:           "RPL_LUSEROP",
 	RPL_LUSERUNKNOWN:      "RPL_LUSERUNKNOWN",
+	RPL_MONLIST:           "RPL_MONLIST",
+	RPL_MONOFFLINE:        "RPL_MONOFFLINE",
+	RPL_MONONLINE:         "RPL_MONONLINE",
 	RPL_MOTD:              "RPL_MOTD",
 	RPL_MOTDSTART:         "RPL_MOTDSTART",
 	RPL_MYINFO:            "RPL_MYINFO",
@@ -237,8 +273,11 @@ var NumericName = map[string]string{
 	RPL_NOUSERS:           "RPL_NOUSERS",
 	RPL_NOWAWAY:           "RPL_NOWAWAY",
 	RPL_REHASHING:         "RPL_REHASHING",
+	RPL_SASLMECHS:         "RPL_SASLMECHS",
+	RPL_SASLSUCCESS:       "RPL_SASLSUCCESS",
 	RPL_SERVLIST:          "RPL_SERVLIST",
 	RPL_SERVLISTEND:       "RPL_SERVLISTEND",
+	RPL_SNOMASK:           "RPL_SNOMASK",
 	RPL_STATSCOMMANDS:     "RPL_STATSCOMMANDS",
 	RPL_STATSLINKINFO:     "RPL_STATSLINKINFO",
 	RPL_STATSOLINE:        "RPL_STATSOLINE",
@@ -246,6 +285,7 @@ var NumericName = map[string]string{
 	RPL_SUMMONING:         "RPL_SUMMONING",
 	RPL_TIME:              "RPL_TIME",
 	RPL_TOPIC:             "RPL_TOPIC",
+	RPL_TOPICWHOTIME:      "RPL_TOPICWHOTIME",
 	RPL_TRACECLASS:        "RPL_TRACECLASS",
 	RPL_TRACECONNECTING:   "RPL_TRACECONNECTING",
 	RPL_TRACEEND:          "RPL_TRACEEND",
@@ -267,12 +307,14 @@ var NumericName = map[string]string{
 	RPL_USERSSTART:        "RPL_USERSSTART",
 	RPL_VERSION:           "RPL_VERSION",
 	RPL_WELCOME:           "RPL_WELCOME",
+	RPL_WHOISACCOUNT:      "RPL_WHOISACCOUNT",
 	RPL_WHOISCHANNELS:     "RPL_WHOISCHANNELS",
 	RPL_WHOISIDLE:         "RPL_WHOISIDLE",
 	RPL_WHOISOPERATOR:     "RPL_WHOISOPERATOR",
 	RPL_WHOISSERVER:       "RPL_WHOISSERVER",
 	RPL_WHOISUSER:         "RPL_WHOISUSER",
 	RPL_WHOREPLY:          "RPL_WHOREPLY",
+	RPL_WHOSPCRPL:         "RPL_WHOSPCRPL",
 	RPL_WHOWASUSER:        "RPL_WHOWASUSER",
 	RPL_YOUREOPER:         "RPL_YOUREOPER",
 	RPL_YOURESERVICE:      "RPL_YOURESERVICE",
@@ -293,8 +335,10 @@ var NumericText = map[string]string{
 	ERR_CHANOPRIVSNEEDED:  `<channel> :You're not channel operator`,
 	ERR_ERRONEUSNICKNAME:  `<nick> :Erroneous nickname`,
 	ERR_FILEERROR:         `File error doing <file op> on <file>`,
+	ERR_INVALIDCAPCMD:     `<command> :Invalid CAP command`,
 	ERR_INVITEONLYCHAN:    `<channel> :Cannot join channel (+i)`,
 	ERR_KEYSET:            `<channel> :Channel key already set`,
+	ERR_MONLISTFULL:       `<limit> <targets> :Monitor list is full`,
 	ERR_NEEDMOREPARAMS:    `<command> :Not enough parameters`,
 	ERR_NICKCOLLISION:     `<nick> :Nickname collision KILL from <user>@<host>`,
 	ERR_NICKNAMEINUSE:     `<nick> :Nickname is already in use`,
@@ -307,6 +351,7 @@ var NumericText = map[string]string{
 	ERR_NOORIGIN:          `No origin specified`,
 	ERR_NOPERMFORHOST:     `Your host isn't among the privileged`,
 	ERR_NOPRIVILEGES:      `Permission Denied- You're not an IRC operator`,
+	ERR_NOPRIVS:           `<priv> :Insufficient oper privileges.`,
 	ERR_NORECIPIENT:       `No recipient given (<command>)`,
 	ERR_NOSUCHCHANNEL:     `<channel name> :No such channel`,
 	ERR_NOSUCHNICK:        `<nickname> :No such nick/channel`,
@@ -318,6 +363,10 @@ var NumericText = map[string]string{
 	ERR_NOTREGISTERED:     `You have not registered`,
 	ERR_PASSWDMISMATCH:    `Password incorrect`,
 	ERR_RESTRICTED:        `Your connection is restricted!`,
+	ERR_SASLABORTED:       `SASL authentication aborted`,
+	ERR_SASLALREADY:       `You have already authenticated using SASL`,
+	ERR_SASLFAIL:          `SASL authentication failed`,
+	ERR_SASLTOOLONG:       `SASL message too long`,
 	ERR_SUMMONDISABLED:    `SUMMON has been disabled`,
 	ERR_TOOMANYCHANNELS:   `<channel name> :You have joined too many channels`,
 	ERR_TOOMANYTARGETS:    `<target> :<error code> recipients. <abort message>`,
@@ -334,10 +383,11 @@ var NumericText = map[string]string{
 	ERR_WILDTOPLEVEL:      `<mask> :Wildcard in toplevel domain`,
 	ERR_YOUREBANNEDCREEP:  `You are banned from this server`,
 	RPL_ADMINEMAIL:        `<admin info>`,
+	RPL_ADMINLOC1:         `<admin info>`,
+	RPL_ADMINLOC2:         `<admin info>`,
 	RPL_ADMINME:           `<server> :Administrative info`,
 	RPL_AWAY:              `<nick> :<away message>`,
 	RPL_BANLIST:           `<channel> <banmask>`,
-	RPL_BOUNCE:            `Try server <server name>, port <port number>`,
 	RPL_CHANNELMODEIS:     `<channel> <mode> <mode params>`,
 	RPL_CREATED:           `This server was created <date>`,
 	RPL_CUSTOM:            `<param> <param> :Custom Numeric`,
@@ -346,6 +396,7 @@ var NumericText = map[string]string{
 	RPL_ENDOFINFO:         `End of INFO list`,
 	RPL_ENDOFINVITELIST:   `<channel> :End of channel invite list`,
 	RPL_ENDOFLINKS:        `<mask> :End of LINKS list`,
+	RPL_ENDOFMONLIST:      `End of MONITOR list`,
 	RPL_ENDOFMOTD:         `End of MOTD command`,
 	RPL_ENDOFNAMES:        `<channel> :End of NAMES list`,
 	RPL_ENDOFSTATS:        `<stats letter> :End of STATS report`,
@@ -354,6 +405,7 @@ var NumericText = map[string]string{
 	RPL_ENDOFWHOIS:        `<nick> :End of WHOIS list`,
 	RPL_ENDOFWHOWAS:       `<nick> :End of WHOWAS`,
 	RPL_EXCEPTLIST:        `<channel> <exceptionmask>`,
+	RPL_GLOBALUSERS:       `<integer> <integer> :Current global users`,
 	RPL_INFO:              `<string>`,
 	RPL_INVITELIST:        `<channel> <invitemask>`,
 	RPL_INVITING:          `<channel> <nick>`,
@@ -362,11 +414,16 @@ var NumericText = map[string]string{
 	RPL_LINKS:             `<mask> <server> :<hopcount> <server info>`,
 	RPL_LIST:              `<channel> <# visible> :<topic>`,
 	RPL_LISTEND:           `End of LIST`,
+	RPL_LOCALUSERS:        `<integer> <integer> :Current local users`,
+	RPL_LOGGEDIN:          `<nick>!<user>@<host> <account> :You are now logged in`,
 	RPL_LUSERCHANNELS:     `<integer> :channels formed`,
 	RPL_LUSERCLIENT:       `There are <integer> users and <integer> services on <integer> servers`,
 	RPL_LUSERME:           `I have <integer> clients and <integer> servers`,
 	RPL_LUSEROP:           `<integer> :operator(s) online`,
 	RPL_LUSERUNKNOWN:      `<integer> :unknown connection(s)`,
+	RPL_MONLIST:           `<target>{,<target>}`,
+	RPL_MONOFFLINE:        `<target>{,<target>}`,
+	RPL_MONONLINE:         `<target>[!<user>@<host>]{,<target>[!<user>@<host>]}`,
 	RPL_MOTD:              `- <text>`,
 	RPL_MOTDSTART:         `- <server> Message of the day - `,
 	RPL_MYINFO:            `<servername> <version> <available user modes> <available channel modes>`,
@@ -375,8 +432,11 @@ var NumericText = map[string]string{
 	RPL_NOUSERS:           `Nobody logged in`,
 	RPL_NOWAWAY:           `You have been marked as being away`,
 	RPL_REHASHING:         `<config file> :Rehashing`,
+	RPL_SASLMECHS:         `<mechanisms> :are available SASL mechanisms`,
+	RPL_SASLSUCCESS:       `SASL authentication successful`,
 	RPL_SERVLIST:          `<name> <server> <mask> <type> <hopcount> <info>`,
 	RPL_SERVLISTEND:       `<mask> <type> :End of service listing`,
+	RPL_SNOMASK:           `<mask> :Server notice mask`,
 	RPL_STATSCOMMANDS:     `<command> <count> <byte count> <remote count>`,
 	RPL_STATSLINKINFO:     `<linkname> <sendq> <sent messages> <sent Kbytes> <received messages> <received Kbytes> <time open>`,
 	RPL_STATSOLINE:        `O <hostmask> * <name>`,
@@ -384,6 +444,7 @@ var NumericText = map[string]string{
 	RPL_SUMMONING:         `<user> :Summoning user to IRC`,
 	RPL_TIME:              `<server> :<string showing server's local time>`,
 	RPL_TOPIC:             `<channel> :<topic>`,
+	RPL_TOPICWHOTIME:      `<channel> <nick> :<setat>`,
 	RPL_TRACECLASS:        `Class <class> <count>`,
 	RPL_TRACECONNECTING:   `Try. <class> <server>`,
 	RPL_TRACEEND:          `<server name> <version & debug level> :End of TRACE`,
@@ -405,12 +466,14 @@ var NumericText = map[string]string{
 	RPL_USERSSTART:        `UserID   Terminal  Host`,
 	RPL_VERSION:           `<version>.<debuglevel> <server> :<comments>`,
 	RPL_WELCOME:           `Welcome to the Internet Relay Network <nick>!<user>@<host>`,
+	RPL_WHOISACCOUNT:      `<nick> <account> :is logged in as`,
 	RPL_WHOISCHANNELS:     `<nick> :*( ( "@" / "+" ) <channel> " " )`,
-	RPL_WHOISIDLE:         `<nick> <integer> :seconds idle`,
+	RPL_WHOISIDLE:         `<nick> <idle> <signon> :seconds idle, signon time`,
 	RPL_WHOISOPERATOR:     `<nick> :is an IRC operator`,
 	RPL_WHOISSERVER:       `<nick> <server> :<server info>`,
 	RPL_WHOISUSER:         `<nick> <user> <host> * :<real name>`,
 	RPL_WHOREPLY:          `<channel> <user> <host> <server> <nick> ( "H" / "G" > ["*"] [ ( "@" / "+" ) ] :<hopcount> <real name>`,
+	RPL_WHOSPCRPL:         `<fields> :<real name>`,
 	RPL_WHOWASUSER:        `<nick> <user> <host> * :<real name>`,
 	RPL_YOUREOPER:         `You are now an IRC operator`,
 	RPL_YOURESERVICE:      `You are service <servicename>`,
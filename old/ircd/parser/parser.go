@@ -2,10 +2,16 @@ package parser
 
 import (
 	"bytes"
+	"sort"
 	"strings"
 )
 
+// The most bytes of IRCv3 tags (including the leading @ and trailing space)
+// accepted on a line, in addition to the usual 512 bytes for the message.
+const MaxTagLength = 8191
+
 type Message struct {
+	Tags    map[string]string // IRCv3 message tags (unescaped)
 	Prefix  string
 	Command string
 	Args    []string
@@ -26,6 +32,12 @@ func NewMessage(pfx, cmd string, args []string) *Message {
 func (m *Message) Dup() *Message {
 	n := new(Message)
 	n.Prefix, n.Command, n.SenderID = m.Prefix, m.Command, m.SenderID
+	if m.Tags != nil {
+		n.Tags = make(map[string]string, len(m.Tags))
+		for k, v := range m.Tags {
+			n.Tags[k] = v
+		}
+	}
 	n.Args = make([]string, len(m.Args))
 	copy(n.Args, m.Args)
 	n.DestIDs = make([]string, len(m.DestIDs))
@@ -33,12 +45,25 @@ func (m *Message) Dup() *Message {
 	return n
 }
 
+// Parse a line into a message.  If the line is empty or its tags are longer
+// than MaxTagLength, nil is returned.
 func ParseMessage(line []byte) *Message {
 	line = bytes.TrimSpace(line)
 	if len(line) <= 0 {
 		return nil
 	}
 	m := new(Message)
+	if line[0] == '@' {
+		split := bytes.SplitN(line, []byte{' '}, 2)
+		if len(split) <= 1 || len(split[0])+1 > MaxTagLength {
+			return nil
+		}
+		m.Tags = parseTags(string(split[0][1:]))
+		line = bytes.TrimLeft(split[1], " ")
+		if len(line) <= 0 {
+			return nil
+		}
+	}
 	if line[0] == ':' {
 		split := bytes.SplitN(line, []byte{' '}, 2)
 		if len(split) <= 1 {
@@ -60,8 +85,104 @@ func ParseMessage(line []byte) *Message {
 	return m
 }
 
+// Parse the tags (without the leading @) of a message.
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(raw, ";") {
+		if len(tag) == 0 {
+			continue
+		}
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 1 {
+			tags[kv[0]] = ""
+			continue
+		}
+		tags[kv[0]] = UnescapeTag(kv[1])
+	}
+	return tags
+}
+
+var tagEscapes = strings.NewReplacer(
+	"\\", "\\\\",
+	";", "\\:",
+	" ", "\\s",
+	"\r", "\\r",
+	"\n", "\\n",
+)
+
+// EscapeTag escapes a tag value for sending.
+func EscapeTag(value string) string {
+	return tagEscapes.Replace(value)
+}
+
+// UnescapeTag unescapes a received tag value.  Unknown escapes stand for the
+// escaped character, and a trailing backslash is dropped.
+func UnescapeTag(value string) string {
+	if strings.IndexByte(value, '\\') < 0 {
+		return value
+	}
+	buf := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buf = append(buf, value[i])
+			continue
+		}
+		if i++; i == len(value) {
+			break
+		}
+		switch value[i] {
+		case ':':
+			buf = append(buf, ';')
+		case 's':
+			buf = append(buf, ' ')
+		case 'r':
+			buf = append(buf, '\r')
+		case 'n':
+			buf = append(buf, '\n')
+		default:
+			buf = append(buf, value[i])
+		}
+	}
+	return string(buf)
+}
+
+// Get the client-only tags (those starting with +) of a message, or nil if
+// there are none.
+func (m *Message) ClientTags() map[string]string {
+	var tags map[string]string
+	for key, value := range m.Tags {
+		if strings.HasPrefix(key, "+") {
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
 func (m Message) Bytes() []byte {
 	buf := bytes.NewBuffer(make([]byte, 0, 512))
+	if len(m.Tags) > 0 {
+		keys := make([]string, 0, len(m.Tags))
+		for key := range m.Tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('@')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(';')
+			}
+			buf.WriteString(key)
+			if value := m.Tags[key]; len(value) > 0 {
+				buf.WriteByte('=')
+				buf.WriteString(EscapeTag(value))
+			}
+		}
+		buf.WriteByte(' ')
+	}
 	if len(m.Prefix) > 0 {
 		buf.WriteByte(':')
 		buf.WriteString(m.Prefix)
@@ -71,7 +192,7 @@ func (m Message) Bytes() []byte {
 	for i, arg := range m.Args {
 		buf.WriteByte(' ')
 		if i == len(m.Args)-1 {
-			if strings.IndexAny(arg, " :") >= 0 {
+			if len(arg) == 0 || strings.IndexAny(arg, " :") >= 0 {
 				buf.WriteByte(':')
 			}
 		}
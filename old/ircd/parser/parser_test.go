@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -46,6 +47,7 @@ var build_message_tests = []struct {
 	{":A B C", "A", "B", []string{"C"}},
 	{"B C", "", "B", []string{"C"}},
 	{":A B C D", "A", "B", []string{"C", "D"}},
+	{":A B C :", "A", "B", []string{"C", ""}},
 }
 
 func TestBuildMessage(t *testing.T) {
@@ -66,6 +68,64 @@ func TestBuildMessage(t *testing.T) {
 	}
 }
 
+var parse_tags_tests = []struct {
+	raw     string
+	command string
+	tags    map[string]string
+}{
+	{"@time=2012-06-30T23:59:60.419Z :nick!u@h PRIVMSG #chan :hi",
+		"PRIVMSG", map[string]string{"time": "2012-06-30T23:59:60.419Z"}},
+	{"@+typing=active;label TAGMSG #chan",
+		"TAGMSG", map[string]string{"+typing": "active", "label": ""}},
+	{`@a=semi\:space\sslash\\cr\rlf\n;b=unknown\x;c=trailing\ PING`,
+		"PING", map[string]string{"a": "semi;space slash\\cr\rlf\n", "b": "unknownx", "c": "trailing"}},
+	{"B C", "B", nil},
+}
+
+func TestParseTags(t *testing.T) {
+	for i, test := range parse_tags_tests {
+		m := ParseMessage([]byte(test.raw))
+		if m == nil {
+			t.Errorf("%d: ParseMessage(%q) = nil", i, test.raw)
+			continue
+		}
+		if test.command != m.Command {
+			t.Errorf("%d: Expected command %q, got %q", i, test.command, m.Command)
+		}
+		if len(test.tags) != len(m.Tags) {
+			t.Errorf("%d: Expected tags %q, got %q", i, test.tags, m.Tags)
+		}
+		for key, value := range test.tags {
+			if got, ok := m.Tags[key]; !ok || got != value {
+				t.Errorf("%d: Expected tag %s=%q, got %q", i, key, value, got)
+			}
+		}
+	}
+
+	long := "@+a=" + strings.Repeat("x", MaxTagLength) + " TAGMSG #chan"
+	if m := ParseMessage([]byte(long)); m != nil {
+		t.Errorf("Expected nil for overlong tags, got %q", m)
+	}
+}
+
+func TestBuildTags(t *testing.T) {
+	m := &Message{
+		Tags:    map[string]string{"time": "now", "+reply": "a b;c\\", "label": ""},
+		Prefix:  "A",
+		Command: "B",
+		Args:    []string{"C"},
+	}
+	if got, want := m.String(), `@+reply=a\sb\:c\\;label;time=now :A B C`; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got, want := len(m.ClientTags()), 1; got != want {
+		t.Errorf("Expected %d client tags, got %d", want, got)
+	}
+	if got := ParseMessage(m.Bytes()).Tags["+reply"]; got != m.Tags["+reply"] {
+		t.Errorf("Expected round trip of %q, got %q", m.Tags["+reply"], got)
+	}
+}
+
 var parse_message_bench = []byte(":server.kevlar.net NOTICE user :*** This is a test")
 
 func BenchmarkParseMessage(b *testing.B) {
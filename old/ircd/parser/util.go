@@ -5,6 +5,12 @@ import (
 	"unicode/utf8"
 )
 
+var (
+	// The longest nick and channel name that are considered valid.
+	NickLen    = 30
+	ChannelLen = 50
+)
+
 func isletter(r rune) bool {
 	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
 }
@@ -67,7 +73,7 @@ func ValidServerPrefix(pfx string) bool {
 }
 
 func ValidNick(str string) bool {
-	if len(str) == 0 {
+	if len(str) == 0 || len(str) > NickLen {
 		return false
 	}
 	first, _ := utf8.DecodeRuneInString(str)
@@ -83,7 +89,7 @@ func ValidNick(str string) bool {
 }
 
 func ValidChannel(str string) bool {
-	if len(str) == 0 {
+	if len(str) == 0 || len(str) > ChannelLen {
 		return false
 	}
 	if str[0] != '#' {
@@ -118,3 +124,38 @@ func StripUnsafe(str string) string {
 		return -1
 	}, str)
 }
+
+// Match reports whether str matches the IRC-style mask, in which * matches
+// any sequence of characters and ? matches any single character.  The
+// comparison is case-insensitive.
+func Match(mask, str string) bool {
+	mask, str = ToLower(mask), ToLower(str)
+
+	// star and back are the positions to retry from after a mismatch
+	star, back := -1, 0
+	m, s := 0, 0
+	for s < len(str) {
+		switch {
+		case m < len(mask) && mask[m] == '*':
+			star, back = m, s
+			m++
+		case m < len(mask) && (mask[m] == '?' || mask[m] == str[s]):
+			m++
+			s++
+		case star >= 0:
+			back++
+			m, s = star+1, back
+		default:
+			return false
+		}
+	}
+	for m < len(mask) && mask[m] == '*' {
+		m++
+	}
+	return m == len(mask)
+}
+
+// IsMask reports whether the string contains any mask wildcards.
+func IsMask(str string) bool {
+	return strings.ContainsAny(str, "*?")
+}
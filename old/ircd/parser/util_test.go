@@ -0,0 +1,32 @@
+package parser
+
+import (
+	"testing"
+)
+
+var matchTests = []struct {
+	Mask  string
+	Str   string
+	Match bool
+}{
+	{"*", "", true},
+	{"*", "anything", true},
+	{"nick", "NICK", true},
+	{"nick", "nick2", false},
+	{"n?ck", "nack", true},
+	{"n?ck", "nck", false},
+	{"*!*@*.example.com", "nick!user@host.example.com", true},
+	{"*!*@*.example.com", "nick!user@example.com", false},
+	{"[nick]*", "{NICK}away", true},
+	{"a*b*c", "aXXbYYbZZc", true},
+	{"a*b*c", "aXXbYYbZZ", false},
+	{"*.*", "server", false},
+}
+
+func TestMatch(t *testing.T) {
+	for idx, test := range matchTests {
+		if got, want := Match(test.Mask, test.Str), test.Match; got != want {
+			t.Errorf("#%d: Match(%q, %q) = %v, want %v", idx, test.Mask, test.Str, got, want)
+		}
+	}
+}
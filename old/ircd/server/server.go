@@ -59,6 +59,20 @@ func (s *Server) Type() servType {
 	return s.styp
 }
 
+// Get the server description.
+func (s *Server) Description() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.desc
+}
+
+// Get the number of hops to the server.
+func (s *Server) Hops() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.hops
+}
+
 // Atomically get all of the server's information.
 func (s *Server) Info() (sid, server, pass string, capab []string) {
 	s.mutex.RLock()
@@ -142,6 +156,26 @@ func GetInfo(id string) (sid, server string, capab []string, typ servType, ok bo
 	return s.id, s.server, s.capab, s.styp, true
 }
 
+// Find returns the SID of a server whose name matches the given mask.
+func Find(mask string) (sid string, ok bool) {
+	servMutex.RLock()
+	defer servMutex.RUnlock()
+
+	for sid, s := range servMap {
+		if parser.Match(mask, s.server) {
+			return sid, true
+		}
+	}
+	return "", false
+}
+
+// Get the number of other servers on the network.
+func Count() int {
+	servMutex.RLock()
+	defer servMutex.RUnlock()
+	return len(servMap)
+}
+
 // Iter iterates over all server links
 func Iter() <-chan string {
 	servMutex.RLock()
@@ -254,6 +288,18 @@ func (s *Server) SetCapab(capab string) error {
 	return nil
 }
 
+// Get whether the server sent the given token (e.g. "EUID") in its CAPAB.
+func (s *Server) HasCapab(token string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, c := range s.capab {
+		if c == token {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Server) SetServer(serv, hops string) error {
 	if len(serv) == 0 {
 		return errors.New("Zero-length server name")
@@ -0,0 +1,68 @@
+package user
+
+import (
+	"sort"
+)
+
+// Get whether the client has enabled the given capability.
+func (u *User) HasCap(name string) bool {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.caps[name]
+}
+
+// Enable or disable a capability for the client.
+func (u *User) SetCap(name string, enabled bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if !enabled {
+		delete(u.caps, name)
+		return
+	}
+	if u.caps == nil {
+		u.caps = make(map[string]bool)
+	}
+	u.caps[name] = true
+}
+
+// Get the capabilities enabled by the client in sorted order.
+func (u *User) Caps() []string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	caps := make([]string, 0, len(u.caps))
+	for name := range u.caps {
+		caps = append(caps, name)
+	}
+	sort.Strings(caps)
+	return caps
+}
+
+// Get the CAP LS version given by the client (0 if none was given).
+func (u *User) CapVersion() int {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.capVersion
+}
+
+// Set the CAP LS version given by the client.  The version is never lowered.
+func (u *User) SetCapVersion(version int) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if version > u.capVersion {
+		u.capVersion = version
+	}
+}
+
+// Get whether registration is suspended for capability negotiation.
+func (u *User) Negotiating() bool {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.negotiating
+}
+
+// Suspend or resume registration for capability negotiation.
+func (u *User) SetNegotiating(negotiating bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.negotiating = negotiating
+}
@@ -0,0 +1,97 @@
+package user
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+)
+
+var (
+	monitorMutex = new(sync.RWMutex)
+
+	// monitors[uid][lownick] = nick, as given by the watcher
+	monitors = make(map[string]map[string]string)
+
+	// watchers[lownick][uid] = true
+	watchers = make(map[string]map[string]bool)
+)
+
+// AddMonitor adds nick to the MONITOR list of the user.  If the user is
+// already watching limit nicks, the list is not changed and false is
+// returned.
+func AddMonitor(uid, nick string, limit int) bool {
+	monitorMutex.Lock()
+	defer monitorMutex.Unlock()
+
+	lownick := parser.ToLower(nick)
+	if _, ok := monitors[uid][lownick]; ok {
+		return true
+	}
+	if len(monitors[uid]) >= limit {
+		return false
+	}
+
+	if monitors[uid] == nil {
+		monitors[uid] = make(map[string]string)
+	}
+	monitors[uid][lownick] = nick
+	if watchers[lownick] == nil {
+		watchers[lownick] = make(map[string]bool)
+	}
+	watchers[lownick][uid] = true
+	return true
+}
+
+// DelMonitor removes nick from the MONITOR list of the user.
+func DelMonitor(uid, nick string) {
+	monitorMutex.Lock()
+	defer monitorMutex.Unlock()
+	delMonitor(uid, parser.ToLower(nick))
+}
+
+// ClearMonitor empties the MONITOR list of the user.
+func ClearMonitor(uid string) {
+	monitorMutex.Lock()
+	defer monitorMutex.Unlock()
+	for lownick := range monitors[uid] {
+		delMonitor(uid, lownick)
+	}
+}
+
+// Make sure monitorMutex is locked before calling this.
+func delMonitor(uid, lownick string) {
+	delete(monitors[uid], lownick)
+	if len(monitors[uid]) == 0 {
+		delete(monitors, uid)
+	}
+	delete(watchers[lownick], uid)
+	if len(watchers[lownick]) == 0 {
+		delete(watchers, lownick)
+	}
+}
+
+// MonitorList returns the nicks on the MONITOR list of the user in sorted
+// order.
+func MonitorList(uid string) []string {
+	monitorMutex.RLock()
+	defer monitorMutex.RUnlock()
+	nicks := make([]string, 0, len(monitors[uid]))
+	for _, nick := range monitors[uid] {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+	return nicks
+}
+
+// Watchers returns the IDs of the users monitoring nick.
+func Watchers(nick string) []string {
+	monitorMutex.RLock()
+	defer monitorMutex.RUnlock()
+	lownick := parser.ToLower(nick)
+	uids := make([]string, 0, len(watchers[lownick]))
+	for uid := range watchers[lownick] {
+		uids = append(uids, uid)
+	}
+	return uids
+}
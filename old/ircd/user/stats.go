@@ -0,0 +1,71 @@
+package user
+
+var (
+	// The number of registered local and global users, and the most there
+	// have been at once (see counted)
+	local, global       int
+	maxLocal, maxGlobal int
+)
+
+// Stats are the user counts reported by LUSERS.
+type Stats struct {
+	Users     int // registered users on the network
+	Invisible int // registered users who are invisible (+i)
+	Opers     int // registered users who are IRC operators (+o)
+	Unknown   int // connections that have not registered yet
+	Local     int // registered users on this server
+	MaxLocal  int // the most local users there have been at once
+	MaxGlobal int // the most users there have been at once
+}
+
+// Count the users.  This looks at every user, so it is only done for
+// LUSERS; the maximums are kept up to date as users come and go.
+func Count() Stats {
+	userMutex.RLock()
+	defer userMutex.RUnlock()
+	return count()
+}
+
+// Record that a user has registered (n = 1) or that a registered user has
+// gone (n = -1), updating the maximums.  Make sure userMutex is locked.
+func counted(id string, n int) {
+	global += n
+	if id[:3] == UserIDPrefix {
+		local += n
+	}
+	if local > maxLocal {
+		maxLocal = local
+	}
+	if global > maxGlobal {
+		maxGlobal = global
+	}
+}
+
+// Count the users.  Make sure userMutex is locked (and no user mutex is)
+// before calling this.
+func count() (s Stats) {
+	for id, u := range userMap {
+		u.mutex.RLock()
+		registered := u.utyp != Unregistered
+		_, invisible := u.modes.Lookup('i')
+		_, oper := u.modes.Lookup('o')
+		u.mutex.RUnlock()
+
+		if !registered {
+			s.Unknown++
+			continue
+		}
+		s.Users++
+		if invisible {
+			s.Invisible++
+		}
+		if oper {
+			s.Opers++
+		}
+		if id[:3] == UserIDPrefix {
+			s.Local++
+		}
+	}
+	s.MaxLocal, s.MaxGlobal = maxLocal, maxGlobal
+	return
+}
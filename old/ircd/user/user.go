@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/kylelemons/ircd-blight/old/ircd/mode"
 	"github.com/kylelemons/ircd-blight/old/ircd/parser"
 )
 
@@ -62,7 +63,24 @@ type User struct {
 	pass  string
 	nick  string
 	name  string
+	host  string
+	ip    string
 	utyp  userType
+	modes *mode.ActiveModes
+
+	signon  int64 // time of registration
+	active  int64 // time of the last message sent
+	account string
+	away    string          // away message ("" if not away)
+	certfp  string          // TLS client certificate fingerprint
+	snomask string          // the server notices an oper receives (see core.SnoOper and core.DefaultSnomask)
+	privs   map[string]bool // the privileges of a local oper (see core.Privileges)
+	class   string          // the connection class of a local user
+
+	// IRCv3 capabilities of a local client (see caps.go)
+	caps        map[string]bool
+	capVersion  int
+	negotiating bool
 }
 
 // Get the user ID.
@@ -79,16 +97,201 @@ func (u *User) Nick() string {
 	return u.nick
 }
 
-// Get the username (immutable).
+// Get the username.
 func (u *User) User() string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
 	return u.user
 }
 
-// Get the user's long name (immutable).
+// Get the user's long name.
 func (u *User) Name() string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
 	return u.name
 }
 
+// Get the user's visible hostname.
+func (u *User) Host() string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.host
+}
+
+// Get the user's IP address.
+func (u *User) IP() string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.ip
+}
+
+// Get the user's nick!user@host.
+func (u *User) Hostmask() string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.nick + "!" + u.user + "@" + u.host
+}
+
+// Get whether the user has the given user mode (e.g. 'i') set.
+func (u *User) HasMode(r rune) bool {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	_, set := u.modes.Lookup(r)
+	return set
+}
+
+// Get the user's mode string (e.g. "+iw").
+func (u *User) Modes() string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	if modes := u.modes.String(); len(modes) > 0 {
+		return modes
+	}
+	return "+"
+}
+
+// Apply user mode changes.  The changes that were applied are returned.
+func (u *User) ApplyModes(changes []mode.Mode) []mode.Mode {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	applied, _ := u.modes.Apply(changes)
+	return applied
+}
+
+// Get the number of seconds since the user last sent a message.
+func (u *User) Idle() int64 {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return (time.Now().UnixNano() - u.active) / 1e9
+}
+
+// Get the time at which the user registered (in seconds).
+func (u *User) Signon() int64 {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.signon / 1e9
+}
+
+// Reset the user's idle time.
+func (u *User) Touch() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.active = time.Now().UnixNano()
+}
+
+// Get the account the user is logged in to ("" if none).
+func (u *User) Account() string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.account
+}
+
+// Set the account the user is logged in to ("" to log out).
+func (u *User) SetAccount(account string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.account = account
+}
+
+// Get the user's away message ("" if not away).
+func (u *User) Away() string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.away
+}
+
+// Set the user's away message ("" to mark them as back).
+func (u *User) SetAway(message string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.away = message
+}
+
+// Get the fingerprint of the user's TLS client certificate ("" if none).
+func (u *User) CertFP() string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.certfp
+}
+
+// Set the fingerprint of the user's TLS client certificate.
+func (u *User) SetCertFP(fp string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.certfp = fp
+}
+
+// Get the user's server notice mask.
+func (u *User) Snomask() string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.snomask
+}
+
+// Set the user's server notice mask.
+func (u *User) SetSnomask(snomask string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.snomask = snomask
+}
+
+// Get the name of the user's connection class.
+func (u *User) Class() string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.class
+}
+
+// Set the name of the user's connection class.
+func (u *User) SetClass(class string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.class = class
+}
+
+// Check whether the user has the given oper privilege.
+func (u *User) HasPrivilege(priv string) bool {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.privs[priv]
+}
+
+// Set the user's oper privileges, replacing any they had before.
+func (u *User) SetPrivileges(privs []string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.privs = make(map[string]bool, len(privs))
+	for _, priv := range privs {
+		u.privs[priv] = true
+	}
+}
+
+// Set the user's hostname and IP address.
+func (u *User) SetHost(host, ip string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.host, u.ip = host, ip
+}
+
+// Change the username and visible hostname of a registered user.  The IP
+// address is left alone.
+func (u *User) SetIdentHost(ident, host string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.user, u.host = ident, host
+}
+
+// Change the long name of a registered user.
+func (u *User) SetName(name string) error {
+	if len(name) == 0 {
+		return parser.NewNumeric(parser.ERR_NEEDMOREPARAMS, parser.CMD_SETNAME)
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.name = name
+	return nil
+}
+
 // Get the user's registration type (immutable).
 func (u *User) Type() userType {
 	return u.utyp
@@ -133,6 +336,7 @@ func (u *User) SetNick(nick string) error {
 	u.mutex.Lock()
 	defer u.mutex.Unlock()
 
+	u.recordWhoWas()
 	u.nick = nick
 	u.ts = time.Now().UnixNano()
 	return nil
@@ -159,11 +363,29 @@ func (u *User) SetUser(user, name string) error {
 
 // Set the user's type (immutable once set).
 func (u *User) SetType(newType userType) error {
+	// Keep the user counts up to date
+	userMutex.Lock()
+	defer userMutex.Unlock()
+
+	if err := u.setType(newType); err != nil {
+		return err
+	}
+	if _, ok := userMap[u.id]; ok {
+		counted(u.id, 1)
+	}
+	return nil
+}
+
+func (u *User) setType(newType userType) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
 	if u.utyp != Unregistered {
 		return parser.NewNumeric(parser.ERR_ALREADYREGISTRED)
 	}
 	u.utyp = newType
 	u.ts = time.Now().UnixNano()
+	u.signon, u.active = u.ts, u.ts
 	return nil
 }
 
@@ -186,6 +408,15 @@ func GetInfo(id string) (nick, user, name string, regType userType, ok bool) {
 	return
 }
 
+// Get the User structure for the given ID if it exists.
+func Lookup(id string) (u *User, ok bool) {
+	userMutex.RLock()
+	defer userMutex.RUnlock()
+
+	u, ok = userMap[id]
+	return
+}
+
 // Get the ID for a particular nick.
 func GetID(nick string) (id string, err error) {
 	userMutex.RLock()
@@ -219,6 +450,7 @@ func Get(id string) *User {
 		mutex: new(sync.RWMutex),
 		id:    id,
 		nick:  "*",
+		modes: mode.NewActiveModes(mode.UserModes),
 	}
 
 	userMap[id] = u
@@ -235,9 +467,14 @@ func Delete(id string) {
 		u.mutex.RLock()
 		defer u.mutex.RUnlock()
 
+		u.recordWhoWas()
+		if u.utyp != Unregistered {
+			counted(id, -1)
+		}
 		nick := strings.ToLower(u.nick)
 		delete(userNicks, nick)
 		delete(userMap, id)
+		ClearMonitor(id)
 	}
 }
 
@@ -279,7 +516,7 @@ func Netsplit(sids []string) (splitIDs []string) {
 	return
 }
 
-func Import(uid, nick, user, host, ip, hops, ts, name string) error {
+func Import(uid, nick, user, host, ip, hops, ts, umodes, name string) error {
 	userMutex.Lock()
 	defer userMutex.Unlock()
 
@@ -296,16 +533,24 @@ func Import(uid, nick, user, host, ip, hops, ts, name string) error {
 	its, _ := strconv.ParseInt(ts, 10, 64)
 	u := &User{
 		mutex: new(sync.RWMutex),
-		ts:    its,
+		ts:    its * 1e9,
 		id:    uid,
 		user:  user,
 		nick:  nick,
 		name:  name,
+		host:  host,
+		ip:    ip,
 		utyp:  RegisteredAsUser,
+		modes: mode.NewActiveModes(mode.UserModes),
+
+		signon: its * 1e9,
 	}
+	changes, _ := mode.UserModes.ParseModeChange([]string{umodes})
+	u.modes.Apply(changes)
 
 	userMap[uid] = u
 	userNicks[lownick] = uid
+	counted(uid, 1)
 	return nil
 }
 
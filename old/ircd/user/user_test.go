@@ -1,6 +1,7 @@
 package user
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/kylelemons/ircd-blight/old/ircd/parser"
@@ -117,3 +118,71 @@ func BenchmarkGenIDs(b *testing.B) {
 		<-userIDs
 	}
 }
+
+func TestWhoWas(t *testing.T) {
+	Import("000AAAWAS", "WasOne", "was", "was.host", "127.0.0.1", "0", "1", "+", "Who Was")
+	u, _ := Lookup("000AAAWAS")
+	u.SetNick("WasTwo")
+	Delete("000AAAWAS")
+
+	if got, want := len(GetWhoWas("wasone", 0)), 1; got != want {
+		t.Errorf("len(GetWhoWas(wasone)) = %d, want %d", got, want)
+	}
+	entries := GetWhoWas("WASTWO", 1)
+	if got, want := len(entries), 1; got != want {
+		t.Fatalf("len(GetWhoWas(WASTWO)) = %d, want %d", got, want)
+	}
+	if got, want := entries[0].Host, "was.host"; got != want {
+		t.Errorf("host = %q, want %q", got, want)
+	}
+	if got, want := entries[0].SID, "000"; got != want {
+		t.Errorf("sid = %q, want %q", got, want)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	defer ClearMonitor("000AAAMON")
+
+	for _, nick := range []string{"Friend", "FRIEND", "Other"} {
+		if !AddMonitor("000AAAMON", nick, 2) {
+			t.Errorf("AddMonitor(%q) failed unexpectedly", nick)
+		}
+	}
+	if AddMonitor("000AAAMON", "Third", 2) {
+		t.Errorf("AddMonitor past the limit succeeded")
+	}
+	if got, want := strings.Join(MonitorList("000AAAMON"), ","), "Friend,Other"; got != want {
+		t.Errorf("MonitorList = %q, want %q", got, want)
+	}
+	if got, want := len(Watchers("friend")), 1; got != want {
+		t.Errorf("len(Watchers(friend)) = %d, want %d", got, want)
+	}
+
+	DelMonitor("000AAAMON", "fRIEND")
+	if got, want := len(Watchers("friend")), 0; got != want {
+		t.Errorf("after DelMonitor, len(Watchers(friend)) = %d, want %d", got, want)
+	}
+}
+
+func TestCount(t *testing.T) {
+	before := Count()
+
+	local := Get(UserIDPrefix + "CNT001")
+	defer Delete(local.ID())
+	local.SetType(RegisteredAsUser)
+	if err := Import("9ZZCNT002", "counted", "user", "host", "127.0.0.1", "1", "0", "+i", "Name"); err != nil {
+		t.Fatalf("Import: %s", err)
+	}
+	Delete("9ZZCNT002")
+
+	after := Count()
+	if got, want := after.Users, before.Users+1; got != want {
+		t.Errorf("Users = %d, want %d", got, want)
+	}
+	if got, want := after.MaxGlobal, before.Users+2; got < want {
+		t.Errorf("MaxGlobal = %d, want at least %d", got, want)
+	}
+	if got, want := after.MaxLocal, before.Local+1; got < want {
+		t.Errorf("MaxLocal = %d, want at least %d", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+package user
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kylelemons/ircd-blight/old/ircd/parser"
+)
+
+// The number of departed or renamed nicks remembered for WHOWAS.
+const WhoWasSize = 1024
+
+var (
+	// whowas is a ring buffer; whowasNext is the next entry to overwrite.
+	whowasMutex = new(sync.RWMutex)
+	whowas      = make([]WhoWas, WhoWasSize)
+	whowasNext  = 0
+)
+
+// A WhoWas records a nick that has left the network or been changed.
+type WhoWas struct {
+	Nick string
+	User string
+	Host string
+	Name string
+	SID  string // the server the user was on
+	Time int64  // when the nick was given up (in seconds)
+}
+
+// Make sure the user mutex is (r)locked before calling this.
+func (u *User) recordWhoWas() {
+	if u.utyp != RegisteredAsUser {
+		return
+	}
+
+	whowasMutex.Lock()
+	defer whowasMutex.Unlock()
+
+	whowas[whowasNext] = WhoWas{
+		Nick: u.nick,
+		User: u.user,
+		Host: u.host,
+		Name: u.name,
+		SID:  u.id[:3],
+		Time: time.Now().Unix(),
+	}
+	whowasNext = (whowasNext + 1) % len(whowas)
+}
+
+// GetWhoWas returns up to max entries (all of them if max <= 0) recorded
+// for the given nick, newest first.
+func GetWhoWas(nick string, max int) (entries []WhoWas) {
+	whowasMutex.RLock()
+	defer whowasMutex.RUnlock()
+
+	lownick := parser.ToLower(nick)
+	for i := 1; i <= len(whowas); i++ {
+		entry := whowas[(whowasNext-i+len(whowas))%len(whowas)]
+		if len(entry.Nick) == 0 {
+			break
+		}
+		if parser.ToLower(entry.Nick) != lownick {
+			continue
+		}
+		entries = append(entries, entry)
+		if len(entries) == max {
+			break
+		}
+	}
+	return
+}